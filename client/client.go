@@ -0,0 +1,224 @@
+// Package client is a Go client for the ZTP dashboard API. Devices, configs and images use the
+// versioned /api/v1 resources, settings and device types the /api routes of the web UI.
+//
+// Requests are authenticated with an API token, or with a session after calling Login:
+//
+//	c := client.New("https://ztp.example.com", client.WithToken(os.Getenv("ZTP_TOKEN")))
+//	devices, err := c.Devices(ctx)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// Client calls the API of a dashboard. It is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates the requests with an API token created in /api/tokens
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for the requests, e.g. to trust a self signed certificate.
+// Login needs the client to have a cookie jar
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New returns a client for the dashboard at baseURL, e.g. http://ztp.example.com:8080
+func New(baseURL string, options ...Option) *Client {
+	jar, _ := cookiejar.New(nil)
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Jar: jar},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Error is returned when the API answers with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ztp dashboard: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("ztp dashboard: %d %s", e.StatusCode, e.Message)
+}
+
+// statusOf returns the status code of an Error, or 0 for other errors
+func statusOf(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound tells if the object of the request does not exist
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsConflict tells if the name is already in use, or the object is used by devices
+func IsConflict(err error) bool {
+	return statusOf(err) == http.StatusConflict
+}
+
+// IsUnauthorized tells if the credentials are missing or not valid
+func IsUnauthorized(err error) bool {
+	return statusOf(err) == http.StatusUnauthorized
+}
+
+// IsForbidden tells if the role of the user or token does not allow the request
+func IsForbidden(err error) bool {
+	return statusOf(err) == http.StatusForbidden
+}
+
+// IsBadRequest tells if the object sent is not valid
+func IsBadRequest(err error) bool {
+	return statusOf(err) == http.StatusBadRequest
+}
+
+// Login opens a session with a local user. Later requests use the session cookie
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	form := url.Values{"username": {username}, "password": {password}}
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/web/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// The login page redirects to the web UI, the redirect is not needed
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		return &Error{StatusCode: resp.StatusCode, Message: "Invalid username or password"}
+	}
+	return nil
+}
+
+// Me returns the user or token used by the client
+func (c *Client) Me(ctx context.Context) (*model.Principal, error) {
+	principal := &model.Principal{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/users/me", nil, principal)
+	if err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// newRequest builds an authenticated request to the API path
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends the request and decodes the JSON response into out, if not nil
+func (c *Client) do(req *http.Request, out interface{}) error {
+	return c.doWithResponse(req, out, nil)
+}
+
+// doWithResponse is do, calling onSuccess with the response to read its headers
+func (c *Client) doWithResponse(req *http.Request, out interface{}, onSuccess func(*http.Response)) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if onSuccess != nil {
+		onSuccess(resp)
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("ztp dashboard: decode response of %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// doJSON sends in as the JSON body of the request and decodes the response into out
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		js, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+// responseError reads the error of the response. The /api/v1 routes send a model.APIError,
+// the other routes plain text
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &Error{StatusCode: resp.StatusCode}
+
+	var jsonErr model.APIError
+	if json.Unmarshal(body, &jsonErr) == nil && jsonErr.Error.Message != "" {
+		apiErr.Message = jsonErr.Error.Message
+	} else if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// Configs lists the day0 configs
+func (c *Client) Configs(ctx context.Context) ([]model.Config, error) {
+	var configs []model.Config
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/configs", nil, &configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// Config returns the config with the given name
+func (c *Client) Config(ctx context.Context, name string) (*model.Config, error) {
+	config := &model.Config{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/configs/"+url.PathEscape(name), nil, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// CreateConfig creates a config. Its device type is referenced by name
+func (c *Client) CreateConfig(ctx context.Context, config model.Config) (*model.Config, error) {
+	created := &model.Config{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/v1/configs", config, created)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateConfig replaces the config with the given name. Devices using it get the new version
+func (c *Client) UpdateConfig(ctx context.Context, name string, config model.Config) (*model.Config, error) {
+	updated := &model.Config{}
+	err := c.doJSON(ctx, http.MethodPut, "/api/v1/configs/"+url.PathEscape(name), config, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteConfig deletes a config not used by devices
+func (c *Client) DeleteConfig(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/v1/configs/"+url.PathEscape(name), nil, nil)
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// Devices lists the devices
+func (c *Client) Devices(ctx context.Context) ([]model.Device, error) {
+	var devices []model.Device
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/devices", nil, &devices)
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Device returns the device with the given serial, or hostname for devices without serial
+func (c *Client) Device(ctx context.Context, serial string) (*model.Device, error) {
+	device := &model.Device{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/devices/"+url.PathEscape(serial), nil, device)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// DeviceStatus returns the provisioning status of a device
+func (c *Client) DeviceStatus(ctx context.Context, serial string) (string, error) {
+	device, err := c.Device(ctx, serial)
+	if err != nil {
+		return "", err
+	}
+	return device.Status, nil
+}
+
+// CreateDevice creates a device. Its device type, image and config are referenced by name
+func (c *Client) CreateDevice(ctx context.Context, device model.Device) (*model.Device, error) {
+	created := &model.Device{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/v1/devices", device, created)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateDevice replaces the device with the given serial. The status is kept by the server
+func (c *Client) UpdateDevice(ctx context.Context, serial string, device model.Device) (*model.Device, error) {
+	updated := &model.Device{}
+	err := c.doJSON(ctx, http.MethodPut, "/api/v1/devices/"+url.PathEscape(serial), device, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// PatchDevice changes the fields of the device present in fields, e.g. {"scope": "lab"}
+func (c *Client) PatchDevice(ctx context.Context, serial string, fields map[string]interface{}) (*model.Device, error) {
+	updated := &model.Device{}
+	err := c.doJSON(ctx, http.MethodPatch, "/api/v1/devices/"+url.PathEscape(serial), fields, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteDevice deletes the device with the given serial
+func (c *Client) DeleteDevice(ctx context.Context, serial string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/v1/devices/"+url.PathEscape(serial), nil, nil)
+}
+
+// DeviceTypes lists the device types
+func (c *Client) DeviceTypes(ctx context.Context) ([]model.DeviceType, error) {
+	var deviceTypes []model.DeviceType
+	err := c.doJSON(ctx, http.MethodGet, "/api/devices/types", nil, &deviceTypes)
+	if err != nil {
+		return nil, err
+	}
+	return deviceTypes, nil
+}
+
+// DeviceTimeline returns the statuses reached by a device, oldest first
+func (c *Client) DeviceTimeline(ctx context.Context, serial string) ([]model.DeviceStatusChange, error) {
+	var timeline []model.DeviceStatusChange
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/devices/"+url.PathEscape(serial)+"/timeline", nil, &timeline)
+	if err != nil {
+		return nil, err
+	}
+	return timeline, nil
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// reconnectDelay is the wait before reopening a broken event stream
+const reconnectDelay = 2 * time.Second
+
+// EventOptions filters the events of WatchEvents. Empty fields match every event
+type EventOptions struct {
+	// Device is a hostname or serial
+	Device string
+	// Types are event types or prefixes, e.g. "device.status" or "config"
+	Types []string
+}
+
+// WatchEvents calls handler with each event until ctx is cancelled. The stream is reopened after
+// network errors, and the events published meanwhile are not lost if the server still has them.
+// Errors of the API, e.g. missing permissions, are returned
+func (c *Client) WatchEvents(ctx context.Context, options EventOptions, handler func(model.Event)) error {
+	values := url.Values{}
+	if options.Device != "" {
+		values.Set("device", options.Device)
+	}
+	if len(options.Types) > 0 {
+		values.Set("type", strings.Join(options.Types, ","))
+	}
+	path := "/api/events"
+	if len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+
+	var lastID int64
+	for {
+		err := c.streamEvents(ctx, path, &lastID, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if statusOf(err) != 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// streamEvents reads the event stream until it ends, keeping the ID of the last event received
+func (c *Client) streamEvents(ctx context.Context, path string, lastID *int64, handler func(model.Event)) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(*lastID, 10))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	data := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && data != "":
+			// A blank line ends the event
+			var event model.Event
+			if json.Unmarshal([]byte(data), &event) == nil {
+				*lastID = event.ID
+				handler(event)
+			}
+			data = ""
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// Images lists the images
+func (c *Client) Images(ctx context.Context) ([]model.Image, error) {
+	var images []model.Image
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/images", nil, &images)
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Image returns the image with the given name
+func (c *Client) Image(ctx context.Context, name string) (*model.Image, error) {
+	image := &model.Image{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/images/"+url.PathEscape(name), nil, image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// UploadImage creates an image with the content of file. The file is streamed to the server, so
+// large images are not kept in memory. Wrap file to follow the progress of the upload
+func (c *Client) UploadImage(ctx context.Context, name, deviceType string, file io.Reader) (*model.Image, error) {
+	image := &model.Image{}
+	err := c.sendImage(ctx, http.MethodPost, "/api/v1/images", map[string]string{"name": name, "deviceType": deviceType}, name, file, image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// ReplaceImage replaces the file and device type of an image
+func (c *Client) ReplaceImage(ctx context.Context, name, deviceType string, file io.Reader) (*model.Image, error) {
+	image := &model.Image{}
+	err := c.sendImage(ctx, http.MethodPut, "/api/v1/images/"+url.PathEscape(name), map[string]string{"deviceType": deviceType}, name, file, image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// SetImageDeviceType changes the device type of an image not used by devices
+func (c *Client) SetImageDeviceType(ctx context.Context, name, deviceType string) (*model.Image, error) {
+	image := &model.Image{}
+	fields := map[string]interface{}{"deviceType": model.DeviceType{Name: deviceType}}
+	err := c.doJSON(ctx, http.MethodPatch, "/api/v1/images/"+url.PathEscape(name), fields, image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// DeleteImage deletes an image not used by devices
+func (c *Client) DeleteImage(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/v1/images/"+url.PathEscape(name), nil, nil)
+}
+
+// sendImage streams the multipart form with the fields and the file to the server
+func (c *Client) sendImage(ctx context.Context, method, path string, fields map[string]string, fileName string, file io.Reader, out interface{}) error {
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+
+	req, err := c.newRequest(ctx, method, path, pipeReader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	go func() {
+		for key, value := range fields {
+			err := form.WriteField(key, value)
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", fileName)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	err = c.do(req, out)
+	// Stop the writer if the server answered before reading the whole file
+	pipeReader.Close()
+	return err
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// ListOptions filters, sorts and pages the lists of devices, configs and images
+type ListOptions struct {
+	// Filters by query parameter, e.g. {"status": "Provisioned", "hostname": "leaf"}.
+	// Hostnames and names match as prefixes
+	Filters map[string]string
+	// Sort is the field to sort by, e.g. "hostname"
+	Sort string
+	// Descending reverses the sort order
+	Descending bool
+	// Limit is the maximum number of objects returned, 0 for all
+	Limit  int
+	Offset int
+}
+
+// encode returns the query string of the options
+func (o ListOptions) encode() string {
+	values := url.Values{}
+	for key, value := range o.Filters {
+		values.Set(key, value)
+	}
+	if o.Sort != "" {
+		values.Set("sort", o.Sort)
+		if o.Descending {
+			values.Set("order", "desc")
+		}
+	}
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		values.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// list reads a page of a list endpoint into out and returns the total count of the filtered list
+func (c *Client) list(ctx context.Context, path string, options ListOptions, out interface{}) (int, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path+options.encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	err = c.doWithResponse(req, out, func(resp *http.Response) {
+		total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	})
+	return total, err
+}
+
+// FindDevices returns a page of the devices matching the options, and the number of matching devices
+func (c *Client) FindDevices(ctx context.Context, options ListOptions) ([]model.Device, int, error) {
+	var devices []model.Device
+	total, err := c.list(ctx, "/api/v1/devices", options, &devices)
+	if err != nil {
+		return nil, 0, err
+	}
+	return devices, total, nil
+}
+
+// FindConfigs returns a page of the configs matching the options, and the number of matching configs
+func (c *Client) FindConfigs(ctx context.Context, options ListOptions) ([]model.Config, int, error) {
+	var configs []model.Config
+	total, err := c.list(ctx, "/api/v1/configs", options, &configs)
+	if err != nil {
+		return nil, 0, err
+	}
+	return configs, total, nil
+}
+
+// FindImages returns a page of the images matching the options, and the number of matching images
+func (c *Client) FindImages(ctx context.Context, options ListOptions) ([]model.Image, int, error) {
+	var images []model.Image
+	total, err := c.list(ctx, "/api/v1/images", options, &images)
+	if err != nil {
+		return nil, 0, err
+	}
+	return images, total, nil
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// Settings returns the global settings
+func (c *Client) Settings(ctx context.Context) (*model.Settings, error) {
+	settings := &model.Settings{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/settings", nil, settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings replaces the global settings. Channel secrets returned redacted by Settings are
+// kept when saved unchanged
+func (c *Client) UpdateSettings(ctx context.Context, settings model.Settings) error {
+	return c.doJSON(ctx, http.MethodPost, "/api/settings", settings, nil)
+}
+
+// NotificationTypes returns the notification types with their default and current message templates
+func (c *Client) NotificationTypes(ctx context.Context) ([]model.NotificationType, error) {
+	var types []model.NotificationType
+	err := c.doJSON(ctx, http.MethodGet, "/api/settings/notifications", nil, &types)
+	return types, err
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// TestSuites lists the test suites
+func (c *Client) TestSuites(ctx context.Context) ([]model.TestSuite, error) {
+	var suites []model.TestSuite
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/testsuites", nil, &suites)
+	if err != nil {
+		return nil, err
+	}
+	return suites, nil
+}
+
+// TestSuite returns the test suite with the given name
+func (c *Client) TestSuite(ctx context.Context, name string) (*model.TestSuite, error) {
+	suite := &model.TestSuite{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/testsuites/"+url.PathEscape(name), nil, suite)
+	if err != nil {
+		return nil, err
+	}
+	return suite, nil
+}
+
+// CreateTestSuite creates a test suite
+func (c *Client) CreateTestSuite(ctx context.Context, suite model.TestSuite) (*model.TestSuite, error) {
+	created := &model.TestSuite{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/v1/testsuites", suite, created)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateTestSuite replaces the test suite with the given name
+func (c *Client) UpdateTestSuite(ctx context.Context, name string, suite model.TestSuite) (*model.TestSuite, error) {
+	updated := &model.TestSuite{}
+	err := c.doJSON(ctx, http.MethodPut, "/api/v1/testsuites/"+url.PathEscape(name), suite, updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteTestSuite deletes a test suite
+func (c *Client) DeleteTestSuite(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/api/v1/testsuites/"+url.PathEscape(name), nil, nil)
+}
+
+// DeviceTestRuns returns the last test runs of a device, newest first
+func (c *Client) DeviceTestRuns(ctx context.Context, serial string) ([]model.TestRun, error) {
+	var runs []model.TestRun
+	err := c.doJSON(ctx, http.MethodGet, "/api/v1/devices/"+url.PathEscape(serial)+"/tests", nil, &runs)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// RunDeviceTests runs the test suites of a device again, or only the named suite when suite is not
+// empty. The runs are returned when they start, their results are read with DeviceTestRuns
+func (c *Client) RunDeviceTests(ctx context.Context, serial string, suite string) ([]model.TestRun, error) {
+	path := "/api/v1/devices/" + url.PathEscape(serial) + "/tests"
+	if suite != "" {
+		path += "?suite=" + url.QueryEscape(suite)
+	}
+	var runs []model.TestRun
+	err := c.doJSON(ctx, http.MethodPost, path, nil, &runs)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/client"
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// listConfigs prints the configs
+func (c cli) listConfigs(ctx context.Context) error {
+	configs, err := c.client.Configs(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(configs, []string{"NAME", "TYPE", "URL"}, func(add func(columns ...string)) {
+		for _, config := range configs {
+			add(config.Name, config.DeviceType.Name, config.Locationurl)
+		}
+	})
+}
+
+// pushConfigs creates or updates a config for each file. The config name is the file name without
+// its extension
+func (c cli) pushConfigs(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("configs push", flag.ExitOnError)
+	deviceType := flags.String("type", "", "device type (required)")
+	flags.Parse(args)
+	if flags.NArg() == 0 || *deviceType == "" {
+		return errors.New("usage: ztpctl configs push -type <device type> <file>...")
+	}
+
+	pushed := []model.Config{}
+	for _, fileName := range flags.Args() {
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		config := model.Config{
+			Name:          name,
+			DeviceType:    model.DeviceType{Name: *deviceType},
+			Configuration: string(content),
+		}
+		result, err := c.client.UpdateConfig(ctx, name, config)
+		if client.IsNotFound(err) {
+			result, err = c.client.CreateConfig(ctx, config)
+		}
+		if err != nil {
+			return fmt.Errorf("push %s: %v", fileName, err)
+		}
+		pushed = append(pushed, *result)
+	}
+	return c.print(pushed, []string{"NAME", "TYPE", "URL"}, func(add func(columns ...string)) {
+		for _, config := range pushed {
+			add(config.Name, config.DeviceType.Name, config.Locationurl)
+		}
+	})
+}
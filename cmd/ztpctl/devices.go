@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/client"
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// csvColumns are the columns accepted by devices import. The header line of the file gives their order
+var csvColumns = []string{"hostname", "serial", "deviceType", "image", "config", "fixedIp", "scope", "relayCircuitId", "relayRemoteId", "interfaceId"}
+
+// listDevices prints the devices with their status
+func (c cli) listDevices(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("devices list", flag.ExitOnError)
+	options := client.ListOptions{Filters: map[string]string{}}
+	filters := map[string]*string{}
+	for _, filter := range []string{"status", "deviceType", "hostname", "image", "config", "site"} {
+		filters[filter] = flags.String(filter, "", "only list the devices with this "+filter)
+	}
+	flags.StringVar(&options.Sort, "sort", "", "sort by hostname, serial, status, deviceType, image, config, site or fixedIp")
+	flags.BoolVar(&options.Descending, "desc", false, "sort in descending order")
+	flags.IntVar(&options.Limit, "limit", 0, "maximum number of devices listed")
+	flags.IntVar(&options.Offset, "offset", 0, "number of devices skipped")
+	flags.Parse(args)
+	for filter, value := range filters {
+		if *value != "" {
+			options.Filters[filter] = *value
+		}
+	}
+
+	devices, total, err := c.client.FindDevices(ctx, options)
+	if err != nil {
+		return err
+	}
+	if len(devices) < total {
+		fmt.Fprintf(os.Stderr, "Devices %d to %d of %d\n", options.Offset+1, options.Offset+len(devices), total)
+	}
+	return c.print(devices, []string{"HOSTNAME", "SERIAL", "TYPE", "STATUS", "FIXED IP", "SCOPE", "IMAGE", "CONFIG"}, func(add func(columns ...string)) {
+		for _, device := range devices {
+			add(device.Hostname, device.Serial, device.DeviceType.Name, device.Status, device.Fixedip, device.Scope, device.Image.Name, device.Config.Name)
+		}
+	})
+}
+
+// addDevice creates the device described by the flags
+func (c cli) addDevice(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("devices add", flag.ExitOnError)
+	device := model.Device{}
+	flags.StringVar(&device.Hostname, "hostname", "", "hostname (required)")
+	flags.StringVar(&device.Serial, "serial", "", "serial number")
+	flags.StringVar(&device.DeviceType.Name, "type", "", "device type (required)")
+	flags.StringVar(&device.Image.Name, "image", "", "image name (required)")
+	flags.StringVar(&device.Config.Name, "config", "", "config name (required)")
+	flags.StringVar(&device.Fixedip, "fixed-ip", "", "fixed IP, allocated from the scope if empty")
+	flags.StringVar(&device.Scope, "scope", "", "DHCP scope")
+	flags.StringVar(&device.RelayCircuitID, "circuit-id", "", "DHCPv4 relay circuit ID of the switch port")
+	flags.StringVar(&device.RelayRemoteID, "remote-id", "", "DHCPv4 relay remote ID of the switch")
+	flags.StringVar(&device.InterfaceID, "interface-id", "", "DHCPv6 relay interface ID of the switch port")
+	flags.Parse(args)
+
+	created, err := c.client.CreateDevice(ctx, device)
+	if err != nil {
+		return err
+	}
+	return c.printDevice(created)
+}
+
+// printDevice prints one device
+func (c cli) printDevice(device *model.Device) error {
+	return c.print(device, []string{"HOSTNAME", "SERIAL", "STATUS", "FIXED IP"}, func(add func(columns ...string)) {
+		add(device.Hostname, device.Serial, device.Status, device.Fixedip)
+	})
+}
+
+// removeDevices deletes the devices with the given serials or hostnames
+func (c cli) removeDevices(ctx context.Context, serials []string) error {
+	if len(serials) == 0 {
+		return errors.New("usage: ztpctl devices remove <serial>...")
+	}
+	for _, serial := range serials {
+		err := c.client.DeleteDevice(ctx, serial)
+		if err != nil {
+			return fmt.Errorf("remove %s: %v", serial, err)
+		}
+		fmt.Fprintln(os.Stderr, "Removed "+serial)
+	}
+	return nil
+}
+
+// importDevices creates the devices of a CSV file. Every line is tried, and the lines that failed
+// are reported at the end
+func (c cli) importDevices(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ztpctl devices import <file.csv>\nColumns: " + strings.Join(csvColumns, ","))
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %v", err)
+	}
+	for _, column := range header {
+		if !validColumn(column) {
+			return fmt.Errorf("unknown column %s. Columns: %s", column, strings.Join(csvColumns, ","))
+		}
+	}
+
+	type importResult struct {
+		Line     int    `json:"line"`
+		Hostname string `json:"hostname"`
+		Error    string `json:"error,omitempty"`
+	}
+	results := []importResult{}
+	failed := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		device := model.Device{}
+		for i, column := range header {
+			setColumn(&device, column, record[i])
+		}
+		result := importResult{Line: line, Hostname: device.Hostname}
+		_, err = c.client.CreateDevice(ctx, device)
+		if err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	err = c.print(results, []string{"LINE", "HOSTNAME", "RESULT"}, func(add func(columns ...string)) {
+		for _, result := range results {
+			status := "added"
+			if result.Error != "" {
+				status = result.Error
+			}
+			add(fmt.Sprint(result.Line), result.Hostname, status)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d devices not added", failed, len(results))
+	}
+	return nil
+}
+
+// validColumn tells if column is one of csvColumns
+func validColumn(column string) bool {
+	for _, valid := range csvColumns {
+		if column == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// setColumn sets the device field of a CSV column
+func setColumn(device *model.Device, column string, value string) {
+	switch column {
+	case "hostname":
+		device.Hostname = value
+	case "serial":
+		device.Serial = value
+	case "deviceType":
+		device.DeviceType.Name = value
+	case "image":
+		device.Image.Name = value
+	case "config":
+		device.Config.Name = value
+	case "fixedIp":
+		device.Fixedip = value
+	case "scope":
+		device.Scope = value
+	case "relayCircuitId":
+		device.RelayCircuitID = value
+	case "relayRemoteId":
+		device.RelayRemoteID = value
+	case "interfaceId":
+		device.InterfaceID = value
+	}
+}
+
+// deviceTimeline prints the statuses reached by a device
+func (c cli) deviceTimeline(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ztpctl devices timeline <serial>")
+	}
+	timeline, err := c.client.DeviceTimeline(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(timeline, []string{"TIME", "STATUS", "DETAIL"}, func(add func(columns ...string)) {
+		for _, change := range timeline {
+			add(change.Time.Local().Format(time.RFC3339), change.Status, change.Detail)
+		}
+	})
+}
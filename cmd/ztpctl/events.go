@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/client"
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// followEvents prints the live events of the dashboard until interrupted
+func (c cli) followEvents(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	serial := flags.String("serial", "", "only follow the device with this serial or hostname")
+	types := flags.String("type", "", "comma separated event types or prefixes, e.g. device.status,config")
+	flags.Parse(args)
+
+	options := client.EventOptions{Device: *serial}
+	if *types != "" {
+		options.Types = strings.Split(*types, ",")
+	}
+	return c.client.WatchEvents(ctx, options, c.printEvent)
+}
+
+// printEvent prints an event as a line of text, or a JSON line
+func (c cli) printEvent(event model.Event) {
+	if c.output == "json" {
+		json.NewEncoder(os.Stdout).Encode(event)
+		return
+	}
+	subject := event.Object
+	if event.Hostname != "" {
+		subject = event.Hostname
+		if event.Serial != "" {
+			subject += " (" + event.Serial + ")"
+		}
+	}
+	fmt.Printf("%s  %-16s %-30s %-22s %s\n", event.Time.Local().Format("15:04:05"), event.Type, subject, event.Status, event.Message)
+}
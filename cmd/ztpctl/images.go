@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// listImages prints the images
+func (c cli) listImages(ctx context.Context) error {
+	images, err := c.client.Images(ctx)
+	if err != nil {
+		return err
+	}
+	return c.print(images, []string{"NAME", "TYPE", "URL"}, func(add func(columns ...string)) {
+		for _, image := range images {
+			add(image.Name, image.DeviceType.Name, image.Locationurl)
+		}
+	})
+}
+
+// uploadImage uploads an image file, showing the progress on stderr
+func (c cli) uploadImage(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("images upload", flag.ExitOnError)
+	name := flags.String("name", "", "image name, the file name if empty")
+	deviceType := flags.String("type", "", "device type (required)")
+	replace := flags.Bool("replace", false, "replace the file of an existing image")
+	quiet := flags.Bool("q", false, "do not show the progress")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *deviceType == "" {
+		return errors.New("usage: ztpctl images upload -type <device type> [-name <name>] <file>")
+	}
+	if *name == "" {
+		*name = filepath.Base(flags.Arg(0))
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = file
+	if !*quiet {
+		progress := &progressReader{reader: file, total: info.Size()}
+		defer progress.finish()
+		reader = progress
+	}
+
+	var image *model.Image
+	if *replace {
+		image, err = c.client.ReplaceImage(ctx, *name, *deviceType, reader)
+	} else {
+		image, err = c.client.UploadImage(ctx, *name, *deviceType, reader)
+	}
+	if err != nil {
+		return err
+	}
+	return c.print(image, []string{"NAME", "TYPE", "URL"}, func(add func(columns ...string)) {
+		add(image.Name, image.DeviceType.Name, image.Locationurl)
+	})
+}
+
+// progressReader draws a progress bar on stderr while the file is read
+type progressReader struct {
+	reader io.Reader
+	total  int64
+	read   int64
+	drawn  time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.read += int64(n)
+	if time.Since(p.drawn) > 200*time.Millisecond || err == io.EOF {
+		p.draw()
+	}
+	return n, err
+}
+
+// draw prints the progress bar over the previous one
+func (p *progressReader) draw() {
+	p.drawn = time.Now()
+	const width = 40
+	percent := 100
+	if p.total > 0 {
+		percent = int(p.read * 100 / p.total)
+	}
+	done := width * percent / 100
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%% %s / %s", strings.Repeat("=", done), strings.Repeat(" ", width-done), percent, byteSize(p.read), byteSize(p.total))
+}
+
+// finish ends the line of the progress bar
+func (p *progressReader) finish() {
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+// byteSize formats a number of bytes, e.g. 1.2 GB
+func byteSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "kMGTPE"[exp])
+}
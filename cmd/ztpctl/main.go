@@ -0,0 +1,151 @@
+// Command ztpctl manages the devices, configs and images of a ZTP dashboard from the command line.
+//
+// The dashboard URL and API token are read from the -url and -token flags, or the ZTP_URL and
+// ZTP_TOKEN environment variables. Results are printed as a table, or as JSON with -o json.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CiscoSE/ztp-dashboard/client"
+)
+
+const usage = `Usage: ztpctl [flags] <command> [arguments]
+
+Commands:
+  devices list                      List devices with their status
+  devices add [flags]               Add a device
+  devices remove <serial>...        Remove devices
+  devices import <file.csv>         Add the devices of a CSV file
+  devices timeline <serial>         Show the statuses reached by a device
+  images list                       List images
+  images upload [flags] <file>      Upload an image
+  configs list                      List configs
+  configs push [flags] <file>...    Create or update configs from files
+  events [flags]                    Follow device status changes
+
+Flags:
+`
+
+// cli holds the global flags shared by the commands
+type cli struct {
+	client *client.Client
+	output string
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	url := flag.String("url", os.Getenv("ZTP_URL"), "dashboard URL, e.g. https://ztp.example.com (env ZTP_URL)")
+	token := flag.String("token", os.Getenv("ZTP_TOKEN"), "API token (env ZTP_TOKEN)")
+	output := flag.String("o", "table", "output format: table or json")
+	insecure := flag.Bool("insecure", false, "do not verify the TLS certificate of the dashboard")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *url == "" {
+		fatalf("the dashboard URL is required, use -url or ZTP_URL")
+	}
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %s", *output)
+	}
+
+	httpClient := &http.Client{}
+	if *insecure {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	c := cli{
+		client: client.New(*url, client.WithToken(*token), client.WithHTTPClient(httpClient)),
+		output: *output,
+	}
+
+	// Ctrl+C cancels the running request
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	args := flag.Args()
+	// Arguments after the command and subcommand
+	rest := []string{}
+	if len(args) > 2 {
+		rest = args[2:]
+	}
+	var err error
+	switch command(args, 0) + " " + command(args, 1) {
+	case "devices list", "devices ":
+		err = c.listDevices(ctx, rest)
+	case "devices add":
+		err = c.addDevice(ctx, rest)
+	case "devices remove":
+		err = c.removeDevices(ctx, rest)
+	case "devices import":
+		err = c.importDevices(ctx, rest)
+	case "devices timeline":
+		err = c.deviceTimeline(ctx, rest)
+	case "images list", "images ":
+		err = c.listImages(ctx)
+	case "images upload":
+		err = c.uploadImage(ctx, rest)
+	case "configs list", "configs ":
+		err = c.listConfigs(ctx)
+	case "configs push":
+		err = c.pushConfigs(ctx, rest)
+	default:
+		if args[0] == "events" {
+			err = c.followEvents(ctx, args[1:])
+		} else {
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// command returns the argument at index, or an empty string
+func command(args []string, index int) string {
+	if index < len(args) {
+		return args[index]
+	}
+	return ""
+}
+
+// fatalf prints the error and exits
+func fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ztpctl: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+// print writes value as indented JSON, or a table with the header and the rows added by rows
+func (c cli) print(value interface{}, header []string, rows func(add func(columns ...string))) error {
+	if c.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	rows(func(columns ...string) {
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+	})
+	return w.Flush()
+}
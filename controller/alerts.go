@@ -0,0 +1,364 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// alertRefreshInterval is the time between two checks of the device alerts. Firing alerts are also
+// sent again to Alertmanager, which resolves the alerts that are not refreshed
+const alertRefreshInterval = time.Minute
+
+// pagerDutyEventsURL is used for the PagerDuty sinks without URL
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// dhcpApplyAlertKey is the key of the DHCP apply alert, there is one DHCP configuration
+const dhcpApplyAlertKey = model.AlertDHCPApplyFailed
+
+// alertController keeps the firing alerts in the alert collection and sends them to the alert sinks
+type alertController struct {
+	db dbController
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (a alertController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/alerts", a.handleAPIAlerts)
+}
+
+// handleAPIAlerts lists the firing alerts
+func (a alertController) handleAPIAlerts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		session, err := a.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIAlerts (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+		alerts := []model.Alert{}
+		err = session.DB("ztpDashboard").C("alert").Find(nil).Sort("startsat").All(&alerts)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIAlerts (read database)", F("error", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+	}
+}
+
+// deviceAlertKey deduplicates the alerts of a device by serial, or hostname for devices without serial
+func deviceAlertKey(name string, device model.Device) string {
+	return name + "/" + device.ScriptName()
+}
+
+// provisioningFailedAlert is the alert of a device that reported a provisioning failure
+func provisioningFailedAlert(device model.Device, detail string) model.Alert {
+	return model.Alert{
+		Key:         deviceAlertKey(model.AlertProvisioningFailed, device),
+		Name:        model.AlertProvisioningFailed,
+		Severity:    "critical",
+		Hostname:    device.Hostname,
+		Serial:      device.Serial,
+		Summary:     "Provisioning of device " + device.Hostname + " failed",
+		Description: detail,
+	}
+}
+
+// deviceStuckAlert is the alert of a device timed out by the provisioning watchdog, in the status
+// it stayed in for too long
+func deviceStuckAlert(device model.Device, since time.Time) model.Alert {
+	return model.Alert{
+		Key:         deviceAlertKey(model.AlertDeviceStuck, device),
+		Name:        model.AlertDeviceStuck,
+		Severity:    "warning",
+		Hostname:    device.Hostname,
+		Serial:      device.Serial,
+		Summary:     "Device " + device.Hostname + " is stuck in " + device.Status,
+		Description: "No progress since " + since.Format(time.RFC3339),
+	}
+}
+
+// fireAlert stores the alert and sends it to the sinks, unless it is already firing
+func (a alertController) fireAlert(alert model.Alert) {
+	session, err := a.db.OpenSession()
+	if err != nil {
+		Log.Error("fireAlert (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("alert")
+
+	count, err := dbCollection.FindId(alert.Key).Count()
+	if err != nil {
+		Log.Error("fireAlert (read database)", F("error", err))
+		return
+	}
+	if count > 0 {
+		return
+	}
+	alert.StartsAt = time.Now().UTC()
+	err = dbCollection.Insert(&alert)
+	if err != nil {
+		Log.Error("fireAlert (insert alert)", F("alert", alert.Key), F("error", err))
+		return
+	}
+	Log.Warn("fireAlert: Alert firing", F("alert", alert.Key), F("summary", alert.Summary))
+	a.sendAlerts([]model.Alert{alert}, false)
+}
+
+// resolveAlert removes a firing alert and sends its resolution to the sinks
+func (a alertController) resolveAlert(key string) {
+	session, err := a.db.OpenSession()
+	if err != nil {
+		Log.Error("resolveAlert (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	a.resolveAlerts(session.DB("ztpDashboard").C("alert"), bson.M{"_id": key})
+}
+
+// resolveAlerts resolves the firing alerts that match the query
+func (a alertController) resolveAlerts(dbCollection storeCollection, query bson.M) {
+	var alerts []model.Alert
+	err := dbCollection.Find(query).All(&alerts)
+	if err != nil {
+		Log.Error("resolveAlerts (read database)", F("error", err))
+		return
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	for i := range alerts {
+		alerts[i].EndsAt = now
+		err = dbCollection.RemoveId(alerts[i].Key)
+		if err != nil {
+			Log.Error("resolveAlerts (remove alert)", F("alert", alerts[i].Key), F("error", err))
+		}
+		Log.Info("resolveAlerts: Alert resolved", F("alert", alerts[i].Key))
+	}
+	a.sendAlerts(alerts, false)
+}
+
+// run checks the device alerts and refreshes the Alertmanager alerts every alertRefreshInterval
+func (a alertController) run() {
+	ticker := time.NewTicker(alertRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.checkDevices()
+
+		session, err := a.db.OpenSession()
+		if err != nil {
+			Log.Error("alertController (open database)", F("error", err))
+			continue
+		}
+		var alerts []model.Alert
+		err = session.DB("ztpDashboard").C("alert").Find(nil).All(&alerts)
+		session.Close()
+		if err != nil {
+			Log.Error("alertController (read database)", F("error", err))
+			continue
+		}
+		if len(alerts) > 0 {
+			a.sendAlerts(alerts, true)
+		}
+	}
+}
+
+// checkDevices resolves the device alerts that do not apply anymore: the device was removed or left
+// the failed or timed out status. The alerts are fired by the provisioning failures and the
+// provisioning watchdog
+func (a alertController) checkDevices() {
+	session, err := a.db.OpenSession()
+	if err != nil {
+		Log.Error("checkDevices (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	var devices []model.Device
+	err = session.DB("ztpDashboard").C("device").Find(nil).All(&devices)
+	if err != nil {
+		Log.Error("checkDevices (read database)", F("error", err))
+		return
+	}
+	current := make(map[string]bool)
+	for _, device := range devices {
+		switch device.Status {
+		case provisioningFailedStatus:
+			current[deviceAlertKey(model.AlertProvisioningFailed, device)] = true
+		case provisioningTimedOutStatus:
+			current[deviceAlertKey(model.AlertDeviceStuck, device)] = true
+		}
+	}
+
+	var firing []model.Alert
+	dbCollection := session.DB("ztpDashboard").C("alert")
+	err = dbCollection.Find(bson.M{"name": bson.M{"$in": []string{model.AlertProvisioningFailed, model.AlertDeviceStuck}}}).All(&firing)
+	if err != nil {
+		Log.Error("checkDevices (read alerts)", F("error", err))
+		return
+	}
+	for _, alert := range firing {
+		if !current[alert.Key] {
+			a.resolveAlerts(dbCollection, bson.M{"_id": alert.Key})
+		}
+	}
+}
+
+// sendAlerts sends the alerts to the enabled sinks. Refreshes are only sent to Alertmanager
+func (a alertController) sendAlerts(alerts []model.Alert, refresh bool) {
+	settings, err := notificationSettings()
+	if err != nil {
+		Log.Error("sendAlerts (read settings)", F("error", err))
+		return
+	}
+	for _, sink := range settings.AlertSinks {
+		if !sink.Enabled || (refresh && sink.Type != model.AlertSinkAlertmanager) {
+			continue
+		}
+		switch sink.Type {
+		case model.AlertSinkAlertmanager:
+			err = postAlertmanager(sink, alerts)
+			countNotification(sink.Type, err)
+		case model.AlertSinkPagerDuty:
+			for _, alert := range alerts {
+				err = postPagerDuty(sink, alert)
+				countNotification(sink.Type, err)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			Log.Error("sendAlerts (send alerts)", F("sink", sink.Name), F("error", err))
+		}
+	}
+}
+
+// alertmanagerAlert is an alert of the Alertmanager v2 API
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// postAlertmanager posts the alerts to the Alertmanager v2 API. Firing alerts end after a few refresh
+// intervals, so they are resolved by Alertmanager when the dashboard stops
+func postAlertmanager(sink model.AlertSink, alerts []model.Alert) error {
+	payload := make([]alertmanagerAlert, len(alerts))
+	for i, alert := range alerts {
+		endsAt := alert.EndsAt
+		if endsAt.IsZero() {
+			endsAt = time.Now().UTC().Add(4 * alertRefreshInterval)
+		}
+		labels := map[string]string{"alertname": alert.Name, "severity": alert.Severity, "service": "ztp-dashboard"}
+		if alert.Serial != "" {
+			labels["serial"] = alert.Serial
+		}
+		if alert.Hostname != "" {
+			labels["hostname"] = alert.Hostname
+		}
+		payload[i] = alertmanagerAlert{
+			Labels:      labels,
+			Annotations: map[string]string{"summary": alert.Summary, "description": alert.Description},
+			StartsAt:    alert.StartsAt,
+			EndsAt:      endsAt,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postNotification(strings.TrimSuffix(sink.URL, "/")+"/api/v2/alerts", body, nil)
+}
+
+// pagerDutyEvent is an event of the PagerDuty Events v2 API
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+// pagerDutyPayload describes a triggered PagerDuty event
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Component     string            `json:"component,omitempty"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// postPagerDuty triggers or resolves the event of the alert
+func postPagerDuty(sink model.AlertSink, alert model.Alert) error {
+	event := pagerDutyEvent{RoutingKey: sink.RoutingKey, EventAction: "trigger", DedupKey: alert.Key}
+	if !alert.EndsAt.IsZero() {
+		event.EventAction = "resolve"
+	} else {
+		event.Payload = &pagerDutyPayload{
+			Summary:       alert.Summary,
+			Source:        "ztp-dashboard",
+			Severity:      alert.Severity,
+			Timestamp:     alert.StartsAt,
+			Component:     alert.Hostname,
+			Class:         alert.Name,
+			CustomDetails: map[string]string{"serial": alert.Serial, "description": alert.Description},
+		}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	url := sink.URL
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+	return postNotification(url, body, nil)
+}
+
+// validateAlertSinks returns a message for the first invalid sink, or an empty string
+func validateAlertSinks(sinks []model.AlertSink) string {
+	names := make(map[string]bool)
+	for _, sink := range sinks {
+		if sink.Name == "" {
+			return "Alert sinks need a name"
+		}
+		if names[sink.Name] {
+			return "Alert sink " + sink.Name + " is duplicated"
+		}
+		names[sink.Name] = true
+		if sink.URL != "" && !strings.HasPrefix(sink.URL, "http://") && !strings.HasPrefix(sink.URL, "https://") {
+			return "Alert sink " + sink.Name + " needs an http or https URL"
+		}
+		switch sink.Type {
+		case model.AlertSinkAlertmanager:
+			if sink.URL == "" {
+				return "Alert sink " + sink.Name + " needs the Alertmanager URL"
+			}
+		case model.AlertSinkPagerDuty:
+			if sink.RoutingKey == "" {
+				return "Alert sink " + sink.Name + " needs a routing key"
+			}
+		default:
+			return "Alert sink " + sink.Name + " has an unknown type " + sink.Type
+		}
+	}
+	return ""
+}
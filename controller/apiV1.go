@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// apiV1Controller serves the versioned REST resources under /api/v1. Errors are returned with the
+// model.APIError body. The unversioned /api routes are kept for the web UI
+type apiV1Controller struct {
+	db dbController
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (a apiV1Controller) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/v1/devices", a.handleDevices)
+	r.HandleFunc("/api/v1/devices/{serial}", a.handleDevice)
+	r.HandleFunc("/api/v1/devices/{serial}/timeline", a.handleDeviceTimeline)
+	r.HandleFunc("/api/v1/devices/{serial}/tests", a.handleDeviceTests)
+	r.HandleFunc("/api/v1/testsuites", a.handleTestSuites)
+	r.HandleFunc("/api/v1/testsuites/{name}", a.handleTestSuite)
+	r.HandleFunc("/api/v1/configs", a.handleConfigs)
+	r.HandleFunc("/api/v1/configs/{name}", a.handleConfig)
+	r.HandleFunc("/api/v1/images", a.handleImages)
+	r.HandleFunc("/api/v1/images/{name}", a.handleImage)
+	// Unknown resources get a JSON error instead of the redirect to the web UI
+	r.PathPrefix("/api/v1/").HandlerFunc(a.handleNotFound)
+}
+
+// writeJSON encodes value as the JSON body of the response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAPIError sends the JSON error body with the status code
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, model.APIError{Error: model.APIErrorDetail{Status: status, Message: message}})
+}
+
+// writeMethodNotAllowed sends a 405 error with the methods the resource supports
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed. Allowed methods: "+strings.Join(allowed, ", "))
+}
+
+// writeDatabaseError logs the error and sends a 404 if the object was not found, a 500 otherwise
+func writeDatabaseError(w http.ResponseWriter, context string, err error, notFoundMessage string) {
+	if err == mgo.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, notFoundMessage)
+		return
+	}
+	Log.Error(context, F("error", err))
+	writeAPIError(w, http.StatusInternalServerError, "Database error")
+}
+
+// handleNotFound answers requests to unknown /api/v1 resources
+func (a apiV1Controller) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "Resource "+r.URL.Path+" not found")
+}
+
+// decodeJSON decodes the request body into value. A 400 error is sent if the body is not valid
+func decodeJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// validFileName tells if name can be used as the name of a file served to devices
+func validFileName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\")
+}
+
+// findDeviceType returns the device type with the given name
+func findDeviceType(session storeSession, name string) (*model.DeviceType, error) {
+	var deviceType model.DeviceType
+	err := session.DB("ztpDashboard").C("deviceType").Find(bson.M{"name": name}).One(&deviceType)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceType, nil
+}
+
+// inUseByDevices returns the hostnames of the devices that match the query
+func inUseByDevices(session storeSession, query bson.M) ([]string, error) {
+	var devices []model.Device
+	err := session.DB("ztpDashboard").C("device").Find(query).Select(bson.M{"hostname": 1}).All(&devices)
+	if err != nil {
+		return nil, err
+	}
+	hostnames := []string{}
+	for _, device := range devices {
+		hostnames = append(hostnames, device.Hostname)
+	}
+	return hostnames, nil
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// saveConfig validates the device type of the config and writes the config file served to devices.
+// An error message is returned if the config is not valid
+func (a apiV1Controller) saveConfig(session storeSession, config *model.Config) (string, error) {
+	if config.Name == "" || config.Configuration == "" {
+		return "Name and configuration are required", nil
+	}
+	if !validFileName(config.Name) {
+		return "Name cannot contain / or start with .", nil
+	}
+	deviceType, err := findDeviceType(session, config.DeviceType.Name)
+	if err == mgo.ErrNotFound {
+		return "Device type " + config.DeviceType.Name + " not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	config.DeviceType = *deviceType
+	config.Locationurl = "/configs/" + config.Name + ".conf"
+	return "", ioutil.WriteFile(basePath+"/public/configs/"+config.Name+".conf", []byte(config.Configuration), 0644)
+}
+
+// handleConfigs lists (GET) and creates (POST) configs
+func (a apiV1Controller) handleConfigs(w http.ResponseWriter, r *http.Request) {
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleConfigs (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("config")
+
+	switch r.Method {
+	case http.MethodGet:
+		query, err := parseListQuery(r, configListFields)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var configs []model.Config
+		total, err := query.run(dbCollection, &configs)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfigs (read database)", err, "")
+			return
+		}
+		if configs == nil {
+			configs = []model.Config{}
+		}
+		setListHeaders(w, total)
+		writeJSON(w, http.StatusOK, configs)
+
+	case http.MethodPost:
+		config := &model.Config{}
+		if !decodeJSON(w, r, config) {
+			return
+		}
+		count, err := dbCollection.Find(bson.M{"name": config.Name}).Count()
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfigs (read database)", err, "")
+			return
+		}
+		if count > 0 {
+			writeAPIError(w, http.StatusConflict, "Configuration name "+config.Name+" already in use")
+			return
+		}
+		message, err := a.saveConfig(session, config)
+		if err != nil {
+			requestLog(r).Error("apiV1 handleConfigs (save config)", F("error", err))
+			writeAPIError(w, http.StatusInternalServerError, "Cannot save config")
+			return
+		}
+		if message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		err = dbCollection.Insert(config)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfigs (insert database)", err, "")
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "config", config.Name, nil, config)
+
+		w.Header().Set("Location", "/api/v1/configs/"+config.Name)
+		writeJSON(w, http.StatusCreated, config)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleConfig reads (GET), replaces (PUT), partially updates (PATCH) and deletes (DELETE) a config.
+// Devices using the config get the new version, and configs in use cannot be deleted
+func (a apiV1Controller) handleConfig(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleConfig (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("config")
+
+	var current model.Config
+	err = dbCollection.Find(bson.M{"name": name}).One(&current)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleConfig (read database)", err, "Config "+name+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, current)
+
+	case http.MethodPut, http.MethodPatch:
+		config := model.Config{}
+		if r.Method == http.MethodPatch {
+			config = current
+		}
+		if !decodeJSON(w, r, &config) {
+			return
+		}
+		if config.Name == "" {
+			config.Name = current.Name
+		}
+		if config.Name != current.Name {
+			writeAPIError(w, http.StatusBadRequest, "Name cannot be changed")
+			return
+		}
+		// Devices already use the config, so the device type is kept
+		if config.DeviceType.Name != current.DeviceType.Name {
+			hostnames, err := inUseByDevices(session, bson.M{"config.name": name})
+			if err != nil {
+				writeDatabaseError(w, "apiV1 handleConfig (read database)", err, "")
+				return
+			}
+			if len(hostnames) > 0 {
+				writeAPIError(w, http.StatusConflict, "Config "+name+" is used by devices and its device type cannot be changed")
+				return
+			}
+		}
+		message, err := a.saveConfig(session, &config)
+		if err != nil {
+			requestLog(r).Error("apiV1 handleConfig (save config)", F("error", err))
+			writeAPIError(w, http.StatusInternalServerError, "Cannot save config")
+			return
+		}
+		if message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		err = dbCollection.Update(bson.M{"name": name}, &config)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfig (update database)", err, "Config "+name+" not found")
+			return
+		}
+		// Devices keep a copy of their config
+		_, err = session.DB("ztpDashboard").C("device").UpdateAll(bson.M{"config.name": name}, bson.M{"$set": bson.M{"config": config}})
+		if err != nil {
+			requestLog(r).Error("apiV1 handleConfig (update devices)", F("error", err))
+		}
+		auditCtl.Record(r, model.AuditUpdate, "config", name, current, config)
+		go dhcpController.GenerateConfigFiles()
+
+		writeJSON(w, http.StatusOK, config)
+
+	case http.MethodDelete:
+		hostnames, err := inUseByDevices(session, bson.M{"config.name": name})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfig (read database)", err, "")
+			return
+		}
+		if len(hostnames) > 0 {
+			writeAPIError(w, http.StatusConflict, "Config "+name+" is used by "+strings.Join(hostnames, ", "))
+			return
+		}
+		err = dbCollection.Remove(bson.M{"name": name})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleConfig (delete database)", err, "Config "+name+" not found")
+			return
+		}
+		err = os.Remove(basePath + "/public/configs/" + name + ".conf")
+		if err != nil && !os.IsNotExist(err) {
+			requestLog(r).Error("apiV1 handleConfig (remove config file)", F("error", err))
+		}
+		auditCtl.Record(r, model.AuditDelete, "config", name, current, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// findDevice returns the device with the given serial. Devices bound to a switch port might not have
+// a serial yet, so the hostname is also accepted
+func (a apiV1Controller) findDevice(session storeSession, serial string) (*model.Device, error) {
+	var device model.Device
+	dbCollection := session.DB("ztpDashboard").C("device")
+	err := dbCollection.Find(bson.M{"serial": serial}).One(&device)
+	if err == mgo.ErrNotFound {
+		err = dbCollection.Find(bson.M{"hostname": serial}).One(&device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// resolveDeviceReferences checks the device type, image and config of the device and loads the image
+// and config stored in database, so the client only needs to send their names. An error
+// message is returned if a reference is not valid
+func (a apiV1Controller) resolveDeviceReferences(session storeSession, device *model.Device) (string, error) {
+	if device.Hostname == "" {
+		return "Hostname is required", nil
+	}
+	deviceType, err := findDeviceType(session, device.DeviceType.Name)
+	if err == mgo.ErrNotFound {
+		return "Device type " + device.DeviceType.Name + " not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	device.DeviceType = *deviceType
+
+	var image model.Image
+	err = session.DB("ztpDashboard").C("image").Find(bson.M{"name": device.Image.Name, "devicetype.name": deviceType.Name}).One(&image)
+	if err == mgo.ErrNotFound {
+		return "Image " + device.Image.Name + " not found for device type " + deviceType.Name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	device.Image = image
+
+	var config model.Config
+	err = session.DB("ztpDashboard").C("config").Find(bson.M{"name": device.Config.Name, "devicetype.name": deviceType.Name}).One(&config)
+	if err == mgo.ErrNotFound {
+		return "Config " + device.Config.Name + " not found for device type " + deviceType.Name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	device.Config = config
+	return "", nil
+}
+
+// handleDevices lists (GET) and creates (POST) devices
+func (a apiV1Controller) handleDevices(w http.ResponseWriter, r *http.Request) {
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleDevices (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+
+	switch r.Method {
+	case http.MethodGet:
+		query, err := parseListQuery(r, deviceListFields)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var devices []model.Device
+		total, err := query.run(session.DB("ztpDashboard").C("device"), &devices)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDevices (read database)", err, "")
+			return
+		}
+		if devices == nil {
+			devices = []model.Device{}
+		}
+		setListHeaders(w, total)
+		writeJSON(w, http.StatusOK, devices)
+
+	case http.MethodPost:
+		device := &model.Device{}
+		if !decodeJSON(w, r, device) {
+			return
+		}
+		message, err := a.resolveDeviceReferences(session, device)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDevices (read database)", err, "")
+			return
+		}
+		if message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		device.Status = "Configured"
+
+		status, err := deviceCtl.addDevice(session, device)
+		if err != nil {
+			writeAPIError(w, status, err.Error())
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "device", device.Hostname, nil, device)
+
+		w.Header().Set("Location", "/api/v1/devices/"+device.ScriptName())
+		writeJSON(w, http.StatusCreated, device)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleDevice reads (GET), replaces (PUT), partially updates (PATCH) and deletes (DELETE) a device
+func (a apiV1Controller) handleDevice(w http.ResponseWriter, r *http.Request) {
+	serial := mux.Vars(r)["serial"]
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleDevice (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+
+	current, err := a.findDevice(session, serial)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleDevice (read database)", err, "Device "+serial+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		change, err := lastStatusChange(session, current.Hostname)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDevice (read timeline)", err, "")
+			return
+		}
+		if change != nil {
+			current.StatusSince = &change.Time
+			current.TimeInStatus = int64(time.Since(change.Time).Seconds())
+		}
+		writeJSON(w, http.StatusOK, current)
+
+	case http.MethodPut, http.MethodPatch:
+		// PUT replaces the device, PATCH only changes the fields present in the body.
+		// The status is kept since it is managed by the provisioning process
+		device := model.Device{}
+		if r.Method == http.MethodPatch {
+			device = *current
+		}
+		if !decodeJSON(w, r, &device) {
+			return
+		}
+		if device.Hostname == "" {
+			device.Hostname = current.Hostname
+		}
+		device.Status = current.Status
+
+		message, err := a.resolveDeviceReferences(session, &device)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDevice (read database)", err, "")
+			return
+		}
+		if message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		status, err := deviceCtl.updateDevice(session, *current, &device)
+		if err != nil {
+			writeAPIError(w, status, err.Error())
+			return
+		}
+		auditCtl.Record(r, model.AuditUpdate, "device", device.Hostname, current, device)
+		writeJSON(w, http.StatusOK, device)
+
+	case http.MethodDelete:
+		err = session.DB("ztpDashboard").C("device").Remove(bson.M{"hostname": current.Hostname})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDevice (delete database)", err, "Device "+serial+" not found")
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "device", current.Hostname, current, nil)
+
+		// Regenerate dhcp and scripts. The fixed IP of the device is free again
+		go dhcpController.GenerateConfigFiles()
+
+		// Send notification
+		Notify(Notification{Type: model.NotifyDeviceDelete, Hostname: current.Hostname, Serial: current.Serial})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDeviceTimeline returns the statuses reached by a device, oldest first
+func (a apiV1Controller) handleDeviceTimeline(w http.ResponseWriter, r *http.Request) {
+	serial := mux.Vars(r)["serial"]
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleDeviceTimeline (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+
+	device, err := a.findDevice(session, serial)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleDeviceTimeline (read database)", err, "Device "+serial+" not found")
+		return
+	}
+	var timeline []model.DeviceStatusChange
+	err = session.DB("ztpDashboard").C("deviceStatus").Find(bson.M{"hostname": device.Hostname}).Sort("time").All(&timeline)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleDeviceTimeline (read database)", err, "")
+		return
+	}
+	if timeline == nil {
+		timeline = []model.DeviceStatusChange{}
+	}
+	writeJSON(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// saveImageFile stores the image file of the multipart request. It returns false if the error
+// response has already been sent
+func (a apiV1Controller) saveImageFile(w http.ResponseWriter, r *http.Request, imageName string) bool {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Image file is required: "+err.Error())
+		return false
+	}
+	defer file.Close()
+	out, err := os.Create(basePath + "/public/images/" + imageName)
+	if err != nil {
+		requestLog(r).Error("apiV1 saveImageFile (create image file)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Cannot save image file")
+		return false
+	}
+	defer out.Close()
+	_, err = io.Copy(out, file)
+	if err != nil {
+		requestLog(r).Error("apiV1 saveImageFile (write image file)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Cannot save image file")
+		return false
+	}
+	return true
+}
+
+// handleImages lists (GET) and uploads (POST, multipart form with name, deviceType and file) images
+func (a apiV1Controller) handleImages(w http.ResponseWriter, r *http.Request) {
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleImages (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("image")
+
+	switch r.Method {
+	case http.MethodGet:
+		query, err := parseListQuery(r, imageListFields)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var images []model.Image
+		total, err := query.run(dbCollection, &images)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImages (read database)", err, "")
+			return
+		}
+		if images == nil {
+			images = []model.Image{}
+		}
+		setListHeaders(w, total)
+		writeJSON(w, http.StatusOK, images)
+
+	case http.MethodPost:
+		imageName := r.FormValue("name")
+		if !validFileName(imageName) {
+			writeAPIError(w, http.StatusBadRequest, "A valid image name is required")
+			return
+		}
+		deviceType, err := findDeviceType(session, r.FormValue("deviceType"))
+		if err == mgo.ErrNotFound {
+			writeAPIError(w, http.StatusBadRequest, "Device type "+r.FormValue("deviceType")+" not found")
+			return
+		}
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImages (read database)", err, "")
+			return
+		}
+		count, err := dbCollection.Find(bson.M{"name": imageName}).Count()
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImages (read database)", err, "")
+			return
+		}
+		if count > 0 {
+			writeAPIError(w, http.StatusConflict, "Image name "+imageName+" already in use")
+			return
+		}
+		if !a.saveImageFile(w, r, imageName) {
+			return
+		}
+		image := &model.Image{
+			Name:        imageName,
+			DeviceType:  *deviceType,
+			Locationurl: "/images/" + imageName,
+		}
+		err = dbCollection.Insert(image)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImages (insert database)", err, "")
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "image", image.Name, nil, image)
+		Notify(Notification{Type: model.NotifyImageCreate, Object: image.Name, Detail: image.DeviceType.Name})
+
+		w.Header().Set("Location", "/api/v1/images/"+image.Name)
+		writeJSON(w, http.StatusCreated, image)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleImage reads (GET), replaces (PUT, multipart form with deviceType and file), changes the device
+// type (PATCH, JSON) and deletes (DELETE) an image. Images in use cannot be deleted
+func (a apiV1Controller) handleImage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleImage (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("image")
+
+	var current model.Image
+	err = dbCollection.Find(bson.M{"name": name}).One(&current)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleImage (read database)", err, "Image "+name+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, current)
+
+	case http.MethodPut, http.MethodPatch:
+		image := current
+		if r.Method == http.MethodPut {
+			image.DeviceType = model.DeviceType{Name: r.FormValue("deviceType")}
+		} else if !decodeJSON(w, r, &image) {
+			return
+		}
+		if image.Name != current.Name {
+			writeAPIError(w, http.StatusBadRequest, "Name cannot be changed")
+			return
+		}
+		deviceType, err := findDeviceType(session, image.DeviceType.Name)
+		if err == mgo.ErrNotFound {
+			writeAPIError(w, http.StatusBadRequest, "Device type "+image.DeviceType.Name+" not found")
+			return
+		}
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImage (read database)", err, "")
+			return
+		}
+		image.DeviceType = *deviceType
+		image.Locationurl = current.Locationurl
+		if image.DeviceType.Name != current.DeviceType.Name {
+			hostnames, err := inUseByDevices(session, bson.M{"image.name": name})
+			if err != nil {
+				writeDatabaseError(w, "apiV1 handleImage (read database)", err, "")
+				return
+			}
+			if len(hostnames) > 0 {
+				writeAPIError(w, http.StatusConflict, "Image "+name+" is used by devices and its device type cannot be changed")
+				return
+			}
+		}
+		if r.Method == http.MethodPut && !a.saveImageFile(w, r, name) {
+			return
+		}
+		err = dbCollection.Update(bson.M{"name": name}, &image)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImage (update database)", err, "Image "+name+" not found")
+			return
+		}
+		auditCtl.Record(r, model.AuditUpdate, "image", name, current, image)
+
+		writeJSON(w, http.StatusOK, image)
+
+	case http.MethodDelete:
+		hostnames, err := inUseByDevices(session, bson.M{"image.name": name})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImage (read database)", err, "")
+			return
+		}
+		if len(hostnames) > 0 {
+			writeAPIError(w, http.StatusConflict, "Image "+name+" is used by "+strings.Join(hostnames, ", "))
+			return
+		}
+		err = dbCollection.Remove(bson.M{"name": name})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleImage (delete database)", err, "Image "+name+" not found")
+			return
+		}
+		err = os.Remove(basePath + "/public/images/" + name)
+		if err != nil && !os.IsNotExist(err) {
+			requestLog(r).Error("apiV1 handleImage (remove image file)", F("error", err))
+		}
+		auditCtl.Record(r, model.AuditDelete, "image", name, current, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
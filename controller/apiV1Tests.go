@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// defaultTestRunLimit is the number of runs returned when the request does not give a limit
+const defaultTestRunLimit = 50
+
+// handleTestSuites lists and creates test suites
+func (a apiV1Controller) handleTestSuites(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleTestSuites (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("testSuite")
+
+	switch r.Method {
+	case http.MethodGet:
+		suites := []model.TestSuite{}
+		err = dbCollection.Find(nil).Sort("name").All(&suites)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleTestSuites (read database)", err, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, suites)
+
+	case http.MethodPost:
+		var suite model.TestSuite
+		if !decodeJSON(w, r, &suite) {
+			return
+		}
+		if message := validateTestSuite(suite); message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		count, err := dbCollection.Find(bson.M{"name": suite.Name}).Count()
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleTestSuites (read database)", err, "")
+			return
+		}
+		if count > 0 {
+			writeAPIError(w, http.StatusConflict, "Suite "+suite.Name+" already exists")
+			return
+		}
+		err = dbCollection.Insert(&suite)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleTestSuites (insert database)", err, "")
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "testSuite", suite.Name, nil, suite)
+		writeJSON(w, http.StatusCreated, suite)
+	}
+}
+
+// handleTestSuite gets, replaces and deletes a test suite
+func (a apiV1Controller) handleTestSuite(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleTestSuite (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("testSuite")
+
+	var current model.TestSuite
+	err = dbCollection.Find(bson.M{"name": name}).One(&current)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleTestSuite (read database)", err, "Suite "+name+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, current)
+
+	case http.MethodPut:
+		var suite model.TestSuite
+		if !decodeJSON(w, r, &suite) {
+			return
+		}
+		if suite.Name == "" {
+			suite.Name = current.Name
+		}
+		if suite.Name != current.Name {
+			writeAPIError(w, http.StatusBadRequest, "Name cannot be changed")
+			return
+		}
+		if message := validateTestSuite(suite); message != "" {
+			writeAPIError(w, http.StatusBadRequest, message)
+			return
+		}
+		err = dbCollection.Update(bson.M{"name": name}, &suite)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleTestSuite (update database)", err, "Suite "+name+" not found")
+			return
+		}
+		auditCtl.Record(r, model.AuditUpdate, "testSuite", name, current, suite)
+		writeJSON(w, http.StatusOK, suite)
+
+	case http.MethodDelete:
+		err = dbCollection.Remove(bson.M{"name": name})
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleTestSuite (delete database)", err, "Suite "+name+" not found")
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "testSuite", name, current, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDeviceTests lists the test runs of a device, newest first, and runs its suites again
+func (a apiV1Controller) handleDeviceTests(w http.ResponseWriter, r *http.Request) {
+	serial := mux.Vars(r)["serial"]
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("apiV1 handleDeviceTests (open database)", F("error", err))
+		writeAPIError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer session.Close()
+
+	device, err := a.findDevice(session, serial)
+	if err != nil {
+		writeDatabaseError(w, "apiV1 handleDeviceTests (read database)", err, "Device "+serial+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := defaultTestRunLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "Invalid limit "+value)
+				return
+			}
+		}
+		runs := []model.TestRun{}
+		err = session.DB("ztpDashboard").C("testRun").Find(bson.M{"hostname": device.Hostname}).Sort("-started").Limit(limit).All(&runs)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDeviceTests (read database)", err, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, runs)
+
+	case http.MethodPost:
+		suiteName := r.URL.Query().Get("suite")
+		runs, suites, err := testController.startRuns(*device, suiteName, testTriggerAPI)
+		if err != nil {
+			writeDatabaseError(w, "apiV1 handleDeviceTests (start runs)", err, "")
+			return
+		}
+		if len(runs) == 0 {
+			writeAPIError(w, http.StatusNotFound, "Suite "+suiteName+" not found")
+			return
+		}
+		requestLog(r).Info("handleDeviceTests: Running the test suites", deviceFields(device.Hostname, device.Serial)...)
+		go testController.finishRuns(*device, runs, suites)
+		writeJSON(w, http.StatusAccepted, runs)
+	}
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// auditController keeps the audit trail of the changes made through the API
+type auditController struct {
+	db dbController
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (a auditController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/audit", a.handleAPIAudit)
+}
+
+// Record appends an entry to the audit trail. before is nil for creations and after is nil for
+// deletions. Errors are logged, the change has already been done when this is called
+func (a auditController) Record(r *http.Request, action string, objectType string, objectName string, before interface{}, after interface{}) {
+	entry := model.AuditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      "unknown",
+		SourceIP:   r.RemoteAddr,
+		Action:     action,
+		ObjectType: objectType,
+		ObjectName: objectName,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.SourceIP = host
+	}
+	if principal := RequestPrincipal(r); principal != nil {
+		entry.Actor = principal.Name
+		if principal.Token {
+			entry.Actor = "token:" + principal.Name
+		}
+	}
+	var err error
+	entry.Before, err = auditObject(before)
+	if err != nil {
+		requestLog(r).Error("auditController.Record (encode before)", F("error", err))
+	}
+	entry.After, err = auditObject(after)
+	if err != nil {
+		requestLog(r).Error("auditController.Record (encode after)", F("error", err))
+	}
+	entry.Changes = auditDiff(entry.Before, entry.After)
+	a.publish(entry)
+
+	session, err := a.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("auditController.Record (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	err = session.DB("ztpDashboard").C("audit").Insert(&entry)
+	if err != nil {
+		requestLog(r).Error("auditController.Record (insert database)", F("error", err))
+	}
+}
+
+// publish sends the change to the event bus, e.g. as a "config.update" event
+func (a auditController) publish(entry model.AuditEntry) {
+	event := model.Event{
+		Time:    entry.Time,
+		Type:    entry.ObjectType + "." + entry.Action,
+		Object:  entry.ObjectName,
+		Message: "Changed by " + entry.Actor,
+	}
+	if entry.ObjectType == "device" {
+		event.Hostname = entry.ObjectName
+		object := entry.After
+		if object == nil {
+			object = entry.Before
+		}
+		event.Serial, _ = object["serial"].(string)
+		event.Status, _ = object["status"].(string)
+	}
+	PublishEvent(event)
+}
+
+// auditObject converts an object to the map returned by the API, so fields hidden from the API
+// (password hashes, tokens) are not stored in the audit trail
+func auditObject(object interface{}) (map[string]interface{}, error) {
+	if object == nil || reflect.ValueOf(object).Kind() == reflect.Ptr && reflect.ValueOf(object).IsNil() {
+		return nil, nil
+	}
+	js, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(js, &result)
+	return result, err
+}
+
+// auditDiff returns the fields that differ between before and after. Nested objects are compared
+// field by field, lists are compared as a whole
+func auditDiff(before map[string]interface{}, after map[string]interface{}) []model.AuditChange {
+	flatBefore := make(map[string]interface{})
+	flattenAuditObject("", before, flatBefore)
+	flatAfter := make(map[string]interface{})
+	flattenAuditObject("", after, flatAfter)
+
+	fields := make(map[string]bool)
+	for field := range flatBefore {
+		fields[field] = true
+	}
+	for field := range flatAfter {
+		fields[field] = true
+	}
+	changes := []model.AuditChange{}
+	for field := range fields {
+		if !reflect.DeepEqual(flatBefore[field], flatAfter[field]) {
+			changes = append(changes, model.AuditChange{Field: field, Before: flatBefore[field], After: flatAfter[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flattenAuditObject stores the leaves of object in result, keyed by their dotted path
+func flattenAuditObject(prefix string, object map[string]interface{}, result map[string]interface{}) {
+	for key, value := range object {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenAuditObject(prefix+key+".", nested, result)
+			continue
+		}
+		result[prefix+key] = value
+	}
+}
+
+// handleAPIAudit returns the audit entries, oldest first. They can be filtered by objectType,
+// object (name), actor, from and to (RFC 3339). With format=jsonl one entry per line is returned
+func (a auditController) handleAPIAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := bson.M{}
+	if objectType := r.URL.Query().Get("objectType"); objectType != "" {
+		query["objecttype"] = objectType
+	}
+	if object := r.URL.Query().Get("object"); object != "" {
+		query["objectname"] = object
+	}
+	if actor := r.URL.Query().Get("actor"); actor != "" {
+		query["actor"] = actor
+	}
+	timeRange := bson.M{}
+	for parameter, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := r.URL.Query().Get(parameter)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid " + parameter + " time, RFC 3339 expected: " + err.Error()))
+			return
+		}
+		timeRange[operator] = t
+	}
+	if len(timeRange) > 0 {
+		query["time"] = timeRange
+	}
+
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPIAudit (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	var entries []model.AuditEntry
+	err = session.DB("ztpDashboard").C("audit").Find(query).Sort("time").All(&entries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPIAudit (read database)", F("error", err))
+		return
+	}
+	if entries == nil {
+		entries = []model.AuditEntry{}
+	}
+
+	enc := json.NewEncoder(w)
+	if r.URL.Query().Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=audit.jsonl")
+		for _, entry := range entries {
+			enc.Encode(entry)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(entries)
+}
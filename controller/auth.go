@@ -0,0 +1,669 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Name of the cookie that holds the web UI session
+const sessionCookieName = "ztp_session"
+
+// Time a web UI session is valid after login
+const sessionDuration = 12 * time.Hour
+
+// principalKey is the request context key of the authenticated principal
+type principalKey struct{}
+
+// authController manages the local users, the web UI sessions and the API tokens
+type authController struct {
+	loginTemplate string
+	db            dbController
+}
+
+// routePermission is the role needed to read (GET) and to modify (other methods) the paths
+// starting with prefix
+type routePermission struct {
+	prefix    string
+	readRole  string
+	writeRole string
+}
+
+// publicPaths do not need authentication. The login page needs the assets
+var publicPaths = []string{"/web/login", "/web/logout", "/assets/", "/api/openapi.json", "/healthz", "/readyz", webexWebhookPath}
+
+// routePermissions are checked in order, the first prefix that matches is used.
+// Paths that do not match any prefix need a read only user
+var routePermissions = []routePermission{
+	{prefix: "/api/users/me", readRole: model.ReadOnlyRole, writeRole: model.ReadOnlyRole},
+	{prefix: "/api/users", readRole: model.AdminRole, writeRole: model.AdminRole},
+	{prefix: "/api/tokens", readRole: model.AdminRole, writeRole: model.AdminRole},
+	{prefix: "/api/audit", readRole: model.AdminRole, writeRole: model.AdminRole},
+	{prefix: "/api/settings", readRole: model.ReadOnlyRole, writeRole: model.AdminRole},
+	{prefix: "/api/scopes", readRole: model.ReadOnlyRole, writeRole: model.AdminRole},
+	{prefix: "/api/", readRole: model.ReadOnlyRole, writeRole: model.OperatorRole},
+}
+
+// roleLevels orders the roles, a role is allowed everything the roles below it are
+var roleLevels = map[string]int{
+	model.ReadOnlyRole: 1,
+	model.OperatorRole: 2,
+	model.AdminRole:    3,
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (a authController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/web/login", a.handleLogin)
+	r.HandleFunc("/web/logout", a.handleLogout)
+	r.HandleFunc("/api/users", a.handleAPIUsers)
+	r.HandleFunc("/api/users/me", a.handleAPIUsersMe)
+	r.HandleFunc("/api/tokens", a.handleAPITokens)
+}
+
+// AuthHandler authenticates every request with the session cookie or the bearer token and checks
+// the role of the principal against routePermissions. The paths used by devices while
+// provisioning are not checked here
+func AuthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isDevicePath(r.URL.Path) || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := authCtl.authenticate(r)
+		if err != nil {
+			Log.Error("AuthHandler (authenticate)", F("error", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if principal == nil {
+			// Browsers are sent to the login page, API clients and scrapers get an error
+			if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/ng/") && r.URL.Path != "/metrics" {
+				http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAuthError(w, r, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		requiredRole := requiredRole(r.URL.Path, r.Method)
+		if roleLevels[principal.Role] < roleLevels[requiredRole] {
+			writeAuthError(w, r, http.StatusForbidden, "Role "+principal.Role+" is not allowed to "+r.Method+" "+r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// writeAuthError sends an authentication error, with the JSON error body for the v1 API
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		writeAPIError(w, status, message)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// RequestPrincipal returns the user or token that sent the request, nil if the request was not authenticated
+func RequestPrincipal(r *http.Request) *model.Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*model.Principal)
+	return principal
+}
+
+// isPublicPath tells if the path can be reached without authentication
+func isPublicPath(path string) bool {
+	for _, prefix := range publicPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredRole returns the role needed for the method on the path
+func requiredRole(path string, method string) string {
+	for _, permission := range routePermissions {
+		if strings.HasPrefix(path, permission.prefix) {
+			if method == http.MethodGet || method == http.MethodHead {
+				return permission.readRole
+			}
+			return permission.writeRole
+		}
+	}
+	return model.ReadOnlyRole
+}
+
+// hashToken returns the hash of an API token or session ID as stored in database. Tokens are
+// random, so a fast hash is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns a random hex string of 32 bytes
+func randomToken() (string, error) {
+	buffer := make([]byte, 32)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// authenticate returns the principal of the bearer token or session cookie of the request.
+// nil is returned if the request has no valid credentials
+func (a authController) authenticate(r *http.Request) (*model.Principal, error) {
+	session, err := a.db.OpenSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	// API tokens
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		var token model.APIToken
+		err = session.DB("ztpDashboard").C("apiToken").Find(bson.M{"tokenhash": hashToken(strings.TrimPrefix(authorization, "Bearer "))}).One(&token)
+		if err == mgo.ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &model.Principal{Name: token.Name, Role: token.Role, Token: true}, nil
+	}
+
+	// Web UI sessions
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+	var userSession model.Session
+	err = session.DB("ztpDashboard").C("session").Find(bson.M{"id": hashToken(cookie.Value)}).One(&userSession)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(userSession.Expires) {
+		session.DB("ztpDashboard").C("session").Remove(bson.M{"id": userSession.ID})
+		return nil, nil
+	}
+	// The role is read from the user so role changes apply to open sessions
+	var user model.User
+	err = session.DB("ztpDashboard").C("user").Find(bson.M{"username": userSession.Username}).One(&user)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.Principal{Name: user.Username, Role: user.Role}, nil
+}
+
+// checkAdminUser creates the first admin user if there are no users in database. The credentials are
+// taken from ADMIN_USERNAME and ADMIN_PASSWORD. If no password is set a random one is logged.
+// An error is returned if the database cannot be read
+func (a authController) checkAdminUser() error {
+	session, err := a.db.OpenSession()
+	if err != nil {
+		return errors.New("Cannot open database: " + err.Error())
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("user")
+
+	count, err := dbCollection.Count()
+	if err != nil {
+		return errors.New("Cannot count users: " + err.Error())
+	}
+	if count > 0 {
+		return nil
+	}
+
+	admin := model.User{Username: os.Getenv("ADMIN_USERNAME"), Password: os.Getenv("ADMIN_PASSWORD"), Role: model.AdminRole}
+	if admin.Username == "" {
+		admin.Username = "admin"
+	}
+	if admin.Password == "" {
+		admin.Password, err = randomToken()
+		if err != nil {
+			Log.Error("checkAdminUser (generate password)", F("error", err))
+			return nil
+		}
+		admin.Password = admin.Password[:16]
+		Log.Warn("No users found. Created the first admin user", F("username", admin.Username), F("password", admin.Password))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(admin.Password), bcrypt.DefaultCost)
+	if err != nil {
+		Log.Error("checkAdminUser (hash password)", F("error", err))
+		return nil
+	}
+	admin.PasswordHash = string(hash)
+	err = dbCollection.Insert(&admin)
+	if err != nil {
+		return errors.New("Cannot insert admin user: " + err.Error())
+	}
+	return nil
+}
+
+// handleLogin shows the login page and creates the session when the credentials are valid
+func (a authController) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.renderLogin(w, "")
+	case http.MethodPost:
+		username := r.FormValue("username")
+		password := r.FormValue("password")
+
+		// Open database
+		session, err := a.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			a.renderLogin(w, "Cannot open database")
+			requestLog(r).Error("handleLogin (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+
+		var user model.User
+		err = session.DB("ztpDashboard").C("user").Find(bson.M{"username": username}).One(&user)
+		if err == nil {
+			err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+		}
+		if err != nil {
+			requestLog(r).Info("handleLogin: failed login", F("username", username), F("remote", r.RemoteAddr))
+			w.WriteHeader(http.StatusUnauthorized)
+			a.renderLogin(w, "Invalid username or password")
+			return
+		}
+
+		sessionID, err := randomToken()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			a.renderLogin(w, err.Error())
+			requestLog(r).Error("handleLogin (generate session)", F("error", err))
+			return
+		}
+		userSession := model.Session{ID: hashToken(sessionID), Username: user.Username, Expires: time.Now().Add(sessionDuration)}
+		err = session.DB("ztpDashboard").C("session").Insert(&userSession)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			a.renderLogin(w, err.Error())
+			requestLog(r).Error("handleLogin (insert session)", F("error", err))
+			return
+		}
+		// Remove expired sessions
+		session.DB("ztpDashboard").C("session").RemoveAll(bson.M{"expires": bson.M{"$lt": time.Now()}})
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    sessionID,
+			Path:     "/",
+			Expires:  userSession.Expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/web/", http.StatusSeeOther)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// renderLogin shows the login page with an optional error message
+func (a authController) renderLogin(w http.ResponseWriter, message string) {
+	t, err := template.ParseFiles(a.loginTemplate)
+	if err != nil {
+		Log.Error("renderLogin (parse template)", F("error", err))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	t.Execute(w, message)
+}
+
+// handleLogout removes the session and sends the browser back to the login page
+func (a authController) handleLogout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err == nil {
+		session, err := a.db.OpenSession()
+		if err != nil {
+			requestLog(r).Error("handleLogout (open database)", F("error", err))
+		} else {
+			defer session.Close()
+			session.DB("ztpDashboard").C("session").Remove(bson.M{"id": hashToken(cookie.Value)})
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+}
+
+// handleAPIUsersMe returns the principal of the request
+func (a authController) handleAPIUsersMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	js, err := json.Marshal(RequestPrincipal(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPIUsersMe (encode json)", F("error", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// handleAPIUsers lists, creates, updates and deletes the local users
+func (a authController) handleAPIUsers(w http.ResponseWriter, r *http.Request) {
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPIUsers (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("user")
+
+	switch r.Method {
+	case http.MethodGet:
+		var users []model.User
+		err = dbCollection.Find(nil).All(&users)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (read database)", F("error", err))
+			return
+		}
+		if users == nil {
+			users = []model.User{}
+		}
+		js, err := json.Marshal(users)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (encode json)", F("error", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+
+	case http.MethodPost, http.MethodPut:
+		user := &model.User{}
+		err = json.NewDecoder(r.Body).Decode(user)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (decode json)", F("error", err))
+			return
+		}
+		if user.Username == "" || !model.ValidRole(user.Role) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Username and role (admin, operator or readonly) are required"))
+			return
+		}
+		count, err := dbCollection.Find(bson.M{"username": user.Username}).Count()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (find user)", F("error", err))
+			return
+		}
+		var before *model.User
+		if count > 0 {
+			before = &model.User{}
+			err = dbCollection.Find(bson.M{"username": user.Username}).One(before)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				requestLog(r).Error("handleAPIUsers (find user)", F("error", err))
+				return
+			}
+		}
+		update := bson.M{"role": user.Role}
+		if r.Method == http.MethodPost {
+			if count > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("User " + user.Username + " already exists"))
+				return
+			}
+			if user.Password == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Password is required"))
+				return
+			}
+		} else {
+			if count == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("User " + user.Username + " not found"))
+				return
+			}
+			if user.Role != model.AdminRole && a.lastAdmin(dbCollection, user.Username) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Cannot remove the admin role from the last admin user"))
+				return
+			}
+		}
+		if user.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				requestLog(r).Error("handleAPIUsers (hash password)", F("error", err))
+				return
+			}
+			user.PasswordHash = string(hash)
+			update["passwordhash"] = user.PasswordHash
+		}
+		if r.Method == http.MethodPost {
+			err = dbCollection.Insert(user)
+		} else {
+			err = dbCollection.Update(bson.M{"username": user.Username}, bson.M{"$set": update})
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (write database)", F("error", err))
+			return
+		}
+		// The password is never recorded, only the fact it changed
+		if user.Password != "" {
+			user.Password = "(changed)"
+		}
+		if r.Method == http.MethodPost {
+			auditCtl.Record(r, model.AuditCreate, "user", user.Username, nil, user)
+		} else {
+			auditCtl.Record(r, model.AuditUpdate, "user", user.Username, before, user)
+		}
+		w.Write([]byte("ok"))
+
+	case http.MethodDelete:
+		username := r.URL.Query().Get("username")
+		if username == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Username is required"))
+			return
+		}
+		if a.lastAdmin(dbCollection, username) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Cannot delete the last admin user"))
+			return
+		}
+		var before model.User
+		err = dbCollection.Find(bson.M{"username": username}).One(&before)
+		if err == nil {
+			err = dbCollection.Remove(bson.M{"username": username})
+		}
+		if err == mgo.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("User " + username + " not found"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIUsers (delete user)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "user", username, before, nil)
+
+		// Close the sessions of the user
+		session.DB("ztpDashboard").C("session").RemoveAll(bson.M{"username": username})
+		w.Write([]byte("ok"))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// lastAdmin tells if username is the only user with the admin role
+func (a authController) lastAdmin(dbCollection storeCollection, username string) bool {
+	var admins []model.User
+	err := dbCollection.Find(bson.M{"role": model.AdminRole}).All(&admins)
+	if err != nil {
+		Log.Error("lastAdmin (read database)", F("error", err))
+		return true
+	}
+	return len(admins) == 1 && admins[0].Username == username
+}
+
+// handleAPITokens lists, creates and deletes API tokens. The token is only returned when it is created
+func (a authController) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	// Open database
+	session, err := a.db.OpenSession()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPITokens (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("apiToken")
+
+	switch r.Method {
+	case http.MethodGet:
+		var tokens []model.APIToken
+		err = dbCollection.Find(nil).All(&tokens)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (read database)", F("error", err))
+			return
+		}
+		if tokens == nil {
+			tokens = []model.APIToken{}
+		}
+		js, err := json.Marshal(tokens)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (encode json)", F("error", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+
+	case http.MethodPost:
+		token := &model.APIToken{}
+		err = json.NewDecoder(r.Body).Decode(token)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (decode json)", F("error", err))
+			return
+		}
+		if token.Name == "" || !model.ValidRole(token.Role) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Name and role (admin, operator or readonly) are required"))
+			return
+		}
+		count, err := dbCollection.Find(bson.M{"name": token.Name}).Count()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (find token)", F("error", err))
+			return
+		}
+		if count > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Token " + token.Name + " already exists"))
+			return
+		}
+		token.Token, err = randomToken()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (generate token)", F("error", err))
+			return
+		}
+		token.TokenHash = hashToken(token.Token)
+		token.Created = time.Now()
+		if principal := RequestPrincipal(r); principal != nil {
+			token.CreatedBy = principal.Name
+		}
+		err = dbCollection.Insert(token)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (insert database)", F("error", err))
+			return
+		}
+		// The token itself is never recorded
+		auditToken := *token
+		auditToken.Token = ""
+		auditCtl.Record(r, model.AuditCreate, "token", token.Name, nil, auditToken)
+		js, err := json.Marshal(token)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (encode json)", F("error", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		var before model.APIToken
+		err = dbCollection.Find(bson.M{"name": name}).One(&before)
+		if err == nil {
+			err = dbCollection.Remove(bson.M{"name": name})
+		}
+		if err == mgo.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Token " + name + " not found"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPITokens (delete token)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "token", name, before, nil)
+		w.Write([]byte("ok"))
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/client"
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/gorilla/mux"
+)
+
+// newTestServer starts the routes of the dashboard behind the authentication, as main does.
+// wrap, if not nil, is applied to the handler
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	r := mux.NewRouter()
+	r.Use(logRequests)
+	registerAllRoutes(r)
+	handler := AuthHandler(r)
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// addTestToken stores an API token with the role and returns it
+func addTestToken(t *testing.T, role string) string {
+	token := t.Name() + "-" + role
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.DB("ztpDashboard").C("apiToken").Insert(&model.APIToken{Name: token, TokenHash: hashToken(token), Role: role, Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestClientToken(t *testing.T) {
+	resetTestStore(t)
+	server := newTestServer(t, nil)
+	ctx := context.Background()
+
+	_, err := client.New(server.URL).Configs(ctx)
+	if !client.IsUnauthorized(err) {
+		t.Errorf("request without token: got %v, want unauthorized", err)
+	}
+	_, err = client.New(server.URL, client.WithToken("not-a-token")).Configs(ctx)
+	if !client.IsUnauthorized(err) {
+		t.Errorf("request with unknown token: got %v, want unauthorized", err)
+	}
+
+	readOnly := client.New(server.URL, client.WithToken(addTestToken(t, model.ReadOnlyRole)))
+	_, err = readOnly.Configs(ctx)
+	if err != nil {
+		t.Errorf("read with read-only token: %v", err)
+	}
+	_, err = readOnly.CreateConfig(ctx, model.Config{Name: "readOnly", DeviceType: model.DeviceType{Name: "iOS-XR"}, Configuration: "hostname readOnly"})
+	if !client.IsForbidden(err) {
+		t.Errorf("write with read-only token: got %v, want forbidden", err)
+	}
+
+	token := addTestToken(t, model.AdminRole)
+	principal, err := client.New(server.URL, client.WithToken(token)).Me(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != token || principal.Role != model.AdminRole || !principal.Token {
+		t.Errorf("got principal %+v, want admin token %s", principal, token)
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	resetTestStore(t)
+	server := newTestServer(t, nil)
+	c := client.New(server.URL, client.WithToken(addTestToken(t, model.AdminRole)))
+	ctx := context.Background()
+
+	_, err := c.Device(ctx, "missing")
+	if !client.IsNotFound(err) {
+		t.Errorf("read missing device: got %v, want not found", err)
+	}
+	_, err = c.Config(ctx, "missing")
+	if !client.IsNotFound(err) {
+		t.Errorf("read missing config: got %v, want not found", err)
+	}
+	err = c.DeleteConfig(ctx, "missing")
+	if !client.IsNotFound(err) {
+		t.Errorf("delete missing config: got %v, want not found", err)
+	}
+}
+
+func TestClientConflict(t *testing.T) {
+	resetTestStore(t)
+	server := newTestServer(t, nil)
+	c := client.New(server.URL, client.WithToken(addTestToken(t, model.AdminRole)))
+	ctx := context.Background()
+
+	config := model.Config{Name: "conflict", DeviceType: model.DeviceType{Name: "iOS-XR"}, Configuration: "hostname conflict"}
+	_, err := c.CreateConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateConfig(ctx, config)
+	if !client.IsConflict(err) {
+		t.Errorf("create config twice: got %v, want conflict", err)
+	}
+
+	_, err = c.UploadImage(ctx, "conflict.iso", "iOS-XR", bytes.NewReader([]byte("image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := model.Device{
+		Hostname:   "conflict1",
+		Serial:     "CONFLICT1",
+		Fixedip:    "10.0.0.11",
+		DeviceType: model.DeviceType{Name: "iOS-XR"},
+		Image:      model.Image{Name: "conflict.iso"},
+		Config:     model.Config{Name: "conflict"},
+	}
+	created, err := c.CreateDevice(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Status != "Configured" || created.Config.Locationurl != "/configs/conflict.conf" {
+		t.Errorf("got device %+v, want configured device with the config reference resolved", created)
+	}
+	read, err := c.Device(ctx, "CONFLICT1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Hostname != "conflict1" {
+		t.Errorf("got hostname %s, want conflict1", read.Hostname)
+	}
+
+	device.Hostname = "conflict2"
+	device.Fixedip = "10.0.0.12"
+	_, err = c.CreateDevice(ctx, device)
+	if !client.IsConflict(err) {
+		t.Errorf("create device with a used serial: got %v, want conflict", err)
+	}
+	err = c.DeleteConfig(ctx, "conflict")
+	if !client.IsConflict(err) {
+		t.Errorf("delete config used by a device: got %v, want conflict", err)
+	}
+}
+
+// countingBody counts the bytes of the request body read by the server
+type countingBody struct {
+	io.ReadCloser
+	read *int64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(b.read, int64(n))
+	return n, err
+}
+
+func TestClientUploadImageStreams(t *testing.T) {
+	resetTestStore(t)
+	var read int64
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = countingBody{r.Body, &read}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := client.New(server.URL, client.WithToken(addTestToken(t, model.AdminRole)))
+
+	// The second half of the image is only written once the server has read the first half, so the
+	// upload blocks if the client buffers the file before sending it
+	half := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	file, writer := io.Pipe()
+	stalled := make(chan bool, 1)
+	go func() {
+		_, err := writer.Write(half)
+		if err != nil {
+			return
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for atomic.LoadInt64(&read) < int64(len(half)) {
+			if time.Now().After(deadline) {
+				stalled <- true
+				writer.CloseWithError(io.ErrNoProgress)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		writer.Write(half)
+		writer.Close()
+	}()
+
+	image, err := c.UploadImage(context.Background(), "stream.iso", "NX-OS", file)
+	if len(stalled) > 0 {
+		t.Fatal("the server did not receive the start of the image before the end was written")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Locationurl != "/images/stream.iso" || image.DeviceType.Name != "NX-OS" {
+		t.Errorf("got image %+v", image)
+	}
+	content, err := ioutil.ReadFile(basePath + "/public/images/stream.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256.Sum256(content) != sha256.Sum256(append(half, half...)) {
+		t.Errorf("stored image of %d bytes differs from the %d bytes uploaded", len(content), 2*len(half))
+	}
+}
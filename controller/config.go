@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
@@ -37,36 +36,14 @@ func (c configController) handleConfigFiles(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
-		CustomLog("handleConfigFiles (reading image file): "+err.Error(), ErrorSeverity)
+		requestLog(r).Error("handleConfigFiles (reading image file)", F("error", err))
 		return
 	}
 
-	var device model.Device
-	// Open database
-	session, err := c.db.OpenSession()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		go CustomLog("handleConfigFiles (open database): "+err.Error(), ErrorSeverity)
-		return
-	}
-	defer session.Close()
-	dbCollection := session.DB("ztpDashboard").C("device")
+	// Update the device status
+	updateDownloadStatus(remoteIP, "configs", requestVars["configName"])
 
-	// If device not found log the error and continue. Otherwhise update database
-	err = dbCollection.Find(bson.M{"fixedip": remoteIP}).One(&device)
-	if err != nil {
-		go CustomLog("handleConfigFiles (Find request device): "+remoteIP+" "+err.Error(), DebugSeverity)
-	} else {
-		// Only do update if device status is different from desired
-		if device.Status != "Running day 0 config" {
-			go CustomLog("handleConfigFiles: Updating device "+device.Hostname+" (serial "+device.Serial+") status to 'Running day 0 config'", DebugSeverity)
-			device.Status = "Running day 0 config"
-			dbCollection.Update(bson.M{"fixedip": remoteIP}, &device)
-			go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") is running day 0 config " + requestVars["configName"])
-		}
-	}
-	w.Write(content)
+	writeDownload(w, "configs", content)
 }
 
 // handleConfig will be executed when a request to /ng/configs is done
@@ -90,10 +67,10 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 		err := dec.Decode(config)
 
 		if err != nil {
-			log.Print(err)
+			requestLog(r).Debug("handleAPIConfigs (decode json)", F("error", err))
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (decode json): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (decode json)", F("error", err))
 			return
 		}
 
@@ -102,7 +79,7 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
@@ -114,7 +91,7 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (read database)", F("error", err))
 			return
 		}
 		if count > 0 {
@@ -129,7 +106,7 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (save config file to local disk): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (save config file to local disk)", F("error", err))
 			return
 		}
 
@@ -140,9 +117,10 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (insert database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (insert database)", F("error", err))
 			return
 		}
+		auditCtl.Record(r, model.AuditCreate, "config", config.Name, nil, config)
 
 		// Return ok message
 		w.Write([]byte("ok"))
@@ -152,27 +130,36 @@ func (c configController) handleAPIConfigs(w http.ResponseWriter, r *http.Reques
 
 		var configs []model.Config
 
+		// Filter, sort and page requested
+		query, err := parseListQuery(r, configListFields)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
 		// Open database
 		session, err := c.db.OpenSession()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 		dbCollection := session.DB("ztpDashboard").C("config")
 
-		err = dbCollection.Find(nil).All(&configs)
+		total, err := query.run(dbCollection, &configs)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIConfigs (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIConfigs (read database)", F("error", err))
 			return
 		}
 		if configs == nil {
 			configs = []model.Config{}
 		}
+		setListHeaders(w, total)
 		enc := json.NewEncoder(w)
 		enc.Encode(configs)
 
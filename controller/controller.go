@@ -4,15 +4,14 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 var basePath = os.Getenv("GOPATH") + "/src/github.com/CiscoSE/ztp-dashboard"
 
-const ErrorSeverity = "ERROR"
-const DebugSeverity = "DEBUG"
-
 var (
 	indexController index
 	homeController  home
@@ -25,67 +24,178 @@ var (
 	WebexTeamsCtl   WebexTeamsController
 	SituationMgrCtl SituationMgrController
 	testController  TestController
+	scopeCtl        scopeController
+	leaseCtl        leaseController
+	authCtl         authController
+	auditCtl        auditController
+	apiV1Ctl        apiV1Controller
+	openAPICtl      openAPIController
+	eventsCtl       eventsController
+	metricsCtl      metricsController
+	healthCtl       healthController
+	alertCtl        alertController
+	watchdog        provisioningWatchdog
 )
 
 // Startup associates controllers with templates and routes
 func Startup(templates map[string]*template.Template, r *mux.Router) {
 
-	// Handle web server mappings
+	// Errors are also sent to Situation Manager, without delaying the code that logs them
+	AddLogHook("situationManager", LevelError, situationMgrLogBuffer, func(entry LogEntry) {
+		SituationMgrCtl.SendLogEvent(entry)
+	})
 
-	// Home & Index
-	indexController.template = templates["index.html"]
-	indexController.registerRoutes(r)
+	// Notifications are queued and sent from the background, with retries
+	go outbox.run()
 
-	homeController.template = templates["home.html"]
-	homeController.registerRoutes(r)
+	// Request IDs and a logger with the route for each request
+	r.Use(logRequests)
 
-	// Devices
+	// Templates and files of the controllers are set before their routes are registered, since
+	// the handlers are bound to copies of the controllers
+	authCtl.loginTemplate = basePath + "/htmlTemplates/login.html"
+	indexController.template = templates["index.html"]
+	homeController.template = templates["home.html"]
 	deviceCtl.deviceListTemplate = templates["devices.html"]
 	deviceCtl.deviceDetailTemplate = templates["deviceDetail.html"]
-	deviceCtl.registerRoutes(r)
-
-	// Create device types if not present
-	deviceCtl.checkDeviceTypes()
-
-	// Settings
 	settingsCtl.template = templates["settings.html"]
-	settingsCtl.registerRoutes(r)
-
-	// Configurations
 	configsCtl.configListTemplate = templates["configs.html"]
 	configsCtl.configDetailTemplate = templates["configDetail.html"]
-	configsCtl.registerRoutes(r)
-
-	// Images
 	imagesCtl.imageListTemplate = templates["images.html"]
 	imagesCtl.imageDetailTemplate = templates["imageDetail.html"]
-	imagesCtl.registerRoutes(r)
-
-	// Public assets and configs
-	r.PathPrefix("/assets/").Handler(http.FileServer(http.Dir(basePath + "/public")))
 
 	// Handle DHCP Config files
 	dhcpController.DhcpTemplate = basePath + "/dhcpConfTemplates/dhcpd.conf"
+	dhcpController.DhcpSubnetTemplate = basePath + "/dhcpConfTemplates/dhcpSubnet.conf"
 	dhcpController.DhcpXRHostsTemplate = basePath + "/dhcpConfTemplates/dhcpXRHost.conf"
 	dhcpController.DhcpNXHostsTemplate = basePath + "/dhcpConfTemplates/dhcpNXHost.conf"
 	dhcpController.Dhcp6Template = basePath + "/dhcpConfTemplates/dhcpd6.conf"
+	dhcpController.Dhcp6SubnetTemplate = basePath + "/dhcpConfTemplates/dhcp6Subnet.conf"
 	dhcpController.Dhcp6XRHostsTemplate = basePath + "/dhcpConfTemplates/dhcp6XRHost.conf"
 	dhcpController.Dhcp6NXHostsTemplate = basePath + "/dhcpConfTemplates/dhcp6NXHost.conf"
 
 	// Handle Day 0 script files
 	scriptCtl.xrShellTemplate = basePath + "/shellTemplates/ztpXR.sh"
 	scriptCtl.nxPythonTemplate = basePath + "/pythonTemplates/poapNX.py"
-	scriptCtl.registerRoutes(r)
 
-	// Integration
 	// Webex teams
 	WebexTeamsCtl.BaseURL = "https://api.ciscospark.com"
 
+	// Handle web server mappings. Routes missing from the OpenAPI document are logged
+	registerAllRoutes(r)
+	checkOpenAPIRoutes(r)
+
+	// Create the device types and the first admin user once the database is reachable
+	go initDatabase()
+
+	// Alerts sent to Alertmanager and PagerDuty
+	go alertCtl.run()
+
+	// Devices that stay too long in a provisioning status are timed out
+	go watchdog.run()
+
+	// Integration
+	if webhookURL := os.Getenv("WEBEX_WEBHOOK_URL"); webhookURL != "" {
+		go func() {
+			err := WebexTeamsCtl.RegisterWebhook(webhookURL)
+			if err != nil {
+				Log.Error("Startup (register webex webhook)", F("error", err))
+			}
+		}()
+	}
+
 	// Make sure that needed directories exists
 	CreateDirIfNotExist(basePath + "/public/configs")
 	CreateDirIfNotExist(basePath + "/public/images")
 	CreateDirIfNotExist(basePath + "/public/scripts")
 	go dhcpController.GenerateConfigFiles()
+
+	// Embedded TFTP server for devices that cannot download their files over HTTP
+	if os.Getenv("TFTP_ENABLED") == "on" {
+		tftpServer := TFTPServer{Root: basePath + "/public", Port: os.Getenv("TFTP_PORT")}
+		if tftpServer.Port == "" {
+			tftpServer.Port = "69"
+		}
+		go func() {
+			err := tftpServer.ListenAndServe()
+			if err != nil {
+				Log.Error("Startup (TFTP server)", F("error", err))
+			}
+		}()
+	}
+}
+
+// registerAllRoutes registers the routes of every controller
+func registerAllRoutes(r *mux.Router) {
+	// Users, sessions and API tokens
+	authCtl.registerRoutes(r)
+
+	// Liveness and readiness probes
+	healthCtl.registerRoutes(r)
+
+	// Audit trail
+	auditCtl.registerRoutes(r)
+
+	// Home & Index
+	indexController.registerRoutes(r)
+	homeController.registerRoutes(r)
+
+	// Devices
+	deviceCtl.registerRoutes(r)
+
+	// Settings
+	settingsCtl.registerRoutes(r)
+
+	// Configurations
+	configsCtl.registerRoutes(r)
+
+	// Images
+	imagesCtl.registerRoutes(r)
+
+	// DHCP scopes and leases
+	scopeCtl.registerRoutes(r)
+	leaseCtl.registerRoutes(r)
+
+	// Live events
+	eventsCtl.registerRoutes(r)
+
+	// Prometheus metrics and request latency
+	metricsCtl.registerRoutes(r)
+
+	// Alerts sent to Alertmanager and PagerDuty
+	alertCtl.registerRoutes(r)
+
+	// Versioned REST API
+	apiV1Ctl.registerRoutes(r)
+
+	// OpenAPI document
+	openAPICtl.registerRoutes(r)
+
+	// Public assets and configs
+	r.PathPrefix("/assets/").Handler(http.FileServer(http.Dir(basePath + "/public")))
+
+	// Day 0 scripts
+	scriptCtl.registerRoutes(r)
+
+	// Webex Teams bot webhook
+	WebexTeamsCtl.registerRoutes(r)
+}
+
+// initDatabase creates the device types and the first admin user. The database might not be
+// reachable yet when the application starts, so it is retried until it succeeds
+func initDatabase() {
+	for {
+		err := deviceCtl.checkDeviceTypes()
+		if err == nil {
+			err = authCtl.checkAdminUser()
+		}
+		if err == nil {
+			atomic.StoreInt32(&databaseReady, 1)
+			return
+		}
+		Log.Error("initDatabase: cannot initialize the database", F("error", err), F("retryIn", databaseRetryInterval.String()))
+		time.Sleep(databaseRetryInterval)
+	}
 }
 
 // CreateDirIfNotExist creates directories if not present
@@ -95,10 +205,10 @@ func CreateDirIfNotExist(dir string) {
 		if os.IsNotExist(err) {
 			err = os.MkdirAll(dir, 0755)
 			if err != nil {
-				go CustomLog("CreateDirIfNotExist (create directory): "+err.Error(), ErrorSeverity)
+				Log.Error("CreateDirIfNotExist (create directory)", F("error", err))
 			}
 		} else {
-			go CustomLog("CreateDirIfNotExist (check directory): "+err.Error(), ErrorSeverity)
+			Log.Error("CreateDirIfNotExist (check directory)", F("error", err))
 		}
 	}
 }
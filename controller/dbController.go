@@ -9,12 +9,73 @@ import (
 type dbController struct {
 }
 
-func (d dbController) OpenSession() (*mgo.Session, error) {
+// storeSession, storeDatabase, storeCollection and storeQuery are the parts of the mgo API used by
+// the controllers. MongoDB is used through mgoSession, the tests use an in-memory store instead
+type storeSession interface {
+	DB(name string) storeDatabase
+	Close()
+}
+
+type storeDatabase interface {
+	C(name string) storeCollection
+}
+
+type storeCollection interface {
+	Find(query interface{}) storeQuery
+	FindId(id interface{}) storeQuery
+	Count() (int, error)
+	Insert(docs ...interface{}) error
+	Update(selector interface{}, update interface{}) error
+	UpdateId(id interface{}, update interface{}) error
+	UpdateAll(selector interface{}, update interface{}) (*mgo.ChangeInfo, error)
+	UpsertId(id interface{}, update interface{}) (*mgo.ChangeInfo, error)
+	Remove(selector interface{}) error
+	RemoveId(id interface{}) error
+	RemoveAll(selector interface{}) (*mgo.ChangeInfo, error)
+}
+
+type storeQuery interface {
+	Sort(fields ...string) storeQuery
+	Skip(n int) storeQuery
+	Limit(n int) storeQuery
+	Select(selector interface{}) storeQuery
+	Count() (int, error)
+	One(result interface{}) error
+	All(result interface{}) error
+}
+
+// openDBSession opens a session on the store. It is replaced by the tests
+var openDBSession = func() (storeSession, error) {
 	// Open database
 	session, err := mgo.Dial(os.Getenv("DB_URI"))
 	if err != nil {
 		return nil, err
 	}
 	session.SetMode(mgo.Monotonic, true)
-	return session, err
+	return mgoSession{session}, err
+}
+
+func (d dbController) OpenSession() (storeSession, error) {
+	return openDBSession()
 }
+
+// mgoSession, mgoDatabase, mgoCollection and mgoQuery adapt the mgo types to the store interfaces
+type mgoSession struct{ *mgo.Session }
+
+func (s mgoSession) DB(name string) storeDatabase { return mgoDatabase{s.Session.DB(name)} }
+
+type mgoDatabase struct{ *mgo.Database }
+
+func (d mgoDatabase) C(name string) storeCollection { return mgoCollection{d.Database.C(name)} }
+
+type mgoCollection struct{ *mgo.Collection }
+
+func (c mgoCollection) Find(query interface{}) storeQuery { return mgoQuery{c.Collection.Find(query)} }
+func (c mgoCollection) FindId(id interface{}) storeQuery  { return mgoQuery{c.Collection.FindId(id)} }
+
+type mgoQuery struct{ *mgo.Query }
+
+func (q mgoQuery) Sort(fields ...string) storeQuery       { return mgoQuery{q.Query.Sort(fields...)} }
+func (q mgoQuery) Skip(n int) storeQuery                  { return mgoQuery{q.Query.Skip(n)} }
+func (q mgoQuery) Limit(n int) storeQuery                 { return mgoQuery{q.Query.Limit(n)} }
+func (q mgoQuery) Select(selector interface{}) storeQuery { return mgoQuery{q.Query.Select(selector)} }
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+)
+
+// downloadStatus is the device status and notification text used when a device downloads a file
+type downloadStatus struct {
+	status string
+	action string
+}
+
+// downloadStatuses maps the public directory a file is served from to the status of the device
+var downloadStatuses = map[string]downloadStatus{
+	"scripts": {status: "Running init script", action: "is executing script"},
+	"configs": {status: "Running day 0 config", action: "is running day 0 config"},
+	"images":  {status: "Installing image", action: "is installing image"},
+}
+
+// provisioningFailedStatus is the status of the devices that reported a provisioning failure
+const provisioningFailedStatus = "Provisioning failed"
+
+// provisioningTimedOutStatus is the status of the devices that stayed in a provisioning status
+// longer than its timeout
+const provisioningTimedOutStatus = "Timed out"
+
+// deviceAddedDetail is the timeline detail of the registration of a device
+const deviceAddedDetail = "Device added"
+
+// updateDownloadStatus updates the status of the device with the given fixed IP when it downloads a
+// file from the scripts, configs or images directories. It is used by the HTTP and TFTP servers
+func updateDownloadStatus(remoteIP string, directory string, fileName string) {
+	download, present := downloadStatuses[directory]
+	if !present {
+		return
+	}
+
+	var device model.Device
+	// Open database
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		Log.Error("updateDownloadStatus (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("device")
+
+	// If device not found log the error and continue. Otherwhise update database
+	err = dbCollection.Find(bson.M{"fixedip": remoteIP}).One(&device)
+	if err != nil {
+		Log.Debug("updateDownloadStatus (Find request device)", F("ip", remoteIP), F("error", err))
+		return
+	}
+	// Only do update if device status is different from desired
+	if device.Status != download.status {
+		Log.Debug("updateDownloadStatus: Updating device status", append(deviceFields(device.Hostname, device.Serial), F("status", download.status))...)
+		device.Status = download.status
+		dbCollection.Update(bson.M{"fixedip": remoteIP}, &device)
+		recordDeviceStatus(device, fileName)
+		// Notify status change
+		Notify(Notification{Type: model.NotifyDeviceStatus, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status, Object: fileName})
+	}
+}
+
+// recordDeviceStatus adds the current status of the device to its timeline. Errors are logged, the
+// status has already been changed when this is called
+func recordDeviceStatus(device model.Device, detail string) {
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		Log.Error("recordDeviceStatus (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	change := model.DeviceStatusChange{
+		Time:     time.Now().UTC(),
+		Hostname: device.Hostname,
+		Serial:   device.Serial,
+		Status:   device.Status,
+		Detail:   detail,
+	}
+	err = session.DB("ztpDashboard").C("deviceStatus").Insert(&change)
+	if err != nil {
+		Log.Error("recordDeviceStatus (insert database)", F("error", err))
+	}
+	PublishEvent(model.Event{
+		Time:     change.Time,
+		Type:     model.EventDeviceStatus,
+		Hostname: device.Hostname,
+		Serial:   device.Serial,
+		Status:   device.Status,
+		Message:  detail,
+	})
+}
+
+// lastStatusChange returns the last entry of the timeline of a device, nil when the timeline is empty
+func lastStatusChange(session storeSession, hostname string) (*model.DeviceStatusChange, error) {
+	var timeline []model.DeviceStatusChange
+	err := session.DB("ztpDashboard").C("deviceStatus").Find(bson.M{"hostname": hostname}).Sort("-time").Limit(1).All(&timeline)
+	if err != nil || len(timeline) == 0 {
+		return nil, err
+	}
+	return &timeline[0], nil
+}
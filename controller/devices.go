@@ -2,12 +2,15 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
-	"log"
+	"net"
 	"net/http"
 	"strings"
 
 	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/asaskevich/govalidator"
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/gorilla/mux"
 )
@@ -36,7 +39,7 @@ func (n deviceController) handleAPIDevicesProvisioned(w http.ResponseWriter, r *
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevicesProvisioned (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevicesProvisioned (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
@@ -45,19 +48,38 @@ func (n deviceController) handleAPIDevicesProvisioned(w http.ResponseWriter, r *
 		// If device not found log the error and continue. Otherwhise update database
 		err = dbCollection.Find(bson.M{"fixedip": remoteIP}).One(&device)
 		if err != nil {
-			go CustomLog("handleAPIDevicesProvisioned (Find device): "+remoteIP+" "+err.Error(), DebugSeverity)
+			requestLog(r).Debug("handleAPIDevicesProvisioned (Find device)", F("ip", remoteIP), F("error", err))
 		} else {
+			// Devices bound to a switch port learn their serial on first contact. The ZTP and POAP
+			// scripts report it with status=started as soon as they run
+			reportedSerial := r.URL.Query().Get("serial")
+			if device.Serial == "" && reportedSerial != "" {
+				n.learnSerial(&device, reportedSerial)
+			}
+			if r.URL.Query().Get("status") == "started" {
+				w.Write([]byte("ok"))
+				return
+			}
+			// Devices report failures with status=failed and the reason in detail
+			if r.URL.Query().Get("status") == "failed" {
+				n.provisioningFailed(r, &device, r.URL.Query().Get("detail"))
+				w.Write([]byte("ok"))
+				return
+			}
 			// Only do update if device status is different from desired
 			if device.Status != "Provisioned" {
-				go CustomLog("handleAPIDevicesProvisioned: Updating device "+device.Serial+" status to 'Provisioned'", DebugSeverity)
+				requestLog(r).Info("handleAPIDevicesProvisioned: Device provisioned", deviceFields(device.Hostname, device.Serial)...)
 				device.Status = "Provisioned"
 				dbCollection.Update(bson.M{"fixedip": remoteIP}, &device)
+				recordDeviceStatus(device, "Provisioning finished")
+				go observeProvisioningDuration(device)
+				go SituationMgrCtl.ClearDeviceEvents(device, "Device "+device.Hostname+" provisioned", situationProvisioning)
 
 				// Send notification
-				go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") provisioned successfully.")
+				Notify(Notification{Type: model.NotifyDeviceStatus, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status})
 
 				// Start automated tests
-				go testController.TestDevice(device)
+				go testController.TestDevice(device, testTriggerProvisioned)
 			}
 		}
 
@@ -67,16 +89,153 @@ func (n deviceController) handleAPIDevicesProvisioned(w http.ResponseWriter, r *
 	}
 }
 
+// provisioningFailed records the failure reported by a device and notifies it
+func (n deviceController) provisioningFailed(r *http.Request, device *model.Device, detail string) {
+	requestLog(r).Warn("handleAPIDevicesProvisioned: Provisioning failed", append(deviceFields(device.Hostname, device.Serial), F("detail", detail))...)
+	session, err := n.db.OpenSession()
+	if err != nil {
+		requestLog(r).Error("provisioningFailed (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	device.Status = provisioningFailedStatus
+	err = session.DB("ztpDashboard").C("device").Update(bson.M{"hostname": device.Hostname}, device)
+	if err != nil {
+		requestLog(r).Error("provisioningFailed (update database)", F("error", err))
+		return
+	}
+	timelineDetail := "Provisioning failed"
+	if detail != "" {
+		timelineDetail += ": " + detail
+	}
+	recordDeviceStatus(*device, timelineDetail)
+	go SituationMgrCtl.RaiseDeviceEvent(*device, situationProvisioning, LevelError, "Provisioning of device "+device.Hostname+" failed")
+	go alertCtl.fireAlert(provisioningFailedAlert(*device, detail))
+	Notify(Notification{Type: model.NotifyProvisionFailed, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status, Detail: detail})
+}
+
+// learnSerial records the serial reported by a device that was registered only by its switch port
+func (n deviceController) learnSerial(device *model.Device, serial string) {
+	// Open database
+	session, err := n.db.OpenSession()
+	if err != nil {
+		Log.Error("learnSerial (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("device")
+
+	// Another record could already own that serial
+	count, err := dbCollection.Find(bson.M{"serial": serial}).Count()
+	if err != nil {
+		Log.Error("learnSerial (read database)", F("error", err))
+		return
+	}
+	if count > 0 {
+		Log.Error("learnSerial: serial already in use", deviceFields(device.Hostname, serial)...)
+		return
+	}
+
+	err = dbCollection.Update(bson.M{"hostname": device.Hostname}, bson.M{"$set": bson.M{"serial": serial}})
+	if err != nil {
+		Log.Error("learnSerial (update database)", F("error", err))
+		return
+	}
+	device.Serial = serial
+	Log.Info("learnSerial: Device reported its serial", deviceFields(device.Hostname, serial)...)
+
+	// Script names depend on the serial
+	go dhcpController.GenerateConfigFiles()
+
+	// Send notification
+	Notify(Notification{Type: model.NotifyDeviceSerial, Hostname: device.Hostname, Serial: serial})
+}
+
+// checkPortBinding validates the switch port binding of a device and makes sure that no other
+// device is bound to the same port. An error message is returned if the binding is not valid
+func (n deviceController) checkPortBinding(dbCollection storeCollection, device *model.Device) (string, error) {
+	if device.Serial == "" && !device.HasPortBinding() {
+		return "Either a serial or a switch port binding is required", nil
+	}
+	if govalidator.IsIPv6(device.Fixedip) {
+		if device.RelayCircuitID != "" || device.RelayRemoteID != "" {
+			return "IPv6 devices can only be bound by relay interface ID", nil
+		}
+		if device.Serial == "" && device.InterfaceID == "" {
+			return "Relay interface ID is required for IPv6 devices without serial", nil
+		}
+	} else if device.InterfaceID != "" {
+		return "IPv4 devices can only be bound by relay circuit ID or remote ID", nil
+	}
+
+	bindings := []bson.M{}
+	if device.RelayCircuitID != "" {
+		bindings = append(bindings, bson.M{"relaycircuitid": device.RelayCircuitID})
+	} else if device.RelayRemoteID != "" {
+		// Remote ID is only used to identify the device when there is no circuit ID
+		bindings = append(bindings, bson.M{"relaycircuitid": "", "relayremoteid": device.RelayRemoteID})
+	}
+	if device.InterfaceID != "" {
+		bindings = append(bindings, bson.M{"interfaceid": device.InterfaceID})
+	}
+	for _, binding := range bindings {
+		binding["hostname"] = bson.M{"$ne": device.Hostname}
+		count, err := dbCollection.Find(binding).Count()
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return "Switch port already bound to another device", nil
+		}
+	}
+	return "", nil
+}
+
+// checkScope makes sure that the scope assigned to the device exists and that the device fixed IP
+// is a valid address inside of it. An error message is returned if the scope is not valid
+func (n deviceController) checkScope(session storeSession, device *model.Device) (string, error) {
+	var scope model.Scope
+	if device.Scope == "" {
+		// Devices without scope use the default scope of their address family, if configured
+		defaultScope := scopeCtl.ScopeForDevice(*device, nil)
+		if defaultScope == nil {
+			return "", nil
+		}
+		scope = *defaultScope
+	} else {
+		err := session.DB("ztpDashboard").C("scope").Find(bson.M{"name": device.Scope}).One(&scope)
+		if err == mgo.ErrNotFound {
+			return "Scope " + device.Scope + " not found", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	network, err := ScopeNetwork(scope)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(device.Fixedip)
+	if ip == nil || !network.Contains(ip) {
+		return "Fixed IP " + device.Fixedip + " is not inside subnet " + scope.Subnet + " of scope " + scope.Name, nil
+	}
+	if isReservedIP(ip, network, scope) {
+		return "Fixed IP " + device.Fixedip + " is reserved in scope " + scope.Name, nil
+	}
+	return "", nil
+}
+
 // checkDeviceTypes check if NX and XR device types are present in Database
-// If not present, will create them
-func (n deviceController) checkDeviceTypes() {
+// If not present, will create them. An error is returned if the database cannot be used
+func (n deviceController) checkDeviceTypes() error {
 
 	var deviceTypes []model.DeviceType
 
 	// Open database
 	session, err := n.db.OpenSession()
 	if err != nil {
-		log.Fatal("Cannot open database: " + err.Error() + "\n")
+		return errors.New("Cannot open database: " + err.Error())
 	}
 	defer session.Close()
 
@@ -84,25 +243,18 @@ func (n deviceController) checkDeviceTypes() {
 	dbCollection := session.DB("ztpDashboard").C("deviceType")
 	err = dbCollection.Find(nil).All(&deviceTypes)
 	if err != nil {
-		log.Fatal("Cannot read database table: " + err.Error() + "\n")
+		return errors.New("Cannot read database table: " + err.Error())
 	}
 
 	// Check if deviceTypes exist and have length greater than 0
-	if deviceTypes == nil {
-		n.createDeviceTypes()
-	} else if len(deviceTypes) == 0 {
-		n.createDeviceTypes()
+	if len(deviceTypes) == 0 {
+		return n.createDeviceTypes(session)
 	}
+	return nil
 }
 
 // createDeviceTypes insert iOS-XR and NX-OS into the database
-func (n deviceController) createDeviceTypes() {
-	// Open database
-	session, err := n.db.OpenSession()
-	if err != nil {
-		log.Fatal("Cannot open database: " + err.Error() + "\n")
-	}
-	defer session.Close()
+func (n deviceController) createDeviceTypes(session storeSession) error {
 	dbCollection := session.DB("ztpDashboard").C("deviceType")
 
 	// Create IOS-XR device type
@@ -110,14 +262,15 @@ func (n deviceController) createDeviceTypes() {
 	deviceTypeNx := model.DeviceType{Name: "NX-OS"}
 
 	// Insert new device types in Database
-	err = dbCollection.Insert(&deviceTypeXr)
+	err := dbCollection.Insert(&deviceTypeXr)
 	if err != nil {
-		log.Fatal("Couldn't insert in database: " + err.Error() + "\n")
+		return errors.New("Couldn't insert in database: " + err.Error())
 	}
 	err = dbCollection.Insert(&deviceTypeNx)
 	if err != nil {
-		log.Fatal("Couldn't insert in database: " + err.Error() + "\n")
+		return errors.New("Couldn't insert in database: " + err.Error())
 	}
+	return nil
 }
 
 func (n deviceController) handleDevices(w http.ResponseWriter, r *http.Request) {
@@ -138,10 +291,10 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 		err := dec.Decode(device)
 
 		if err != nil {
-			log.Print(err)
+			requestLog(r).Debug("handleAPIDevices (decode json)", F("error", err))
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (decode json): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (decode json)", F("error", err))
 			return
 		}
 
@@ -150,69 +303,18 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 
-		dbCollection := session.DB("ztpDashboard").C("device")
-
-		// Check if the name has been used before
-		count, err := dbCollection.Find(bson.M{"hostname": device.Hostname}).Count()
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (read database): "+err.Error(), ErrorSeverity)
-			return
-		}
-		if count > 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Hostname " + device.Hostname + " already in use"))
-			return
-		}
-
-		// Check if the serial has been used before
-		count, err = dbCollection.Find(bson.M{"serial": device.Serial}).Count()
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (read database): "+err.Error(), ErrorSeverity)
-			return
-		}
-		if count > 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Serial " + device.Serial + " already in use"))
-			return
-		}
-
-		// Check if the fixed IP has been used before
-		count, err = dbCollection.Find(bson.M{"fixedip": device.Fixedip}).Count()
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (read database): "+err.Error(), ErrorSeverity)
-			return
-		}
-		if count > 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Fixed IP " + device.Fixedip + " already in use"))
-			return
-		}
-
-		// Insert new device in Database
-		err = dbCollection.Insert(&device)
+		status, err := n.addDevice(session, device)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(legacyStatus(status))
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (insert database): "+err.Error(), ErrorSeverity)
 			return
 		}
-
-		// Regenerate config file and restart dhcp service
-		go dhcpController.GenerateConfigFiles()
-
-		// Send notification
-		go WebexTeamsCtl.SendMessage("New device configuration added for " + device.Serial)
+		auditCtl.Record(r, model.AuditCreate, "device", device.Hostname, nil, device)
 
 		// Return ok message
 		w.Write([]byte("ok"))
@@ -221,28 +323,37 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 
 		var devices []model.Device
 
+		// Filter, sort and page requested
+		query, err := parseListQuery(r, deviceListFields)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
 		// Open database
 		session, err := n.db.OpenSession()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 		dbCollection := session.DB("ztpDashboard").C("device")
 
-		err = dbCollection.Find(nil).All(&devices)
+		total, err := query.run(dbCollection, &devices)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
 			return
 		}
 
 		if devices == nil {
 			devices = []model.Device{}
 		}
+		setListHeaders(w, total)
 		enc := json.NewEncoder(w)
 		enc.Encode(devices)
 
@@ -255,10 +366,10 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 		err := dec.Decode(device)
 
 		if err != nil {
-			log.Print(err)
+			requestLog(r).Debug("handleAPIDevices (decode json)", F("error", err))
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Couldn't decode json: " + err.Error() + "\n"))
-			go CustomLog("handleAPIDevices (decode json): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (decode json)", F("error", err))
 			return
 		}
 
@@ -267,20 +378,60 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 
 		dbCollection := session.DB("ztpDashboard").C("device")
 
+		// Validate the new switch port binding and scope
+		var current model.Device
+		err = dbCollection.Find(bson.M{"hostname": device.Hostname}).One(&current)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
+			return
+		}
+		before := current
+		current.RelayCircuitID = device.RelayCircuitID
+		current.RelayRemoteID = device.RelayRemoteID
+		current.InterfaceID = device.InterfaceID
+		message, err := n.checkPortBinding(dbCollection, &current)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
+			return
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(message))
+			return
+		}
+
+		// Validate the new DHCP scope
+		current.Scope = device.Scope
+		message, err = n.checkScope(session, &current)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
+			return
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(message))
+			return
+		}
 		// Update new device in Database (Image and day0 script)
 		err = dbCollection.Update(bson.M{"hostname": device.Hostname}, bson.M{"$set": bson.M{"config": device.Config}})
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (update database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (update database)", F("error", err))
 			return
 		}
 
@@ -288,72 +439,272 @@ func (n deviceController) handleAPIDevices(w http.ResponseWriter, r *http.Reques
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (update database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (update database)", F("error", err))
+			return
+		}
+
+		// Update switch port binding and DHCP scope
+		err = dbCollection.Update(bson.M{"hostname": device.Hostname}, bson.M{"$set": bson.M{
+			"relaycircuitid": device.RelayCircuitID,
+			"relayremoteid":  device.RelayRemoteID,
+			"interfaceid":    device.InterfaceID,
+			"scope":          device.Scope,
+		}})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIDevices (update database)", F("error", err))
 			return
 		}
 
+		// Record the change
+		var updated model.Device
+		err = dbCollection.Find(bson.M{"hostname": device.Hostname}).One(&updated)
+		if err != nil {
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
+		}
+		auditCtl.Record(r, model.AuditUpdate, "device", device.Hostname, before, updated)
+
 		// Regenerate config file and restart dhcp service
 		go dhcpController.GenerateConfigFiles()
 
 		// Send notification
-		go WebexTeamsCtl.SendMessage("Device " + device.Serial + " updated.")
+		Notify(Notification{Type: model.NotifyDeviceUpdate, Hostname: device.Hostname, Serial: device.Serial})
 
 		// Return ok message
 		w.Write([]byte("ok"))
 		break
 	case http.MethodDelete:
-		// Retrieve serial in request
-		queryString, present := r.URL.Query()["serial"]
-
-		if !present || len(queryString) != 1 {
+		// Retrieve serial in request. Devices bound to a switch port might only have a hostname
+		query := bson.M{}
+		if queryString, present := r.URL.Query()["serial"]; present && len(queryString) == 1 {
+			query["serial"] = queryString[0]
+		} else if queryString, present := r.URL.Query()["hostname"]; present && len(queryString) == 1 {
+			query["hostname"] = queryString[0]
+		} else {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Serial parameter not found"))
+			w.Write([]byte("Serial or hostname parameter not found"))
+			return
 		}
-		deviceSerial := queryString[0]
 
 		// Open database
 		session, err := n.db.OpenSession()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 
 		dbCollection := session.DB("ztpDashboard").C("device")
-		count, err := dbCollection.Find(bson.M{"serial": deviceSerial}).Count()
+		count, err := dbCollection.Find(query).Count()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
 			return
 		}
 		if count != 1 {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Couldn't find single object to delete in DB"))
-			go CustomLog("handleAPIDevices (delete database): Couldn't find single object to delete in DB", ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (delete database): Couldn't find single object to delete in DB")
 			return
 		}
-		err = dbCollection.Remove(bson.M{"serial": deviceSerial})
+		var before model.Device
+		err = dbCollection.Find(query).One(&before)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDevices (delete database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDevices (read database)", F("error", err))
 			return
 		}
+		err = dbCollection.Remove(query)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIDevices (delete database)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "device", before.Hostname, before, nil)
 
-		// Regenerate dhcp and scripts
+		// Regenerate dhcp and scripts. The fixed IP of the device is free again
 		dhcpController.GenerateConfigFiles()
 
 		// Send notification
-		go WebexTeamsCtl.SendMessage("Device " + deviceSerial + " removed.")
+		Notify(Notification{Type: model.NotifyDeviceDelete, Hostname: before.Hostname, Serial: before.Serial})
 
 		w.Write([]byte("Ok"))
 		break
 	}
 }
 
+// legacyStatus returns the status code the unversioned API used for an addDevice error. Conflicts
+// are only reported as such by /api/v1
+func legacyStatus(status int) int {
+	if status == http.StatusConflict {
+		return http.StatusBadRequest
+	}
+	return status
+}
+
+// addDevice validates and stores a new device, then regenerates the DHCP configuration.
+// If the device cannot be added, the HTTP status code to return and the error are given back
+func (n deviceController) addDevice(session storeSession, device *model.Device) (int, error) {
+	dbCollection := session.DB("ztpDashboard").C("device")
+
+	// The lock is held until the device is stored, so a manual fixed IP cannot pass the uniqueness
+	// check while an allocation picks the same address
+	ipamMutex.Lock()
+	defer ipamMutex.Unlock()
+
+	// Allocate the next free address of the scope when no fixed IP is given
+	if device.Fixedip == "" {
+		fixedIP, err := scopeCtl.AllocateIP(session, device.Scope)
+		if err != nil {
+			Log.Error("addDevice (allocate fixed IP)", F("error", err))
+			return http.StatusBadRequest, errors.New("Cannot allocate fixed IP: " + err.Error())
+		}
+		device.Fixedip = fixedIP
+	}
+
+	// Check if the name has been used before
+	count, err := dbCollection.Find(bson.M{"hostname": device.Hostname}).Count()
+	if err != nil {
+		Log.Error("addDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if count > 0 {
+		return http.StatusConflict, errors.New("Hostname " + device.Hostname + " already in use")
+	}
+
+	// Check if the serial has been used before. Devices bound to a switch port can omit it
+	if device.Serial != "" {
+		count, err = dbCollection.Find(bson.M{"serial": device.Serial}).Count()
+		if err != nil {
+			Log.Error("addDevice (read database)", F("error", err))
+			return http.StatusInternalServerError, err
+		}
+		if count > 0 {
+			return http.StatusConflict, errors.New("Serial " + device.Serial + " already in use")
+		}
+	}
+
+	// Check the switch port binding
+	message, err := n.checkPortBinding(dbCollection, device)
+	if err != nil {
+		Log.Error("addDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if message != "" {
+		return http.StatusBadRequest, errors.New(message)
+	}
+
+	// Check the DHCP scope
+	message, err = n.checkScope(session, device)
+	if err != nil {
+		Log.Error("addDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if message != "" {
+		return http.StatusBadRequest, errors.New(message)
+	}
+
+	// Check if the fixed IP has been used before
+	count, err = dbCollection.Find(bson.M{"fixedip": device.Fixedip}).Count()
+	if err != nil {
+		Log.Error("addDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if count > 0 {
+		return http.StatusConflict, errors.New("Fixed IP " + device.Fixedip + " already in use")
+	}
+
+	// Insert new device in Database
+	err = dbCollection.Insert(&device)
+	if err != nil {
+		Log.Error("addDevice (insert database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	recordDeviceStatus(*device, deviceAddedDetail)
+
+	// Regenerate config file and restart dhcp service
+	go dhcpController.GenerateConfigFiles()
+
+	// Send notification
+	Notify(Notification{Type: model.NotifyDeviceCreate, Hostname: device.Hostname, Serial: device.Serial})
+	return http.StatusOK, nil
+}
+
+// updateDevice validates and stores the new attributes of an existing device, then regenerates the
+// DHCP configuration. The hostname identifies the device and cannot be changed. If the device
+// cannot be updated, the HTTP status code to return and the error are given back
+func (n deviceController) updateDevice(session storeSession, current model.Device, device *model.Device) (int, error) {
+	dbCollection := session.DB("ztpDashboard").C("device")
+	if device.Hostname != current.Hostname {
+		return http.StatusBadRequest, errors.New("Hostname cannot be changed")
+	}
+
+	// Check if the serial is used by another device
+	if device.Serial != "" && device.Serial != current.Serial {
+		count, err := dbCollection.Find(bson.M{"serial": device.Serial, "hostname": bson.M{"$ne": device.Hostname}}).Count()
+		if err != nil {
+			Log.Error("updateDevice (read database)", F("error", err))
+			return http.StatusInternalServerError, err
+		}
+		if count > 0 {
+			return http.StatusConflict, errors.New("Serial " + device.Serial + " already in use")
+		}
+	}
+
+	// Check the switch port binding
+	message, err := n.checkPortBinding(dbCollection, device)
+	if err != nil {
+		Log.Error("updateDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if message != "" {
+		return http.StatusBadRequest, errors.New(message)
+	}
+
+	// Check the DHCP scope
+	message, err = n.checkScope(session, device)
+	if err != nil {
+		Log.Error("updateDevice (read database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+	if message != "" {
+		return http.StatusBadRequest, errors.New(message)
+	}
+
+	// Check if the fixed IP is used by another device
+	if device.Fixedip != current.Fixedip {
+		ipamMutex.Lock()
+		defer ipamMutex.Unlock()
+		count, err := dbCollection.Find(bson.M{"fixedip": device.Fixedip, "hostname": bson.M{"$ne": device.Hostname}}).Count()
+		if err != nil {
+			Log.Error("updateDevice (read database)", F("error", err))
+			return http.StatusInternalServerError, err
+		}
+		if count > 0 {
+			return http.StatusConflict, errors.New("Fixed IP " + device.Fixedip + " already in use")
+		}
+	}
+
+	err = dbCollection.Update(bson.M{"hostname": device.Hostname}, device)
+	if err != nil {
+		Log.Error("updateDevice (update database)", F("error", err))
+		return http.StatusInternalServerError, err
+	}
+
+	// Regenerate config file and restart dhcp service
+	go dhcpController.GenerateConfigFiles()
+
+	// Send notification
+	Notify(Notification{Type: model.NotifyDeviceUpdate, Hostname: device.Hostname, Serial: device.Serial})
+	return http.StatusOK, nil
+}
+
 // handleAPIDeviceTypes return a list of device types from the database
 func (n deviceController) handleAPIDeviceTypes(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -365,7 +716,7 @@ func (n deviceController) handleAPIDeviceTypes(w http.ResponseWriter, r *http.Re
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDeviceTypes (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDeviceTypes (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
@@ -376,7 +727,7 @@ func (n deviceController) handleAPIDeviceTypes(w http.ResponseWriter, r *http.Re
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIDeviceTypes (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIDeviceTypes (read database)", F("error", err))
 			return
 		}
 		// If result is nil, return an empty slice
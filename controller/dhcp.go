@@ -3,11 +3,13 @@ package controller
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/CiscoSE/ztp-dashboard/model"
 	"github.com/asaskevich/govalidator"
@@ -17,20 +19,35 @@ type DhcpController struct {
 	db                   dbController
 	DhcpTemplate         string
 	Dhcp6Template        string
+	DhcpSubnetTemplate   string
+	Dhcp6SubnetTemplate  string
 	DhcpXRHostsTemplate  string
 	DhcpNXHostsTemplate  string
 	Dhcp6XRHostsTemplate string
 	Dhcp6NXHostsTemplate string
 	interfacesCtl        interfaceController
+	scopeCtl             scopeController
 }
 
 type DhcpConfig struct {
-	ServerIP        string
-	DhcpDomain      string
-	DhcpNameServers string
-	DhcpSubnet      string
-	DhcpNetmask     string
-	Hosts           string
+	Subnets string
+}
+
+// DhcpSubnetConfig holds the values of a single subnet block of the DHCP configuration
+type DhcpSubnetConfig struct {
+	Name             string
+	Site             string
+	Network          string
+	Netmask          string
+	Gateway          string
+	Domain           string
+	NameServers      string
+	ServerIP         string
+	DefaultLeaseTime int
+	MaxLeaseTime     int
+	RangeStart       string
+	RangeEnd         string
+	Hosts            string
 }
 
 type DhcpHostConfig struct {
@@ -40,78 +57,184 @@ type DhcpHostConfig struct {
 	FQDN         string
 	BootFile     string
 	ScriptFile   string
+	CircuitID    string
+	RemoteID     string
+	InterfaceID  string
+}
+
+// executeTemplate renders the given template file with the values provided
+func (d DhcpController) executeTemplate(templateFile string, data interface{}) (string, error) {
+	t, err := template.ParseFiles(templateFile)
+	if err != nil {
+		return "", err
+	}
+	buf1 := new(bytes.Buffer)
+	err = t.Execute(buf1, data)
+	if err != nil {
+		return "", err
+	}
+	return buf1.String(), nil
+}
+
+// serverIPForScope returns the address devices in the scope use to reach the ZTP server
+func (d DhcpController) serverIPForScope(scope model.Scope, localServerIPv4 string, localServerIPv6 string) string {
+	if scope.ZtpServer != "" {
+		return scope.ZtpServer
+	}
+	if IsIPv6Scope(scope) {
+		return localServerIPv6
+	}
+	return localServerIPv4
+}
+
+// subnetConfig builds the values for the subnet block of the scope
+func (d DhcpController) subnetConfig(scope model.Scope, serverIP string, hosts string) (*DhcpSubnetConfig, error) {
+	network, err := ScopeNetwork(scope)
+	if err != nil {
+		return nil, err
+	}
+	subnet := &DhcpSubnetConfig{
+		Name:             scope.Name,
+		Site:             scope.Site,
+		Gateway:          scope.Gateway,
+		Domain:           scope.Domain,
+		NameServers:      strings.Join(scope.NameServers, ", "),
+		ServerIP:         serverIP,
+		DefaultLeaseTime: scope.DefaultLeaseTime,
+		MaxLeaseTime:     scope.MaxLeaseTime,
+		RangeStart:       scope.DynamicRange.Start,
+		RangeEnd:         scope.DynamicRange.End,
+		Hosts:            hosts,
+	}
+	if subnet.DefaultLeaseTime == 0 {
+		subnet.DefaultLeaseTime = defaultLeaseTime
+	}
+	if subnet.MaxLeaseTime == 0 {
+		subnet.MaxLeaseTime = defaultMaxLeaseTime
+	}
+	if network.IP.To4() == nil {
+		// DHCPv6 subnets are written in CIDR notation
+		subnet.Network = network.String()
+	} else {
+		subnet.Network = network.IP.String()
+		subnet.Netmask = net.IP(network.Mask).String()
+	}
+	return subnet, nil
 }
 
+// GenerateConfigFiles writes the DHCP configurations and day 0 scripts of all the devices, then
+// restarts the DHCP services. The result is published as a dhcp.apply event
 func (d DhcpController) GenerateConfigFiles() {
 	var devices []model.Device
-	dhcpHosts := ""
-	dhcp6Hosts := ""
+
+	// Errors are logged and reported in the event
+	started := time.Now()
+	applyErrors := []string{}
+	logError := func(message string) {
+		applyErrors = append(applyErrors, message)
+		Log.Error(message)
+	}
+	defer func() {
+		d.finishApply(started, applyErrors)
+	}()
 
 	// Open database
 	session, err := d.db.OpenSession()
 	if err != nil {
-		go CustomLog("GenerateConfigFiles (open database): "+err.Error(), ErrorSeverity)
+		logError("GenerateConfigFiles (open database): " + err.Error())
 		return
 	}
 	defer session.Close()
 	dbCollection := session.DB("ztpDashboard").C("device")
 	err = dbCollection.Find(nil).All(&devices)
 	if err != nil {
-		go CustomLog("GenerateConfigFiles (read database): "+err.Error(), ErrorSeverity)
+		logError("GenerateConfigFiles (read database): " + err.Error())
 	}
 
-	localServerIPv4, err := d.interfacesCtl.GetFirstIPv4()
+	scopes, err := d.scopeCtl.GetScopes()
 	if err != nil {
-		go CustomLog("GenerateConfigFiles (get IPv4 address): "+err.Error(), ErrorSeverity)
+		logError("GenerateConfigFiles (read scopes): " + err.Error())
 	}
-	if localServerIPv4 == "" {
-		go CustomLog("GenerateConfigFiles (IPv4 address empty)", ErrorSeverity)
+	// Default scopes from environment variables hold devices without scope assigned
+	defaultScopeV4, defaultScopeV6 := d.scopeCtl.defaultScopes()
+	if defaultScopeV4 != nil {
+		scopes = append(scopes, *defaultScopeV4)
 	}
-	localServerIPv6, err := d.interfacesCtl.GetFirstIPv6()
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (get IPv6 address): "+err.Error(), ErrorSeverity)
+	if defaultScopeV6 != nil {
+		scopes = append(scopes, *defaultScopeV6)
 	}
-	if localServerIPv4 == "" {
-		go CustomLog("GenerateConfigFiles (IPv6 address empty): "+err.Error(), ErrorSeverity)
+
+	// A server address is only needed for the families that have scopes, so hosts with IPv4 or
+	// IPv6 only do not report errors
+	hasIPv4Scopes, hasIPv6Scopes := false, false
+	for _, scope := range scopes {
+		if IsIPv6Scope(scope) {
+			hasIPv6Scopes = true
+		} else {
+			hasIPv4Scopes = true
+		}
+	}
+	localServerIPv4, err := d.interfacesCtl.GetFirstIPv4()
+	if err != nil && hasIPv4Scopes {
+		logError("GenerateConfigFiles (get IPv4 address): " + err.Error())
+	}
+	localServerIPv6, err := d.interfacesCtl.GetFirstIPv6()
+	if err != nil && hasIPv6Scopes {
+		logError("GenerateConfigFiles (get IPv6 address): " + err.Error())
 	}
 
 	err = scriptCtl.RemoveAllScripts()
 	if err != nil {
-		go CustomLog("GenerateConfigFiles (clean script directory): "+err.Error(), ErrorSeverity)
+		logError("GenerateConfigFiles (clean script directory): " + err.Error())
 	}
+
+	// Host declarations are grouped by scope
+	scopeHosts := make(map[string]string)
 	for _, item := range devices {
+		scope := d.scopeCtl.ScopeForDevice(item, scopes)
+		if scope == nil {
+			logError("GenerateConfigFiles: no scope found for device " + item.Hostname)
+			continue
+		}
+		serverIP := d.serverIPForScope(*scope, localServerIPv4, localServerIPv6)
+
 		if item.DeviceType.Name == "iOS-XR" {
-			scriptCtl.GenerateXRZtpScript(item, govalidator.IsIPv6(item.Fixedip))
+			scriptCtl.GenerateXRZtpScript(item, serverIP)
 		} else if item.DeviceType.Name == "NX-OS" {
-			scriptCtl.GenerateNXPoapScript(item, govalidator.IsIPv6(item.Fixedip))
+			scriptCtl.GenerateNXPoapScript(item, serverIP)
 		}
 		var hostTemplate string
 		dhcpHost := &DhcpHostConfig{}
 
 		if govalidator.IsIPv6(item.Fixedip) {
-			clientID := "00:02:00:00:00:09:"
-			for _, element := range item.Serial {
-				h := fmt.Sprintf("%X", element)
-				clientID += h + ":"
+			clientID := ""
+			if item.Serial != "" {
+				clientID = "00:02:00:00:00:09:"
+				for _, element := range item.Serial {
+					h := fmt.Sprintf("%X", element)
+					clientID += h + ":"
+				}
+				clientID += "00"
 			}
-			clientID += "00"
 			if item.DeviceType.Name == "iOS-XR" {
 				hostTemplate = d.Dhcp6XRHostsTemplate
 				dhcpHost = &DhcpHostConfig{
 					HostName:     item.Hostname,
 					ClientID:     clientID,
-					FQDN:         item.Hostname + "." + os.Getenv("DHCP_DOMAIN"),
-					BootFile:     "http://[" + localServerIPv6 + "]:" + os.Getenv("APP_WEB_PORT") + item.Image.Locationurl,
-					ScriptFile:   "http://[" + localServerIPv6 + "]:" + os.Getenv("APP_WEB_PORT") + item.Config.Locationurl,
+					FQDN:         item.Hostname + "." + scope.Domain,
+					BootFile:     deviceBootURL(item, serverIP) + item.Image.Locationurl,
+					ScriptFile:   deviceBootURL(item, serverIP) + item.Config.Locationurl,
 					FixedAddress: item.Fixedip,
+					InterfaceID:  item.InterfaceID,
 				}
 			} else if item.DeviceType.Name == "NX-OS" {
 				hostTemplate = d.Dhcp6NXHostsTemplate
 				dhcpHost = &DhcpHostConfig{
 					HostName:     item.Hostname,
 					ClientID:     clientID,
-					ScriptFile:   "/tftboot/public/scripts/" + item.Serial + ".py",
+					ScriptFile:   "/tftboot/public/scripts/" + item.ScriptName() + ".py",
 					FixedAddress: item.Fixedip,
+					InterfaceID:  item.InterfaceID,
 				}
 			}
 		} else {
@@ -121,101 +244,118 @@ func (d DhcpController) GenerateConfigFiles() {
 				dhcpHost = &DhcpHostConfig{
 					HostName:     item.Hostname,
 					ClientID:     clientID,
-					FQDN:         item.Hostname + "." + os.Getenv("DHCP_DOMAIN"),
-					BootFile:     "http://" + localServerIPv4 + ":" + os.Getenv("APP_WEB_PORT") + item.Image.Locationurl,
-					ScriptFile:   "http://" + localServerIPv4 + ":" + os.Getenv("APP_WEB_PORT") + "/scripts/" + item.Serial + ".sh",
+					FQDN:         item.Hostname + "." + scope.Domain,
+					BootFile:     deviceBootURL(item, serverIP) + item.Image.Locationurl,
+					ScriptFile:   deviceBootURL(item, serverIP) + "/scripts/" + item.ScriptName() + ".sh",
 					FixedAddress: item.Fixedip,
+					CircuitID:    item.RelayCircuitID,
+					RemoteID:     item.RelayRemoteID,
 				}
 			} else if item.DeviceType.Name == "NX-OS" {
 				hostTemplate = d.DhcpNXHostsTemplate
 				dhcpHost = &DhcpHostConfig{
 					HostName:     item.Hostname,
 					ClientID:     clientID,
-					ScriptFile:   "public/scripts/" + item.Serial + ".py",
+					ScriptFile:   "public/scripts/" + item.ScriptName() + ".py",
 					FixedAddress: item.Fixedip,
+					CircuitID:    item.RelayCircuitID,
+					RemoteID:     item.RelayRemoteID,
 				}
 			}
 		}
 
-		t, err := template.ParseFiles(hostTemplate)
-
+		host, err := d.executeTemplate(hostTemplate, dhcpHost)
 		if err != nil {
-			go CustomLog("GenerateConfigFiles (parse hostTemplate): "+err.Error(), ErrorSeverity)
-
+			logError("GenerateConfigFiles (execute hostTemplate): " + err.Error())
+			continue
 		}
-		buf1 := new(bytes.Buffer)
-		err = t.Execute(buf1, dhcpHost)
+		scopeHosts[scope.Name+"/"+scope.Subnet] += host
+	}
+
+	// One subnet block per scope
+	dhcpSubnets := ""
+	dhcp6Subnets := ""
+	for _, scope := range scopes {
+		serverIP := d.serverIPForScope(scope, localServerIPv4, localServerIPv6)
+		subnet, err := d.subnetConfig(scope, serverIP, scopeHosts[scope.Name+"/"+scope.Subnet])
 		if err != nil {
-			go CustomLog("GenerateConfigFiles (execute hostTemplate): "+err.Error(), ErrorSeverity)
+			logError("GenerateConfigFiles (scope " + scope.Name + " subnet): " + err.Error())
+			continue
 		}
-		if govalidator.IsIPv6(item.Fixedip) {
-			dhcp6Hosts += buf1.String()
+		if IsIPv6Scope(scope) {
+			result, err := d.executeTemplate(d.Dhcp6SubnetTemplate, subnet)
+			if err != nil {
+				logError("GenerateConfigFiles (Execute Dhcp6 Subnet Template): " + err.Error())
+				continue
+			}
+			dhcp6Subnets += result
 		} else {
-			dhcpHosts += buf1.String()
+			result, err := d.executeTemplate(d.DhcpSubnetTemplate, subnet)
+			if err != nil {
+				logError("GenerateConfigFiles (execute dhcpSubnetTemplate): " + err.Error())
+				continue
+			}
+			dhcpSubnets += result
 		}
-
 	}
 
-	// DHCPv4
-	dhcpConfig := &DhcpConfig{
-		DhcpNameServers: os.Getenv("DHCP_NAMESERVERS"),
-		DhcpDomain:      os.Getenv("DHCP_DOMAIN"),
-		DhcpSubnet:      os.Getenv("DHCP_SUBNET"),
-		DhcpNetmask:     os.Getenv("DHCP_SUBNET_NETMASK"),
-		ServerIP:        localServerIPv4,
-		Hosts:           dhcpHosts,
-	}
-	t, err := template.ParseFiles(d.DhcpTemplate)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (parse dhcpTemplate): "+err.Error(), ErrorSeverity)
-	}
-	buf1 := new(bytes.Buffer)
-	err = t.Execute(buf1, dhcpConfig)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (execute dhcpTemplate): "+err.Error(), ErrorSeverity)
-	}
-	result := buf1.String()
-	err = ioutil.WriteFile(os.Getenv("DHCP_CONFIG_PATH"), []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (write dhcp.conf file): "+err.Error(), ErrorSeverity)
-	}
+	// The service of a family without scopes is left alone
+	if hasIPv4Scopes {
+		result, err := d.executeTemplate(d.DhcpTemplate, &DhcpConfig{Subnets: dhcpSubnets})
+		if err != nil {
+			logError("GenerateConfigFiles (execute dhcpTemplate): " + err.Error())
+		}
+		err = ioutil.WriteFile(os.Getenv("DHCP_CONFIG_PATH"), []byte(result), 0644)
+		if err != nil {
+			logError("GenerateConfigFiles (write dhcp.conf file): " + err.Error())
+		}
 
-	go CustomLog("Restarting DHCPv4 service using: "+os.Getenv("DHCP_SERVICE_RESTART_CMD"), DebugSeverity)
+		Log.Debug("Restarting DHCPv4 service", F("command", os.Getenv("DHCP_SERVICE_RESTART_CMD")))
 
-	_, err = exec.Command("bash", "-c", os.Getenv("DHCP_SERVICE_RESTART_CMD")).Output()
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (restart DHCP service): "+err.Error(), ErrorSeverity)
+		_, err = exec.Command("bash", "-c", os.Getenv("DHCP_SERVICE_RESTART_CMD")).Output()
+		if err != nil {
+			logError("GenerateConfigFiles (restart DHCP service): " + err.Error())
+		}
 	}
 
-	// DHCPv6
-	dhcp6Config := &DhcpConfig{
-		DhcpNameServers: os.Getenv("DHCP6_NAMESERVERS"),
-		DhcpDomain:      os.Getenv("DHCP6_DOMAIN"),
-		DhcpSubnet:      os.Getenv("DHCP6_SUBNET"),
-		DhcpNetmask:     os.Getenv("DHCP6_SUBNET_NETMASK"),
-		ServerIP:        localServerIPv6,
-		Hosts:           dhcp6Hosts,
-	}
-	t, err = template.ParseFiles(d.Dhcp6Template)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (Parse Dhcp6 Template): "+err.Error(), ErrorSeverity)
+	if hasIPv6Scopes {
+		result, err := d.executeTemplate(d.Dhcp6Template, &DhcpConfig{Subnets: dhcp6Subnets})
+		if err != nil {
+			logError("GenerateConfigFiles (Execute Dhcp6 Template): " + err.Error())
+		}
+		err = ioutil.WriteFile(os.Getenv("DHCP6_CONFIG_PATH"), []byte(result), 0644)
+		if err != nil {
+			logError("GenerateConfigFiles (wrote dhcp6 config file): " + err.Error())
+		}
+		Log.Debug("Restarting DHCPv6 service", F("command", os.Getenv("DHCP6_SERVICE_RESTART_CMD")))
 
+		_, err = exec.Command("bash", "-c", os.Getenv("DHCP6_SERVICE_RESTART_CMD")).Output()
+		if err != nil {
+			logError("GenerateConfigFiles (restart DHCP6 service): " + err.Error())
+		}
 	}
-	buf1 = new(bytes.Buffer)
-	err = t.Execute(buf1, dhcp6Config)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (Execute Dhcp6 Template): "+err.Error(), ErrorSeverity)
-	}
-	result = buf1.String()
-	err = ioutil.WriteFile(os.Getenv("DHCP6_CONFIG_PATH"), []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (wrote dhcp6 config file): "+err.Error(), ErrorSeverity)
-	}
-	go CustomLog("Restarting DHCPv6 service using:"+os.Getenv("DHCP6_SERVICE_RESTART_CMD"), DebugSeverity)
+}
 
-	_, err = exec.Command("bash", "-c", os.Getenv("DHCP6_SERVICE_RESTART_CMD")).Output()
-	if err != nil {
-		go CustomLog("GenerateConfigFiles (restart DHCP6 service): "+err.Error(), ErrorSeverity)
+// finishApply measures and publishes the result of a configuration regeneration
+func (d DhcpController) finishApply(started time.Time, applyErrors []string) {
+	recordDHCPApply(applyErrors)
+	dhcpRegenerations.Inc()
+	dhcpRegenerationDuration.Observe(time.Since(started).Seconds())
+	if len(applyErrors) > 0 {
+		dhcpRegenerationFailures.Inc()
 	}
 
+	event := model.Event{
+		Type:    model.EventDHCPApply,
+		Status:  "ok",
+		Message: "DHCP configuration applied in " + time.Since(started).Round(time.Millisecond).String(),
+	}
+	if len(applyErrors) > 0 {
+		event.Status = "failed"
+		event.Message = strings.Join(applyErrors, "; ")
+		go alertCtl.fireAlert(model.Alert{Key: dhcpApplyAlertKey, Name: model.AlertDHCPApplyFailed, Severity: "error", Summary: "DHCP configuration cannot be applied", Description: event.Message})
+	} else {
+		go alertCtl.resolveAlert(dhcpApplyAlertKey)
+	}
+	PublishEvent(event)
 }
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/gorilla/mux"
+)
+
+// eventHistorySize is the number of events kept to resume the streams of reconnecting clients
+const eventHistorySize = 256
+
+// eventHeartbeat is the interval of the comments sent to keep idle streams open through proxies
+const eventHeartbeat = 15 * time.Second
+
+// eventFilter selects the events sent to a subscriber. Empty fields match every event
+type eventFilter struct {
+	// device is a hostname or serial
+	device string
+	// types are event types or prefixes of event types, e.g. "device" or "device.status"
+	types []string
+}
+
+// matches tells if the event passes the filter
+func (f eventFilter) matches(event model.Event) bool {
+	if f.device != "" && f.device != event.Hostname && f.device != event.Serial {
+		return false
+	}
+	if len(f.types) == 0 {
+		return true
+	}
+	for _, eventType := range f.types {
+		if event.Type == eventType || strings.HasPrefix(event.Type, eventType+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// eventBus delivers the events published by the controllers to the subscribers of /api/events
+type eventBus struct {
+	mutex       sync.Mutex
+	lastID      int64
+	history     []model.Event
+	subscribers map[chan model.Event]eventFilter
+}
+
+// events is the event bus of the application
+var events = &eventBus{subscribers: make(map[chan model.Event]eventFilter)}
+
+// PublishEvent sends the event to the subscribers. Subscribers that do not keep up lose events
+// instead of blocking the publisher
+func PublishEvent(event model.Event) {
+	events.publish(event)
+}
+
+func (b *eventBus) publish(event model.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+	for subscriber, filter := range b.subscribers {
+		if !filter.matches(event) {
+			continue
+		}
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel with the events matching the filter. The events published after
+// lastID that are still in the history are sent first
+func (b *eventBus) subscribe(filter eventFilter, lastID int64) chan model.Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber := make(chan model.Event, eventHistorySize)
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID && filter.matches(event) {
+				subscriber <- event
+			}
+		}
+	}
+	b.subscribers[subscriber] = filter
+	return subscriber
+}
+
+// unsubscribe stops sending events to the channel
+func (b *eventBus) unsubscribe(subscriber chan model.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, subscriber)
+}
+
+// eventsController streams the events of the bus with Server-Sent Events
+type eventsController struct {
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (e eventsController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/events", e.handleAPIEvents)
+}
+
+// handleAPIEvents streams the events as Server-Sent Events until the client disconnects.
+// The device (hostname or serial) and type (comma separated types or prefixes) parameters filter
+// the events. Reconnecting clients get the events they missed with the Last-Event-ID header
+func (e eventsController) handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming not supported"))
+		return
+	}
+
+	filter := eventFilter{device: r.URL.Query().Get("device")}
+	if types := r.URL.Query().Get("type"); types != "" {
+		filter.types = strings.Split(types, ",")
+	}
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	subscriber := events.subscribe(filter, lastID)
+	defer events.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Ask nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			w.Write([]byte(": heartbeat\n\n"))
+		case event := <-subscriber:
+			js, err := json.Marshal(event)
+			if err != nil {
+				requestLog(r).Error("handleAPIEvents (encode json)", F("error", err))
+				continue
+			}
+			// The type is in the data, so EventSource clients get every event with onmessage
+			w.Write([]byte("id: " + strconv.FormatInt(event.ID, 10) + "\ndata: " + string(js) + "\n\n"))
+		}
+		flusher.Flush()
+	}
+}
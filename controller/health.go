@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// databaseRetryInterval is the wait between two attempts to initialize the database
+const databaseRetryInterval = 10 * time.Second
+
+// readinessTimeout limits the time spent reaching the database in a readiness check
+const readinessTimeout = 3 * time.Second
+
+// databaseReady is set to 1 once initDatabase succeeded
+var databaseReady int32
+
+// lastDHCPApply is the result of the last DHCP configuration regeneration. time is zero until the
+// first regeneration ends
+var lastDHCPApply struct {
+	sync.Mutex
+	time   time.Time
+	errors []string
+}
+
+// recordDHCPApply keeps the result of a regeneration for the readiness check
+func recordDHCPApply(applyErrors []string) {
+	lastDHCPApply.Lock()
+	defer lastDHCPApply.Unlock()
+	lastDHCPApply.time = time.Now()
+	lastDHCPApply.errors = applyErrors
+}
+
+// healthCheck is the result of one readiness check
+type healthCheck struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthReport is the body of /healthz and /readyz
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// healthController answers the liveness and readiness probes of load balancers and orchestrators
+type healthController struct {
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (h healthController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/healthz", h.handleHealthz)
+	r.HandleFunc("/readyz", h.handleReadyz)
+}
+
+// handleHealthz tells that the process is able to answer requests
+func (h healthController) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	h.writeReport(w, healthReport{Status: "ok"})
+}
+
+// handleReadyz checks the dependencies needed to provision devices. The status code is 503 if
+// any check fails, and the body has the result of each check
+func (h healthController) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{
+		Status: "ok",
+		Checks: map[string]healthCheck{
+			"database":          h.checkDatabase(),
+			"templates":         h.checkTemplates(),
+			"publicDirectories": h.checkPublicDirectories(),
+			"serverAddress":     h.checkServerAddress(),
+			"dhcp":              h.checkDHCP(),
+		},
+	}
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "failed"
+		}
+	}
+	h.writeReport(w, report)
+}
+
+// writeReport sends the report, with a 503 status code if it failed
+func (h healthController) writeReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// failedCheck returns a failed check with the messages
+func failedCheck(messages []string) healthCheck {
+	return healthCheck{Status: "failed", Message: strings.Join(messages, "; ")}
+}
+
+// checkDatabase counts the device types through a new session and checks that the database has
+// been initialized. The check fails if the store does not answer within readinessTimeout
+func (h healthController) checkDatabase() healthCheck {
+	result := make(chan error, 1)
+	go func() {
+		session, err := openDBSession()
+		if err != nil {
+			result <- errors.New("Cannot connect: " + err.Error())
+			return
+		}
+		defer session.Close()
+		_, err = session.DB("ztpDashboard").C("deviceType").Count()
+		if err != nil {
+			result <- errors.New("Query failed: " + err.Error())
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return failedCheck([]string{err.Error()})
+		}
+	case <-time.After(readinessTimeout):
+		return failedCheck([]string{"No answer after " + readinessTimeout.String()})
+	}
+	if atomic.LoadInt32(&databaseReady) == 0 {
+		return failedCheck([]string{"Device types and admin user not created yet"})
+	}
+	return healthCheck{Status: "ok"}
+}
+
+// checkTemplates checks that the template directories can be read
+func (h healthController) checkTemplates() healthCheck {
+	messages := []string{}
+	for _, directory := range []string{"htmlTemplates", "dhcpConfTemplates", "shellTemplates", "pythonTemplates"} {
+		_, err := ioutil.ReadDir(basePath + "/" + directory)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return failedCheck(messages)
+	}
+	return healthCheck{Status: "ok"}
+}
+
+// checkPublicDirectories checks that the files served to devices can be written
+func (h healthController) checkPublicDirectories() healthCheck {
+	messages := []string{}
+	for directory := range downloadStatuses {
+		file, err := ioutil.TempFile(basePath+"/public/"+directory, ".readyz")
+		if err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		file.Close()
+		os.Remove(file.Name())
+	}
+	if len(messages) > 0 {
+		return failedCheck(messages)
+	}
+	return healthCheck{Status: "ok"}
+}
+
+// checkServerAddress checks that devices can be given an address to reach this server
+func (h healthController) checkServerAddress() healthCheck {
+	ipv4, _ := dhcpController.interfacesCtl.GetFirstIPv4()
+	ipv6, _ := dhcpController.interfacesCtl.GetFirstIPv6()
+	if ipv4 == "" && ipv6 == "" {
+		return failedCheck([]string{"No IPv4 or IPv6 address found on the server interfaces"})
+	}
+	addresses := []string{}
+	for _, ip := range []string{ipv4, ipv6} {
+		if ip != "" {
+			addresses = append(addresses, ip)
+		}
+	}
+	return healthCheck{Status: "ok", Message: strings.Join(addresses, ", ")}
+}
+
+// checkDHCP checks the result of the last DHCP configuration regeneration
+func (h healthController) checkDHCP() healthCheck {
+	lastDHCPApply.Lock()
+	defer lastDHCPApply.Unlock()
+	if lastDHCPApply.time.IsZero() {
+		return failedCheck([]string{"DHCP configuration not applied yet"})
+	}
+	applied := "Applied at " + lastDHCPApply.time.UTC().Format(time.RFC3339)
+	if len(lastDHCPApply.errors) > 0 {
+		return failedCheck(append([]string{applied + " with errors"}, lastDHCPApply.errors...))
+	}
+	return healthCheck{Status: "ok", Message: applied}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+func TestReadyzIPv4Only(t *testing.T) {
+	resetTestStore(t)
+	dir := t.TempDir()
+	t.Setenv("DHCP_SUBNET", "")
+	t.Setenv("DHCP6_SUBNET", "")
+	t.Setenv("DHCP_CONFIG_PATH", dir+"/dhcpd.conf")
+	t.Setenv("DHCP_SERVICE_RESTART_CMD", "true")
+	t.Setenv("DHCP6_CONFIG_PATH", dir+"/dhcpd6.conf")
+	t.Setenv("DHCP6_SERVICE_RESTART_CMD", "false")
+	addTestScope(t, model.Scope{Name: "readyz", Subnet: "192.0.2.0/24", Gateway: "192.0.2.1"})
+
+	// The server has no IPv6 address and no IPv6 scope, so DHCPv6 is not needed
+	d := dhcpController
+	d.DhcpTemplate = "../dhcpConfTemplates/dhcpd.conf"
+	d.DhcpSubnetTemplate = "../dhcpConfTemplates/dhcpSubnet.conf"
+	d.Dhcp6Template = "../dhcpConfTemplates/dhcpd6.conf"
+	d.Dhcp6SubnetTemplate = "../dhcpConfTemplates/dhcp6Subnet.conf"
+	d.GenerateConfigFiles()
+
+	config, err := ioutil.ReadFile(dir + "/dhcpd.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "192.0.2.0") {
+		t.Errorf("DHCPv4 configuration has no subnet for the scope:\n%s", config)
+	}
+	if _, err := os.Stat(dir + "/dhcpd6.conf"); !os.IsNotExist(err) {
+		t.Errorf("DHCPv6 configuration written without IPv6 scope: %v", err)
+	}
+
+	resp, err := http.Get(newTestServer(t, nil).URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report healthReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"serverAddress", "dhcp"} {
+		if check := report.Checks[name]; check.Status != "ok" {
+			t.Errorf("%s check is %+v, want ok", name, check)
+		}
+	}
+}
+
+func TestReadyzDatabase(t *testing.T) {
+	resetTestStore(t)
+	ready := atomic.LoadInt32(&databaseReady)
+	t.Cleanup(func() { atomic.StoreInt32(&databaseReady, ready) })
+
+	// The check reads the store the controllers use
+	atomic.StoreInt32(&databaseReady, 0)
+	if check := healthCtl.checkDatabase(); check.Status != "failed" || check.Message != "Device types and admin user not created yet" {
+		t.Errorf("database check before initialization is %+v", check)
+	}
+	atomic.StoreInt32(&databaseReady, 1)
+	if check := healthCtl.checkDatabase(); check.Status != "ok" {
+		t.Errorf("database check is %+v, want ok", check)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strings"
 
@@ -37,37 +36,14 @@ func (i imageController) handleImageFiles(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
-		CustomLog("handleImageFiles (reading image file): "+err.Error(), ErrorSeverity)
+		requestLog(r).Error("handleImageFiles (reading image file)", F("error", err))
 		return
 	}
 
-	var device model.Device
-	// Open database
-	session, err := i.db.OpenSession()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		go CustomLog("handleImageFiles (open database): "+err.Error(), ErrorSeverity)
-		return
-	}
-	defer session.Close()
-	dbCollection := session.DB("ztpDashboard").C("device")
+	// Update the device status
+	updateDownloadStatus(remoteIP, "images", requestVars["imageName"])
 
-	// If device not found log the error and continue. Otherwhise update database
-	err = dbCollection.Find(bson.M{"fixedip": remoteIP}).One(&device)
-	if err != nil {
-		go CustomLog("handleImageFiles (Find request device): "+remoteIP+" "+err.Error(), DebugSeverity)
-	} else {
-		// Only do update if device status is different from desired
-		if device.Status != "Installing image" {
-			go CustomLog("handleImageFiles: Updating device "+device.Hostname+" (serial "+device.Serial+") status to 'Installing image'", DebugSeverity)
-			device.Status = "Installing image"
-			dbCollection.Update(bson.M{"fixedip": remoteIP}, &device)
-			// Notify status change
-			go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") is installing image " + requestVars["imageName"])
-		}
-	}
-	w.Write(content)
+	writeDownload(w, "images", content)
 }
 
 // handleConfig will be executed when a request to /ng/images is done
@@ -96,10 +72,10 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 
 		file, _, err := r.FormFile("file")
 		if err != nil {
-			log.Print(err.Error())
+			requestLog(r).Debug("handleAPIImages (read form file)", F("error", err))
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (retrieve file from request): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (retrieve file from request)", F("error", err))
 			return
 		}
 		defer file.Close()
@@ -109,7 +85,7 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (save image file): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (save image file)", F("error", err))
 			return
 		}
 
@@ -118,7 +94,7 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
@@ -133,7 +109,7 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (read database)", F("error", err))
 			return
 		}
 		if deviceTypesCount == 0 {
@@ -149,7 +125,7 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (read database)", F("error", err))
 			return
 		}
 		if count > 0 {
@@ -172,9 +148,11 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (insert database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (insert database)", F("error", err))
 			return
 		}
+		auditCtl.Record(r, model.AuditCreate, "image", image.Name, nil, image)
+		Notify(Notification{Type: model.NotifyImageCreate, Object: image.Name, Detail: image.DeviceType.Name})
 
 		// Return ok message
 		w.Write([]byte("ok"))
@@ -182,30 +160,40 @@ func (i imageController) handleAPIImages(w http.ResponseWriter, r *http.Request)
 	// If method is GET, return all objects
 	case http.MethodGet:
 
-		var configs []model.Config
+		var images []model.Image
+
+		// Filter, sort and page requested
+		query, err := parseListQuery(r, imageListFields)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
 
 		// Open database
 		session, err := i.db.OpenSession()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 		dbCollection := session.DB("ztpDashboard").C("image")
 
-		err = dbCollection.Find(nil).All(&configs)
+		total, err := query.run(dbCollection, &images)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPIImages (read database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPIImages (read database)", F("error", err))
+			return
 		}
-		if configs == nil {
-			configs = []model.Config{}
+		if images == nil {
+			images = []model.Image{}
 		}
+		setListHeaders(w, total)
 		enc := json.NewEncoder(w)
-		enc.Encode(configs)
+		enc.Encode(images)
 
 		break
 	}
@@ -10,11 +10,14 @@ import (
 type interfaceController struct {
 }
 
-func (i interfaceController) GetFirstIPv4() (string, error) {
+// localAddresses returns the addresses of the interfaces that are up, except loopbacks. The tests
+// replace it to run as on a host with given addresses
+var localAddresses = func() ([]net.IP, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	ips := []net.IP{}
 	for _, iface := range ifaces {
 		if iface.Flags&net.FlagUp == 0 {
 			continue // interface down
@@ -24,7 +27,7 @@ func (i interfaceController) GetFirstIPv4() (string, error) {
 		}
 		addrs, err := iface.Addrs()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		for _, addr := range addrs {
 			var ip net.IP
@@ -37,51 +40,40 @@ func (i interfaceController) GetFirstIPv4() (string, error) {
 			if ip == nil || ip.IsLoopback() {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil {
-				continue // not an ipv4 address
-			}
-			return ip.String(), nil
+			ips = append(ips, ip)
 		}
 	}
+	return ips, nil
+}
+
+func (i interfaceController) GetFirstIPv4() (string, error) {
+	ips, err := localAddresses()
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		ip = ip.To4()
+		if ip == nil {
+			continue // not an ipv4 address
+		}
+		return ip.String(), nil
+	}
 	return "", errors.New("No IPv4 address found")
 }
 
 func (i interfaceController) GetFirstIPv6() (string, error) {
-	ifaces, err := net.Interfaces()
+	ips, err := localAddresses()
 	if err != nil {
 		return "", err
 	}
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 {
-			continue // interface down
-		}
-		if iface.Flags&net.FlagLoopback != 0 {
-			continue // loopback interface
-		}
-		addrs, err := iface.Addrs()
-		if err != nil {
-			return "", err
-		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.IsLoopback() {
+	for _, ip := range ips {
+		isIPv6 := ip.To4()
+		if isIPv6 == nil {
+			if strings.HasPrefix(ip.String(), "fe80") {
 				continue
 			}
-			isIPv6 := ip.To4()
-			if isIPv6 == nil {
-				if strings.HasPrefix(ip.String(), "fe80") {
-					continue
-				}
-				// IPv6 global address
-				return ip.String(), nil
-			}
+			// IPv6 global address
+			return ip.String(), nil
 		}
 	}
 	return "", errors.New("No IPv6 address found")
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ipamMutex serializes address allocations and fixed IP uniqueness checks so two devices created
+// at the same time do not get the same address. It must be held until the device is stored in database
+var ipamMutex sync.Mutex
+
+// ipamMaxCandidates limits the addresses checked by an allocation, so a large IPv6 scope full of
+// devices does not hold ipamMutex for long. Exclusion and dynamic ranges are skipped as a whole
+const ipamMaxCandidates = 65536
+
+// nextIP returns the address that follows ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// normalizeIP returns the 4 bytes form for IPv4 addresses and 16 bytes form for IPv6 addresses,
+// so addresses can be compared byte by byte
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// ipInRange tells if ip is between the start and end addresses of the range
+func ipInRange(ip net.IP, ipRange model.IPRange) bool {
+	start := net.ParseIP(ipRange.Start)
+	end := net.ParseIP(ipRange.End)
+	if start == nil || end == nil {
+		return false
+	}
+	ip, start, end = normalizeIP(ip), normalizeIP(start), normalizeIP(end)
+	if len(ip) != len(start) || len(ip) != len(end) {
+		return false
+	}
+	return bytes.Compare(ip, start) >= 0 && bytes.Compare(ip, end) <= 0
+}
+
+// isBroadcast tells if ip is the broadcast address of an IPv4 network
+func isBroadcast(ip net.IP, network *net.IPNet) bool {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return false
+	}
+	for i := range ip4 {
+		if ip4[i]|network.Mask[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// isReservedIP tells if ip cannot be given to a device because it is the network or broadcast
+// address, the gateway or the ZTP server of the scope, or it is inside an exclusion range or the
+// dynamic range
+func isReservedIP(ip net.IP, network *net.IPNet, scope model.Scope) bool {
+	if ip.Equal(network.IP) || isBroadcast(ip, network) {
+		return true
+	}
+	if ip.Equal(net.ParseIP(scope.Gateway)) || ip.Equal(net.ParseIP(scope.ZtpServer)) {
+		return true
+	}
+	for _, exclusion := range scope.Exclusions {
+		if ipInRange(ip, exclusion) {
+			return true
+		}
+	}
+	return ipInRange(ip, scope.DynamicRange)
+}
+
+// reservedRangeEnd returns the last address of the exclusion or dynamic ranges containing ip, or
+// nil if ip is not inside a range
+func reservedRangeEnd(ip net.IP, scope model.Scope) net.IP {
+	var end net.IP
+	for _, ipRange := range append([]model.IPRange{scope.DynamicRange}, scope.Exclusions...) {
+		if !ipInRange(ip, ipRange) {
+			continue
+		}
+		rangeEnd := normalizeIP(net.ParseIP(ipRange.End))
+		if end == nil || bytes.Compare(rangeEnd, end) > 0 {
+			end = rangeEnd
+		}
+	}
+	return end
+}
+
+// validateRanges checks that the exclusion and dynamic ranges of the scope are inside its subnet.
+// An error message is returned if a range is not valid
+func validateRanges(scope model.Scope, network *net.IPNet) string {
+	ranges := append([]model.IPRange{}, scope.Exclusions...)
+	if scope.DynamicRange.Start != "" || scope.DynamicRange.End != "" {
+		ranges = append(ranges, scope.DynamicRange)
+	}
+	for _, ipRange := range ranges {
+		start := net.ParseIP(ipRange.Start)
+		end := net.ParseIP(ipRange.End)
+		if start == nil || end == nil || !network.Contains(start) || !network.Contains(end) {
+			return "Range " + ipRange.Start + " - " + ipRange.End + " is not inside subnet " + scope.Subnet
+		}
+		if bytes.Compare(normalizeIP(start), normalizeIP(end)) > 0 {
+			return "Range " + ipRange.Start + " - " + ipRange.End + " starts after it ends"
+		}
+	}
+	return ""
+}
+
+// findScope returns the scope with the given name. An empty name selects the default IPv4 scope
+func (s scopeController) findScope(session storeSession, scopeName string) (*model.Scope, error) {
+	if scopeName == "" {
+		defaultScope, _ := s.defaultScopes()
+		if defaultScope == nil {
+			return nil, errors.New("No scope assigned and no default scope configured")
+		}
+		return defaultScope, nil
+	}
+	var scope model.Scope
+	err := session.DB("ztpDashboard").C("scope").Find(bson.M{"name": scopeName}).One(&scope)
+	if err == mgo.ErrNotFound {
+		return nil, errors.New("Scope " + scopeName + " not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &scope, nil
+}
+
+// AllocateIP returns the first free address of the scope. Addresses of deleted devices become free
+// again since only the devices in database are taken as reserved. ipamMutex must be held by the
+// caller until the device is inserted
+func (s scopeController) AllocateIP(session storeSession, scopeName string) (string, error) {
+	scope, err := s.findScope(session, scopeName)
+	if err != nil {
+		return "", err
+	}
+	network, err := ScopeNetwork(*scope)
+	if err != nil {
+		return "", err
+	}
+
+	// Addresses already reserved by devices
+	var devices []model.Device
+	err = session.DB("ztpDashboard").C("device").Find(nil).Select(bson.M{"fixedip": 1}).All(&devices)
+	if err != nil {
+		return "", err
+	}
+	used := make(map[string]bool)
+	for _, device := range devices {
+		if ip := net.ParseIP(device.Fixedip); ip != nil {
+			used[ip.String()] = true
+		}
+	}
+
+	ip := nextIP(normalizeIP(network.IP))
+	for candidates := 0; network.Contains(ip); candidates++ {
+		if candidates == ipamMaxCandidates {
+			return "", errors.New("No free address found in the first " + strconv.Itoa(ipamMaxCandidates) + " addresses of scope " + scope.Name)
+		}
+		// Ranges are skipped at once, they can hold most of an IPv6 subnet
+		if end := reservedRangeEnd(ip, *scope); end != nil {
+			ip = nextIP(end)
+			continue
+		}
+		if isReservedIP(ip, network, *scope) || used[ip.String()] {
+			ip = nextIP(ip)
+			continue
+		}
+		return ip.String(), nil
+	}
+	return "", errors.New("No free addresses left in scope " + scope.Name)
+}
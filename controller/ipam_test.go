@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// addTestScope stores the scope and devices with the fixed IPs
+func addTestScope(t *testing.T, scope model.Scope, fixedIPs ...string) storeSession {
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(session.Close)
+	err = session.DB("ztpDashboard").C("scope").Insert(&scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixedIP := range fixedIPs {
+		err = session.DB("ztpDashboard").C("device").Insert(&model.Device{Hostname: scope.Name + "-" + fixedIP, Fixedip: fixedIP, Scope: scope.Name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return session
+}
+
+func TestAllocateIPSkipsRanges(t *testing.T) {
+	resetTestStore(t)
+
+	// The dynamic range covers almost 2^48 addresses, followed by an exclusion
+	session := addTestScope(t, model.Scope{
+		Name:         "ipam-v6",
+		Subnet:       "2001:db8::/64",
+		DynamicRange: model.IPRange{Start: "2001:db8::1", End: "2001:db8::ffff:ffff:ffff"},
+		Exclusions:   []model.IPRange{{Start: "2001:db8::1:0:0:0", End: "2001:db8::1:0:0:5"}},
+	}, "2001:db8::1:0:0:6")
+
+	ip, err := scopeCtl.AllocateIP(session, "ipam-v6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "2001:db8::1:0:0:7" {
+		t.Errorf("allocated %s, want 2001:db8::1:0:0:7", ip)
+	}
+}
+
+func TestAllocateIPFullScope(t *testing.T) {
+	resetTestStore(t)
+	session := addTestScope(t, model.Scope{Name: "ipam-full", Subnet: "192.0.2.0/29", Gateway: "192.0.2.1"},
+		"192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5", "192.0.2.6")
+
+	_, err := scopeCtl.AllocateIP(session, "ipam-full")
+	if err == nil || !strings.Contains(err.Error(), "No free addresses left") {
+		t.Errorf("got error %v, want no free addresses", err)
+	}
+}
@@ -0,0 +1,620 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// Default location of the ISC dhcpd lease files
+const defaultLeasesPath = "/var/lib/dhcp/dhcpd.leases"
+const defaultLeases6Path = "/var/lib/dhcp/dhcpd6.leases"
+
+// keaClient calls the Kea control agent. The timeout keeps an unresponsive agent from blocking the
+// lease pages
+var keaClient = &http.Client{Timeout: 10 * time.Second}
+
+// leaseController reads the leases of the DHCP server and matches them with the devices in inventory
+type leaseController struct {
+	db dbController
+}
+
+// leaseToken is a single word or quoted string of an ISC lease file
+type leaseToken struct {
+	text   string
+	quoted bool
+}
+
+// leaseNode is a statement of an ISC lease file. Blocks like "lease x { ... }" have children
+type leaseNode struct {
+	words    []leaseToken
+	children []leaseNode
+}
+
+// keaCommand is the body sent to the Kea control agent
+type keaCommand struct {
+	Command string   `json:"command"`
+	Service []string `json:"service"`
+}
+
+// keaLease is a lease as returned by the Kea lease commands
+type keaLease struct {
+	IPAddress string `json:"ip-address"`
+	HWAddress string `json:"hw-address"`
+	ClientID  string `json:"client-id"`
+	DUID      string `json:"duid"`
+	Hostname  string `json:"hostname"`
+	Cltt      int64  `json:"cltt"`
+	ValidLft  int64  `json:"valid-lft"`
+	State     int    `json:"state"`
+}
+
+// keaResponse is the answer of the Kea control agent, one item per service
+type keaResponse []struct {
+	Result    int    `json:"result"`
+	Text      string `json:"text"`
+	Arguments struct {
+		Leases []keaLease `json:"leases"`
+	} `json:"arguments"`
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (l leaseController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/leases", l.handleAPILeases)
+	r.HandleFunc("/api/leases/unknown", l.handleAPILeasesUnknown)
+	r.HandleFunc("/api/leases/adopt", l.handleAPILeasesAdopt)
+}
+
+// tokenizeLeases splits the content of an ISC lease file in words, quoted strings and punctuation
+func tokenizeLeases(content string) []leaseToken {
+	var tokens []leaseToken
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			// Comment until end of line
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, leaseToken{text: string(c)})
+			i++
+		case c == '"':
+			// Quoted string with octal and character escapes
+			var value []byte
+			i++
+			for i < len(content) && content[i] != '"' {
+				if content[i] == '\\' && i+1 < len(content) {
+					if i+3 < len(content) && isOctal(content[i+1]) && isOctal(content[i+2]) && isOctal(content[i+3]) {
+						n, _ := strconv.ParseUint(content[i+1:i+4], 8, 8)
+						value = append(value, byte(n))
+						i += 4
+						continue
+					}
+					value = append(value, content[i+1])
+					i += 2
+					continue
+				}
+				value = append(value, content[i])
+				i++
+			}
+			i++
+			tokens = append(tokens, leaseToken{text: string(value), quoted: true})
+		default:
+			start := i
+			for i < len(content) && !strings.ContainsRune(" \t\r\n{};\"#", rune(content[i])) {
+				i++
+			}
+			tokens = append(tokens, leaseToken{text: content[start:i]})
+		}
+	}
+	return tokens
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// parseLeaseNodes builds the statements tree of an ISC lease file. It returns the statements found
+// and the position of the first token not consumed
+func parseLeaseNodes(tokens []leaseToken, pos int) ([]leaseNode, int) {
+	var nodes []leaseNode
+	current := leaseNode{}
+	for pos < len(tokens) {
+		token := tokens[pos]
+		pos++
+		if token.quoted {
+			current.words = append(current.words, token)
+			continue
+		}
+		switch token.text {
+		case ";":
+			if len(current.words) > 0 {
+				nodes = append(nodes, current)
+			}
+			current = leaseNode{}
+		case "{":
+			current.children, pos = parseLeaseNodes(tokens, pos)
+			nodes = append(nodes, current)
+			current = leaseNode{}
+		case "}":
+			return nodes, pos
+		default:
+			current.words = append(current.words, token)
+		}
+	}
+	return nodes, pos
+}
+
+// parseLeaseTime reads the date of statements like "ends 3 2019/01/02 10:15:00" or "ends epoch 1546424100"
+func parseLeaseTime(words []leaseToken) time.Time {
+	if len(words) >= 3 && words[1].text == "epoch" {
+		seconds, err := strconv.ParseInt(words[2].text, 10, 64)
+		if err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+	}
+	if len(words) >= 4 {
+		t, err := time.Parse("2006/01/02 15:04:05", words[2].text+" "+words[3].text)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// leaseBytes returns the raw value of an identifier written either as quoted string or as
+// colon separated hexadecimal bytes
+func leaseBytes(token leaseToken) []byte {
+	if token.quoted {
+		return []byte(token.text)
+	}
+	value, err := hex.DecodeString(strings.Replace(token.text, ":", "", -1))
+	if err != nil {
+		return []byte(token.text)
+	}
+	return value
+}
+
+// isPrintable tells if the identifier is made only of printable characters, like serial numbers
+func isPrintable(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, c := range value {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// colonHex formats bytes as colon separated hexadecimal
+func colonHex(value []byte) string {
+	parts := make([]string, len(value))
+	for i, c := range value {
+		parts[i] = hex.EncodeToString([]byte{c})
+	}
+	return strings.Join(parts, ":")
+}
+
+// clientIDv4 returns the client identifier and, when the client sends it, the serial of the
+// device. XR devices send the serial as client ID, NX devices prefix it with a zero byte
+func clientIDv4(value []byte) (clientID string, serial string) {
+	if len(value) > 1 && value[0] == 0 && isPrintable(value[1:]) {
+		return string(value[1:]), string(value[1:])
+	}
+	if isPrintable(value) {
+		return string(value), string(value)
+	}
+	return colonHex(value), ""
+}
+
+// clientIDv6 returns the DUID and, for vendor based DUIDs with Cisco enterprise number, the
+// serial of the device
+func clientIDv6(duid []byte) (clientID string, serial string) {
+	clientID = colonHex(duid)
+	if len(duid) > 6 && bytes.Equal(duid[:6], []byte{0, 2, 0, 0, 0, 9}) {
+		identifier := bytes.TrimRight(duid[6:], "\x00")
+		if isPrintable(identifier) {
+			serial = string(identifier)
+		}
+	}
+	return clientID, serial
+}
+
+// applyLeaseStatement reads a single statement of a lease block into the lease
+func applyLeaseStatement(lease *model.Lease, words []leaseToken) {
+	if len(words) == 0 {
+		return
+	}
+	switch words[0].text {
+	case "starts", "cltt":
+		lease.Starts = parseLeaseTime(words)
+	case "ends":
+		lease.Ends = parseLeaseTime(words)
+	case "binding":
+		if len(words) >= 3 && words[1].text == "state" {
+			lease.State = words[2].text
+		}
+	case "hardware":
+		if len(words) >= 3 {
+			lease.HardwareAddr = words[2].text
+		}
+	case "uid":
+		if len(words) >= 2 {
+			lease.ClientID, lease.Serial = clientIDv4(leaseBytes(words[1]))
+		}
+	case "client-hostname":
+		if len(words) >= 2 {
+			lease.ClientName = words[1].text
+		}
+	case "set":
+		if len(words) >= 4 {
+			switch words[1].text {
+			case "vendor-class":
+				lease.VendorClass = words[3].text
+			case "user-class":
+				lease.UserClass = words[3].text
+			}
+		}
+	case "option":
+		if len(words) >= 3 {
+			switch words[1].text {
+			case "agent.circuit-id":
+				lease.CircuitID = string(leaseBytes(words[2]))
+			case "agent.remote-id":
+				lease.RemoteID = string(leaseBytes(words[2]))
+			}
+		}
+	}
+}
+
+// parseISCLeases reads the leases of an ISC dhcpd lease file. The file is append only, so the
+// last entry of each address is kept
+func parseISCLeases(content string) []model.Lease {
+	nodes, _ := parseLeaseNodes(tokenizeLeases(content), 0)
+	var leases []model.Lease
+	positions := make(map[string]int)
+	add := func(lease model.Lease) {
+		if position, present := positions[lease.Address]; present {
+			leases[position] = lease
+			return
+		}
+		positions[lease.Address] = len(leases)
+		leases = append(leases, lease)
+	}
+
+	for _, node := range nodes {
+		if len(node.words) < 2 {
+			continue
+		}
+		switch node.words[0].text {
+		case "lease":
+			// DHCPv4 lease
+			lease := model.Lease{Address: node.words[1].text}
+			for _, child := range node.children {
+				applyLeaseStatement(&lease, child.words)
+			}
+			add(lease)
+		case "ia-na", "ia-ta":
+			// DHCPv6 identity association. The identifier is the IAID (4 bytes) followed by the DUID
+			identifier := leaseBytes(node.words[1])
+			base := model.Lease{}
+			if len(identifier) > 4 {
+				base.ClientID, base.Serial = clientIDv6(identifier[4:])
+			}
+			for _, child := range node.children {
+				if len(child.words) >= 2 && child.words[0].text == "iaaddr" {
+					continue
+				}
+				applyLeaseStatement(&base, child.words)
+			}
+			for _, child := range node.children {
+				if len(child.words) < 2 || child.words[0].text != "iaaddr" {
+					continue
+				}
+				lease := base
+				lease.Address = child.words[1].text
+				for _, statement := range child.children {
+					applyLeaseStatement(&lease, statement.words)
+				}
+				add(lease)
+			}
+		}
+	}
+	return leases
+}
+
+// readISCLeases parses the lease files of the ISC DHCPv4 and DHCPv6 servers
+func (l leaseController) readISCLeases() ([]model.Lease, error) {
+	var leases []model.Lease
+	paths := []string{os.Getenv("DHCP_LEASES_PATH"), os.Getenv("DHCP6_LEASES_PATH")}
+	if paths[0] == "" {
+		paths[0] = defaultLeasesPath
+	}
+	if paths[1] == "" {
+		paths[1] = defaultLeases6Path
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			Log.Debug("readISCLeases: lease file not found", F("path", path))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, parseISCLeases(string(content))...)
+	}
+	return leases, nil
+}
+
+// readKeaLeases asks the Kea control agent for all DHCPv4 and DHCPv6 leases
+func (l leaseController) readKeaLeases(ctx context.Context) ([]model.Lease, error) {
+	var leases []model.Lease
+	commands := []keaCommand{
+		{Command: "lease4-get-all", Service: []string{"dhcp4"}},
+		{Command: "lease6-get-all", Service: []string{"dhcp6"}},
+	}
+	for _, command := range commands {
+		payload, err := json.Marshal(command)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, os.Getenv("KEA_CONTROL_URL"), bytes.NewBuffer(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := keaClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		var response keaResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range response {
+			// Result 3 means that there are no leases
+			if item.Result != 0 && item.Result != 3 {
+				return nil, errors.New("Kea " + command.Command + ": " + item.Text)
+			}
+			for _, keaLease := range item.Arguments.Leases {
+				lease := model.Lease{
+					Address:      keaLease.IPAddress,
+					HardwareAddr: keaLease.HWAddress,
+					ClientName:   keaLease.Hostname,
+					Starts:       time.Unix(keaLease.Cltt, 0).UTC(),
+					Ends:         time.Unix(keaLease.Cltt+keaLease.ValidLft, 0).UTC(),
+				}
+				// Kea states: 0 assigned, 1 declined, 2 expired-reclaimed
+				switch keaLease.State {
+				case 0:
+					lease.State = "active"
+				case 1:
+					lease.State = "declined"
+				default:
+					lease.State = "expired"
+				}
+				if keaLease.DUID != "" {
+					lease.ClientID, lease.Serial = clientIDv6(leaseBytes(leaseToken{text: keaLease.DUID}))
+				} else if keaLease.ClientID != "" {
+					lease.ClientID, lease.Serial = clientIDv4(leaseBytes(leaseToken{text: keaLease.ClientID}))
+				}
+				leases = append(leases, lease)
+			}
+		}
+	}
+	return leases, nil
+}
+
+// GetLeases returns the active leases of the DHCP server, matched with the devices in inventory.
+// Leases are read from Kea when KEA_CONTROL_URL is set, otherwise from the ISC lease files
+func (l leaseController) GetLeases(ctx context.Context) ([]model.Lease, error) {
+	var allLeases []model.Lease
+	var err error
+	if os.Getenv("KEA_CONTROL_URL") != "" {
+		allLeases, err = l.readKeaLeases(ctx)
+	} else {
+		allLeases, err = l.readISCLeases()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []model.Device
+	session, err := l.db.OpenSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	err = session.DB("ztpDashboard").C("device").Find(nil).All(&devices)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := []model.Lease{}
+	now := time.Now()
+	for _, lease := range allLeases {
+		if lease.State != "active" || (!lease.Ends.IsZero() && lease.Ends.Before(now)) {
+			continue
+		}
+		for _, device := range devices {
+			if lease.Address == device.Fixedip ||
+				(lease.Serial != "" && lease.Serial == device.Serial) ||
+				(lease.CircuitID != "" && lease.CircuitID == device.RelayCircuitID) {
+				lease.Device = device.Hostname
+				break
+			}
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// GetUnknownClients returns the clients seen in the active leases that do not match any device
+func (l leaseController) GetUnknownClients(ctx context.Context) ([]model.Lease, error) {
+	leases, err := l.GetLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	unknown := []model.Lease{}
+	for _, lease := range leases {
+		if lease.Device == "" {
+			unknown = append(unknown, lease)
+		}
+	}
+	return unknown, nil
+}
+
+// handleAPILeases will be executed when a request to /api/leases is done
+func (l leaseController) handleAPILeases(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		leases, err := l.GetLeases(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPILeases (read leases)", F("error", err))
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(leases)
+		break
+	}
+}
+
+// handleAPILeasesUnknown will be executed when a request to /api/leases/unknown is done
+func (l leaseController) handleAPILeasesUnknown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		leases, err := l.GetUnknownClients(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPILeasesUnknown (read leases)", F("error", err))
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(leases)
+		break
+	}
+}
+
+// handleAPILeasesAdopt creates a device record for an unknown DHCP client
+func (l leaseController) handleAPILeasesAdopt(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		dec := json.NewDecoder(r.Body)
+		request := &model.AdoptRequest{}
+		err := dec.Decode(request)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPILeasesAdopt (decode json)", F("error", err))
+			return
+		}
+		if request.ClientID == "" || request.Hostname == "" || request.DeviceType.Name == "" || request.Image == "" || request.Config == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Client ID, hostname, device type, image and config are required"))
+			return
+		}
+
+		// Find the client in the leases
+		unknown, err := l.GetUnknownClients(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPILeasesAdopt (read leases)", F("error", err))
+			return
+		}
+		var lease *model.Lease
+		for i := range unknown {
+			if unknown[i].ClientID == request.ClientID {
+				lease = &unknown[i]
+				break
+			}
+		}
+		if lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("No unknown client with ID " + request.ClientID + " found in leases"))
+			return
+		}
+		if lease.Serial == "" && lease.CircuitID == "" && lease.RemoteID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Client " + request.ClientID + " does not send a serial and is not behind a relay"))
+			return
+		}
+
+		// Open database
+		session, err := l.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPILeasesAdopt (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+
+		// Image and config must exist and belong to the device type
+		var image model.Image
+		err = session.DB("ztpDashboard").C("image").Find(bson.M{"name": request.Image, "devicetype.name": request.DeviceType.Name}).One(&image)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Image " + request.Image + " not found for device type " + request.DeviceType.Name))
+			return
+		}
+		var config model.Config
+		err = session.DB("ztpDashboard").C("config").Find(bson.M{"name": request.Config, "devicetype.name": request.DeviceType.Name}).One(&config)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Config " + request.Config + " not found for device type " + request.DeviceType.Name))
+			return
+		}
+
+		device := &model.Device{
+			Hostname:   request.Hostname,
+			Serial:     lease.Serial,
+			Fixedip:    request.Fixedip,
+			Scope:      request.Scope,
+			DeviceType: request.DeviceType,
+			Image:      image,
+			Config:     config,
+			Status:     "Configured",
+		}
+		// Clients without serial are adopted by the switch port they are cabled to
+		if device.Serial == "" {
+			device.RelayCircuitID = lease.CircuitID
+			device.RelayRemoteID = lease.RemoteID
+		}
+
+		status, err := deviceCtl.addDevice(session, device)
+		if err != nil {
+			w.WriteHeader(legacyStatus(status))
+			w.Write([]byte(err.Error()))
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "device", device.Hostname, nil, device)
+
+		// Return ok message
+		w.Write([]byte("ok"))
+		break
+	}
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// maxListLimit is the largest page that can be requested
+const maxListLimit = 1000
+
+// listField maps a query parameter of a list endpoint to the database field it filters or sorts.
+// Prefix fields match the values starting with the parameter, the others the exact value
+type listField struct {
+	dbField string
+	prefix  bool
+}
+
+// Filters and sort fields of the list endpoints, by query parameter
+var (
+	deviceListFields = map[string]listField{
+		"hostname":   {dbField: "hostname", prefix: true},
+		"serial":     {dbField: "serial"},
+		"status":     {dbField: "status"},
+		"deviceType": {dbField: "devicetype.name"},
+		"image":      {dbField: "image.name"},
+		"config":     {dbField: "config.name"},
+		"scope":      {dbField: "scope"},
+		// Each site has its own DHCP scope
+		"site":    {dbField: "scope"},
+		"fixedIp": {dbField: "fixedip"},
+	}
+	configListFields = map[string]listField{
+		"name":       {dbField: "name", prefix: true},
+		"deviceType": {dbField: "devicetype.name"},
+	}
+	imageListFields = map[string]listField{
+		"name":       {dbField: "name", prefix: true},
+		"deviceType": {dbField: "devicetype.name"},
+	}
+)
+
+// listQuery is the filter, sort and page requested for a list endpoint
+type listQuery struct {
+	filter bson.M
+	sort   []string
+	limit  int
+	offset int
+}
+
+// parseListQuery reads the query parameters of a list endpoint:
+// the filters of fields, sort (a field of fields), order (asc or desc), limit and offset.
+// Without parameters the whole collection is returned, as before pagination was added
+func parseListQuery(r *http.Request, fields map[string]listField) (*listQuery, error) {
+	values := r.URL.Query()
+	query := &listQuery{filter: bson.M{}}
+
+	for parameter, field := range fields {
+		value := values.Get(parameter)
+		if value == "" {
+			continue
+		}
+		if field.prefix {
+			query.filter[field.dbField] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(value)}
+		} else {
+			query.filter[field.dbField] = value
+		}
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		field, present := fields[sort]
+		if !present {
+			return nil, errors.New("Cannot sort by " + sort)
+		}
+		switch strings.ToLower(values.Get("order")) {
+		case "", "asc":
+			query.sort = []string{field.dbField}
+		case "desc":
+			query.sort = []string{"-" + field.dbField}
+		default:
+			return nil, errors.New("Order must be asc or desc")
+		}
+	}
+
+	var err error
+	if limit := values.Get("limit"); limit != "" {
+		query.limit, err = strconv.Atoi(limit)
+		if err != nil || query.limit < 1 || query.limit > maxListLimit {
+			return nil, errors.New("Limit must be a number between 1 and " + strconv.Itoa(maxListLimit))
+		}
+	}
+	if offset := values.Get("offset"); offset != "" {
+		query.offset, err = strconv.Atoi(offset)
+		if err != nil || query.offset < 0 {
+			return nil, errors.New("Offset must be a positive number")
+		}
+	}
+	return query, nil
+}
+
+// run reads the requested page into result and returns the number of objects matching the filter
+func (q listQuery) run(collection storeCollection, result interface{}) (int, error) {
+	total, err := collection.Find(q.filter).Count()
+	if err != nil {
+		return 0, err
+	}
+	find := collection.Find(q.filter)
+	if len(q.sort) > 0 {
+		find = find.Sort(q.sort...)
+	}
+	if q.offset > 0 {
+		find = find.Skip(q.offset)
+	}
+	if q.limit > 0 {
+		find = find.Limit(q.limit)
+	}
+	return total, find.All(result)
+}
+
+// setListHeaders sends the total count of the list in the X-Total-Count header
+func setListHeaders(w http.ResponseWriter, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+}
@@ -1,24 +1,327 @@
 package controller
 
 import (
-	"log"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels of the log entries, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
 )
 
-// CustomLog is the method use for manage all logs generated in the system
-func CustomLog(message string, severity string) {
-	// If debug is turn on, print everything
+// String returns the name of the level as printed in the logs
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// parseLevel reads a level name, e.g. from the LOG_LEVEL variable
+func parseLevel(name string) (Level, bool) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, true
+	case "info":
+		return LevelInfo, true
+	case "warn", "warning":
+		return LevelWarn, true
+	case "error":
+		return LevelError, true
+	}
+	return LevelInfo, false
+}
+
+// Field is a key/value pair added to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field. Errors are logged with their message
+func F(key string, value interface{}) Field {
+	if err, ok := value.(error); ok && err != nil {
+		value = err.Error()
+	}
+	return Field{Key: key, Value: value}
+}
+
+// LogEntry is a log line, as written and as given to the hooks
+type LogEntry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Logger writes leveled entries with fields. The zero value writes to the application output
+// without fields
+type Logger struct {
+	fields []Field
+}
+
+// Log is the logger of the application
+var Log = &Logger{}
+
+// With returns a logger that adds the fields to each entry
+func (l *Logger) With(fields ...Field) *Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &Logger{fields: merged}
+}
+
+// Debug logs details only useful when troubleshooting
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.log(LevelDebug, message, fields)
+}
+
+// Info logs normal events of the application
+func (l *Logger) Info(message string, fields ...Field) {
+	l.log(LevelInfo, message, fields)
+}
+
+// Warn logs unexpected events that the application recovered from
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.log(LevelWarn, message, fields)
+}
+
+// Error logs failures that need attention. They are also given to the hooks, e.g. Situation Manager
+func (l *Logger) Error(message string, fields ...Field) {
+	l.log(LevelError, message, fields)
+}
+
+func (l *Logger) log(level Level, message string, fields []Field) {
+	if level < logConfig.level {
+		return
+	}
+	entry := LogEntry{Time: time.Now().UTC(), Level: level, Message: message, Fields: l.fields}
+	if len(fields) > 0 {
+		entry.Fields = append(append([]Field{}, l.fields...), fields...)
+	}
+	logConfig.write(entry)
+	for _, hook := range logConfig.hooks() {
+		hook.fire(entry)
+	}
+}
+
+// logConfig is where and how the entries are written. LOG_LEVEL sets the minimum level (debug,
+// info, warn or error; DEBUG=on is the same as debug) and LOG_FORMAT=json writes a JSON object
+// per line
+var logConfig = newLogOutput(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+
+// logOutput writes the entries in order, one line each
+type logOutput struct {
+	mutex     sync.Mutex
+	writer    io.Writer
+	level     Level
+	json      bool
+	hookList  []*logHook
+	hookMutex sync.RWMutex
+}
+
+func newLogOutput(writer io.Writer, level string, format string) *logOutput {
+	output := &logOutput{writer: writer, json: strings.ToLower(format) == "json"}
+	output.level, _ = parseLevel(level)
 	if os.Getenv("DEBUG") == "on" {
-		log.Print(severity + ": " + message)
-		if severity == "ERROR" {
-			SituationMgrCtl.SendEvent(message)
-		}
+		output.level = LevelDebug
+	}
+	return output
+}
+
+// write formats the entry and writes it as a single line
+func (o *logOutput) write(entry LogEntry) {
+	var line []byte
+	if o.json {
+		line = o.formatJSON(entry)
 	} else {
-		// Only print ERROR severity
-		if severity == "ERROR" {
-			log.Print(severity + ": " + message)
-			SituationMgrCtl.SendEvent(message)
+		line = o.formatText(entry)
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.writer.Write(line)
+}
+
+// formatText writes time, level, message and key=value fields
+func (o *logOutput) formatText(entry LogEntry) []byte {
+	var b strings.Builder
+	b.WriteString(entry.Time.Format("2006-01-02T15:04:05.000Z07:00"))
+	b.WriteString(" ")
+	b.WriteString(entry.Level.String())
+	b.WriteString(" ")
+	b.WriteString(entry.Message)
+	for _, field := range entry.Fields {
+		value := fmt.Sprint(field.Value)
+		if value == "" || strings.ContainsAny(value, " \"=\t\n") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" " + field.Key + "=" + value)
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// formatJSON writes an object with the time, level, message and fields
+func (o *logOutput) formatJSON(entry LogEntry) []byte {
+	object := make(map[string]interface{}, len(entry.Fields)+3)
+	for _, field := range entry.Fields {
+		object[field.Key] = field.Value
+	}
+	object["time"] = entry.Time.Format(time.RFC3339Nano)
+	object["level"] = strings.ToLower(entry.Level.String())
+	object["message"] = entry.Message
+	line, err := json.Marshal(object)
+	if err != nil {
+		// A field cannot be encoded, keep the entry without fields
+		line, _ = json.Marshal(map[string]string{"time": object["time"].(string), "level": object["level"].(string), "message": entry.Message, "logError": err.Error()})
+	}
+	return append(line, '\n')
+}
+
+func (o *logOutput) hooks() []*logHook {
+	o.hookMutex.RLock()
+	defer o.hookMutex.RUnlock()
+	return o.hookList
+}
+
+// logHook forwards entries to an external sink from its own goroutine, so a slow sink never
+// delays the logging code. Entries are dropped when the buffer is full
+type logHook struct {
+	name    string
+	level   Level
+	entries chan LogEntry
+	dropped int32
+}
+
+// AddLogHook calls send with each entry of the level or above. Entries are buffered up to
+// bufferSize and sent one at a time. send should log with hookLog, whose entries are not given to
+// the hooks, so a sink that logs its own failures does not loop
+func AddLogHook(name string, level Level, bufferSize int, send func(LogEntry)) {
+	hook := &logHook{name: name, level: level, entries: make(chan LogEntry, bufferSize)}
+	go hook.run(send)
+
+	logConfig.hookMutex.Lock()
+	defer logConfig.hookMutex.Unlock()
+	logConfig.hookList = append(logConfig.hookList, hook)
+}
+
+// fire queues the entry without blocking
+func (h *logHook) fire(entry LogEntry) {
+	if entry.Level < h.level || hookEntry(entry) {
+		return
+	}
+	select {
+	case h.entries <- entry:
+	default:
+		if atomic.AddInt32(&h.dropped, 1) == 1 {
+			logConfig.write(LogEntry{Time: time.Now().UTC(), Level: LevelWarn, Message: "Log hook is full, dropping entries", Fields: []Field{F("hook", h.name)}})
 		}
 	}
+}
 
+// run sends the queued entries, reporting how many were dropped meanwhile
+func (h *logHook) run(send func(LogEntry)) {
+	for entry := range h.entries {
+		if dropped := atomic.SwapInt32(&h.dropped, 0); dropped > 0 {
+			logConfig.write(LogEntry{Time: time.Now().UTC(), Level: LevelWarn, Message: "Log hook dropped entries", Fields: []Field{F("hook", h.name), F("dropped", dropped)}})
+		}
+		send(entry)
+	}
+}
+
+// hookField marks the entries logged by the hooks themselves
+const hookField = "logHook"
+
+// hookEntry tells if the entry was logged by a hook
+func hookEntry(entry LogEntry) bool {
+	for _, field := range entry.Fields {
+		if field.Key == hookField {
+			return true
+		}
+	}
+	return false
+}
+
+// hookLog is the logger for the code run by a hook, its entries are not forwarded to the hooks
+func hookLog(name string) *Logger {
+	return Log.With(F(hookField, name))
+}
+
+// requestIDHeader carries the ID of a request, given by a proxy or generated
+const requestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// logRequests gives each request an ID, returned in the X-Request-ID header, and a logger with the
+// request ID and route. Requests are logged at the debug level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		logger := Log.With(F("requestId", id), F("route", route))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+		logger.Debug("HTTP request", F("method", r.Method), F("path", r.URL.Path), F("status", recorder.status), F("duration", time.Since(started).String()), F("remote", r.RemoteAddr))
+	})
+}
+
+// requestLog returns the logger of the request, with its ID and route
+func requestLog(r *http.Request) *Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return Log
+}
+
+// newRequestID returns a random ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// deviceFields returns the fields identifying a device in the logs
+func deviceFields(hostname string, serial string) []Field {
+	fields := []Field{F("hostname", hostname)}
+	if serial != "" {
+		fields = append(fields, F("serial", serial))
+	}
+	return fields
 }
@@ -0,0 +1,886 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// testServerIPv4 is the only address of the server in the tests
+const testServerIPv4 = "192.0.2.10"
+
+// testStore replaces MongoDB for all the tests of the package
+var testStore *memoryStore
+
+// TestMain points the controllers to the in-memory store, to a temporary directory for the files
+// served to devices and to a server with an IPv4 address only
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "ztp-dashboard")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	basePath = dir
+	testStore = newMemoryStore()
+	openDBSession = testStore.open
+	localAddresses = func() ([]net.IP, error) { return []net.IP{net.ParseIP(testServerIPv4)}, nil }
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// resetTestStore empties the store and the public directories, then creates the device types, so
+// each test starts from a new installation. The variables set by TestMain are not replaced since
+// goroutines started by previous tests can still read them
+func resetTestStore(t *testing.T) {
+	testStore.mutex.Lock()
+	testStore.collections = make(map[string][]bson.M)
+	testStore.mutex.Unlock()
+	for _, public := range []string{"configs", "images", "scripts"} {
+		err := os.RemoveAll(basePath + "/public/" + public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		CreateDirIfNotExist(basePath + "/public/" + public)
+	}
+	err := deviceCtl.checkDeviceTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memoryStore keeps the collections in memory for the tests. Documents are converted with the
+// bson rules used by mgo: lowercased field names, the bson tag, omitempty, inline and "-"
+type memoryStore struct {
+	mutex       sync.Mutex
+	collections map[string][]bson.M
+	nextID      int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{collections: make(map[string][]bson.M)}
+}
+
+// open is used as openDBSession
+func (s *memoryStore) open() (storeSession, error) {
+	return memorySession{s}, nil
+}
+
+// documents returns a copy of the documents of a collection of the ztpDashboard database
+func (s *memoryStore) documents(collection string) []bson.M {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	documents := []bson.M{}
+	for _, document := range s.collections["ztpDashboard."+collection] {
+		documents = append(documents, copyValue(document).(bson.M))
+	}
+	return documents
+}
+
+type memorySession struct{ store *memoryStore }
+
+func (s memorySession) DB(name string) storeDatabase { return memoryDatabase{s.store, name} }
+func (s memorySession) Close()                       {}
+
+type memoryDatabase struct {
+	store *memoryStore
+	name  string
+}
+
+func (d memoryDatabase) C(name string) storeCollection {
+	return memoryCollection{d.store, d.name + "." + name}
+}
+
+type memoryCollection struct {
+	store *memoryStore
+	name  string
+}
+
+func (c memoryCollection) Find(query interface{}) storeQuery {
+	return &memoryQuery{collection: c, query: query, limit: -1}
+}
+
+func (c memoryCollection) FindId(id interface{}) storeQuery {
+	return c.Find(bson.M{"_id": id})
+}
+
+func (c memoryCollection) Count() (int, error) {
+	return c.Find(nil).Count()
+}
+
+func (c memoryCollection) Insert(docs ...interface{}) error {
+	c.store.mutex.Lock()
+	defer c.store.mutex.Unlock()
+	for _, doc := range docs {
+		document, err := toDocument(doc)
+		if err != nil {
+			return err
+		}
+		if _, ok := document["_id"]; !ok {
+			c.store.nextID++
+			document["_id"] = strconv.Itoa(c.store.nextID)
+		}
+		for _, existing := range c.store.collections[c.name] {
+			if equalValues(existing["_id"], document["_id"]) {
+				return &mgo.LastError{Code: 11000, Err: fmt.Sprintf("E11000 duplicate key error collection: %s _id: %v", c.name, document["_id"])}
+			}
+		}
+		c.store.collections[c.name] = append(c.store.collections[c.name], document)
+	}
+	return nil
+}
+
+// update changes the documents matching the selector, the first one only if all is false
+func (c memoryCollection) update(selector, update interface{}, all bool) (*mgo.ChangeInfo, error) {
+	c.store.mutex.Lock()
+	defer c.store.mutex.Unlock()
+	info := &mgo.ChangeInfo{}
+	for i, document := range c.store.collections[c.name] {
+		ok, err := matchDocument(document, selector)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		updated, err := applyUpdate(document, update)
+		if err != nil {
+			return nil, err
+		}
+		c.store.collections[c.name][i] = updated
+		info.Matched++
+		info.Updated++
+		if !all {
+			break
+		}
+	}
+	return info, nil
+}
+
+func (c memoryCollection) Update(selector, update interface{}) error {
+	info, err := c.update(selector, update, false)
+	if err != nil {
+		return err
+	}
+	if info.Matched == 0 {
+		return mgo.ErrNotFound
+	}
+	return nil
+}
+
+func (c memoryCollection) UpdateId(id, update interface{}) error {
+	return c.Update(bson.M{"_id": id}, update)
+}
+
+func (c memoryCollection) UpdateAll(selector, update interface{}) (*mgo.ChangeInfo, error) {
+	return c.update(selector, update, true)
+}
+
+func (c memoryCollection) UpsertId(id, update interface{}) (*mgo.ChangeInfo, error) {
+	info, err := c.update(bson.M{"_id": id}, update, false)
+	if err != nil || info.Matched > 0 {
+		return info, err
+	}
+	document, err := applyUpdate(bson.M{"_id": normalize(id)}, update)
+	if err != nil {
+		return nil, err
+	}
+	document["_id"] = normalize(id)
+	err = c.Insert(document)
+	if err != nil {
+		return nil, err
+	}
+	return &mgo.ChangeInfo{UpsertedId: id}, nil
+}
+
+// remove deletes the documents matching the selector, the first one only if all is false
+func (c memoryCollection) remove(selector interface{}, all bool) (*mgo.ChangeInfo, error) {
+	c.store.mutex.Lock()
+	defer c.store.mutex.Unlock()
+	info := &mgo.ChangeInfo{}
+	kept := []bson.M{}
+	for _, document := range c.store.collections[c.name] {
+		ok, err := matchDocument(document, selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (all || info.Removed == 0) {
+			info.Matched++
+			info.Removed++
+			continue
+		}
+		kept = append(kept, document)
+	}
+	c.store.collections[c.name] = kept
+	return info, nil
+}
+
+func (c memoryCollection) Remove(selector interface{}) error {
+	info, err := c.remove(selector, false)
+	if err != nil {
+		return err
+	}
+	if info.Removed == 0 {
+		return mgo.ErrNotFound
+	}
+	return nil
+}
+
+func (c memoryCollection) RemoveId(id interface{}) error {
+	return c.Remove(bson.M{"_id": id})
+}
+
+func (c memoryCollection) RemoveAll(selector interface{}) (*mgo.ChangeInfo, error) {
+	return c.remove(selector, true)
+}
+
+type memoryQuery struct {
+	collection memoryCollection
+	query      interface{}
+	sort       []string
+	skip       int
+	limit      int
+	selector   interface{}
+}
+
+func (q *memoryQuery) Sort(fields ...string) storeQuery       { q.sort = fields; return q }
+func (q *memoryQuery) Skip(n int) storeQuery                  { q.skip = n; return q }
+func (q *memoryQuery) Limit(n int) storeQuery                 { q.limit = n; return q }
+func (q *memoryQuery) Select(selector interface{}) storeQuery { q.selector = selector; return q }
+
+// run returns copies of the matching documents, sorted, paginated and projected
+func (q *memoryQuery) run() ([]bson.M, error) {
+	q.collection.store.mutex.Lock()
+	defer q.collection.store.mutex.Unlock()
+	documents := []bson.M{}
+	for _, document := range q.collection.store.collections[q.collection.name] {
+		ok, err := matchDocument(document, q.query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			documents = append(documents, copyValue(document).(bson.M))
+		}
+	}
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, field := range q.sort {
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			a, _ := lookupPath(documents[i], field)
+			b, _ := lookupPath(documents[j], field)
+			order := compareValues(a, b)
+			if order != 0 {
+				return (order < 0) != descending
+			}
+		}
+		return false
+	})
+	if q.skip >= len(documents) {
+		documents = []bson.M{}
+	} else {
+		documents = documents[q.skip:]
+	}
+	if q.limit > 0 && q.limit < len(documents) {
+		documents = documents[:q.limit]
+	}
+	if q.selector != nil {
+		fields := normalize(q.selector).(bson.M)
+		for i, document := range documents {
+			projected := bson.M{"_id": document["_id"]}
+			for field := range fields {
+				if value, ok := lookupPath(document, field); ok {
+					setPath(projected, field, value)
+				}
+			}
+			documents[i] = projected
+		}
+	}
+	return documents, nil
+}
+
+func (q *memoryQuery) Count() (int, error) {
+	documents, err := q.run()
+	return len(documents), err
+}
+
+func (q *memoryQuery) One(result interface{}) error {
+	documents, err := q.run()
+	if err != nil {
+		return err
+	}
+	if len(documents) == 0 {
+		return mgo.ErrNotFound
+	}
+	return fromDocument(documents[0], result)
+}
+
+func (q *memoryQuery) All(result interface{}) error {
+	documents, err := q.run()
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(documents))
+	for i, document := range documents {
+		values[i] = document
+	}
+	return fromDocument(values, result)
+}
+
+// toDocument converts a struct or map to a document
+func toDocument(doc interface{}) (bson.M, error) {
+	document, ok := normalize(doc).(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("memory store: cannot store %T", doc)
+	}
+	return document, nil
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	regExType = reflect.TypeOf(bson.RegEx{})
+)
+
+// normalize converts a value to the types stored in documents: bson.M, []interface{}, string,
+// int64, float64, bool, time.Time, bson.RegEx and nil
+func normalize(value interface{}) interface{} {
+	return normalizeValue(reflect.ValueOf(value))
+}
+
+func normalizeValue(v reflect.Value) interface{} {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time)
+	case regExType:
+		return v.Interface().(bson.RegEx)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Map:
+		document := bson.M{}
+		for _, key := range v.MapKeys() {
+			document[fmt.Sprint(key.Interface())] = normalizeValue(v.MapIndex(key))
+		}
+		return document
+	case reflect.Slice, reflect.Array:
+		if v.Type() == reflect.TypeOf(bson.D{}) {
+			document := bson.M{}
+			for _, element := range v.Interface().(bson.D) {
+				document[element.Name] = normalize(element.Value)
+			}
+			return document
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = normalizeValue(v.Index(i))
+		}
+		return values
+	case reflect.Struct:
+		document := bson.M{}
+		addStructFields(document, v)
+		return document
+	}
+	return v.Interface()
+}
+
+// bsonField is the name and options of a struct field in documents
+type bsonField struct {
+	name      string
+	omitEmpty bool
+	inline    bool
+	skip      bool
+}
+
+func fieldOf(field reflect.StructField) bsonField {
+	if field.PkgPath != "" {
+		return bsonField{skip: true}
+	}
+	options := strings.Split(field.Tag.Get("bson"), ",")
+	result := bsonField{name: options[0]}
+	if result.name == "-" {
+		return bsonField{skip: true}
+	}
+	for _, option := range options[1:] {
+		result.omitEmpty = result.omitEmpty || option == "omitempty"
+		result.inline = result.inline || option == "inline"
+	}
+	if result.name == "" {
+		result.name = strings.ToLower(field.Name)
+	}
+	return result
+}
+
+func addStructFields(document bson.M, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := fieldOf(v.Type().Field(i))
+		if field.skip {
+			continue
+		}
+		value := v.Field(i)
+		if field.inline {
+			addStructFields(document, reflect.Indirect(value))
+			continue
+		}
+		if field.omitEmpty && value.IsZero() {
+			continue
+		}
+		document[field.name] = normalizeValue(value)
+	}
+}
+
+// fromDocument decodes a normalized value into the value pointed by result
+func fromDocument(value interface{}, result interface{}) error {
+	target := reflect.ValueOf(result)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("memory store: cannot decode into %T", result)
+	}
+	return decodeValue(value, target.Elem())
+}
+
+func decodeValue(value interface{}, target reflect.Value) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	switch target.Type() {
+	case timeType:
+		t, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("memory store: cannot decode %T into time", value)
+		}
+		target.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch target.Kind() {
+	case reflect.Ptr:
+		element := reflect.New(target.Type().Elem())
+		err := decodeValue(value, element.Elem())
+		if err != nil {
+			return err
+		}
+		target.Set(element)
+		return nil
+	case reflect.Interface:
+		target.Set(reflect.ValueOf(copyValue(value)))
+		return nil
+	case reflect.Struct:
+		document, ok := value.(bson.M)
+		if !ok {
+			return fmt.Errorf("memory store: cannot decode %T into %s", value, target.Type())
+		}
+		return decodeStruct(document, target)
+	case reflect.Map:
+		document, ok := value.(bson.M)
+		if !ok {
+			return fmt.Errorf("memory store: cannot decode %T into %s", value, target.Type())
+		}
+		result := reflect.MakeMap(target.Type())
+		for key, item := range document {
+			element := reflect.New(target.Type().Elem()).Elem()
+			err := decodeValue(item, element)
+			if err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), element)
+		}
+		target.Set(result)
+		return nil
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("memory store: cannot decode %T into %s", value, target.Type())
+		}
+		result := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			err := decodeValue(item, result.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		target.Set(result)
+		return nil
+	}
+	source := reflect.ValueOf(value)
+	if !source.Type().ConvertibleTo(target.Type()) || (source.Kind() == reflect.String) != (target.Kind() == reflect.String) {
+		return fmt.Errorf("memory store: cannot decode %T into %s", value, target.Type())
+	}
+	target.Set(source.Convert(target.Type()))
+	return nil
+}
+
+func decodeStruct(document bson.M, target reflect.Value) error {
+	for i := 0; i < target.NumField(); i++ {
+		field := fieldOf(target.Type().Field(i))
+		if field.skip {
+			continue
+		}
+		if field.inline {
+			err := decodeStruct(document, reflect.Indirect(target.Field(i)))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := document[field.name]
+		if !ok {
+			continue
+		}
+		err := decodeValue(value, target.Field(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyValue deep copies a normalized value
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case bson.M:
+		document := bson.M{}
+		for key, item := range value {
+			document[key] = copyValue(item)
+		}
+		return document
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = copyValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+// lookupPath returns the value of a dotted field path of the document
+func lookupPath(document bson.M, path string) (interface{}, bool) {
+	var value interface{} = document
+	for _, key := range strings.Split(path, ".") {
+		current, ok := value.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		value, ok = current[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPath sets the value of a dotted field path, creating the intermediate documents
+func setPath(document bson.M, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := document[key].(bson.M)
+		if !ok {
+			next = bson.M{}
+			document[key] = next
+		}
+		document = next
+	}
+	document[keys[len(keys)-1]] = value
+}
+
+// matchDocument tells if the document matches the query. Only the operators used by the
+// controllers are supported
+func matchDocument(document bson.M, query interface{}) (bool, error) {
+	if query == nil {
+		return true, nil
+	}
+	conditions, ok := normalize(query).(bson.M)
+	if !ok {
+		return false, fmt.Errorf("memory store: query %T is not a document", query)
+	}
+	for key, condition := range conditions {
+		var ok bool
+		var err error
+		switch key {
+		case "$or", "$and":
+			ok, err = matchList(document, key, condition)
+		default:
+			value, exists := lookupPath(document, key)
+			ok, err = matchCondition(value, exists, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchList(document bson.M, operator string, condition interface{}) (bool, error) {
+	queries, ok := condition.([]interface{})
+	if !ok {
+		return false, errors.New("memory store: " + operator + " needs an array")
+	}
+	for _, query := range queries {
+		ok, err := matchDocument(document, query)
+		if err != nil {
+			return false, err
+		}
+		if ok && operator == "$or" {
+			return true, nil
+		}
+		if !ok && operator == "$and" {
+			return false, nil
+		}
+	}
+	return operator == "$and", nil
+}
+
+// matchCondition matches a field value against an equality or a document of operators
+func matchCondition(value interface{}, exists bool, condition interface{}) (bool, error) {
+	operators, ok := condition.(bson.M)
+	if !ok || len(operators) == 0 {
+		return matchEqual(value, condition), nil
+	}
+	for operator := range operators {
+		if !strings.HasPrefix(operator, "$") {
+			return matchEqual(value, condition), nil
+		}
+	}
+	for operator, argument := range operators {
+		var ok bool
+		switch operator {
+		case "$ne":
+			ok = !matchEqual(value, argument)
+		case "$in", "$nin":
+			items, isArray := argument.([]interface{})
+			if !isArray {
+				return false, errors.New("memory store: " + operator + " needs an array")
+			}
+			for _, item := range items {
+				ok = ok || matchEqual(value, item)
+			}
+			ok = ok == (operator == "$in")
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && orderable(value, argument)
+			if ok {
+				order := compareValues(value, argument)
+				switch operator {
+				case "$gt":
+					ok = order > 0
+				case "$gte":
+					ok = order >= 0
+				case "$lt":
+					ok = order < 0
+				case "$lte":
+					ok = order <= 0
+				}
+			}
+		case "$exists":
+			ok = exists == (argument == true)
+		default:
+			return false, errors.New("memory store: unsupported operator " + operator)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEqual compares a field value with a query value. Arrays match if one of their items does
+func matchEqual(value interface{}, condition interface{}) bool {
+	if regEx, ok := condition.(bson.RegEx); ok {
+		text, ok := value.(string)
+		if !ok {
+			return false
+		}
+		pattern := regEx.Pattern
+		if strings.Contains(regEx.Options, "i") {
+			pattern = "(?i)" + pattern
+		}
+		matched, err := regexp.MatchString(pattern, text)
+		return err == nil && matched
+	}
+	if items, ok := value.([]interface{}); ok {
+		if _, isArray := condition.([]interface{}); !isArray {
+			for _, item := range items {
+				if equalValues(item, condition) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return equalValues(value, condition)
+}
+
+func equalValues(a, b interface{}) bool {
+	if orderable(a, b) {
+		return compareValues(a, b) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// orderable tells if compareValues can order the two values
+func orderable(a, b interface{}) bool {
+	switch a.(type) {
+	case int64, float64:
+		switch b.(type) {
+		case int64, float64:
+			return true
+		}
+	case string:
+		_, ok := b.(string)
+		return ok
+	case time.Time:
+		_, ok := b.(time.Time)
+		return ok
+	case bool:
+		_, ok := b.(bool)
+		return ok
+	}
+	return false
+}
+
+// compareValues orders two values, missing values and other types first
+func compareValues(a, b interface{}) int {
+	if !orderable(a, b) {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		case b == nil:
+			return 1
+		}
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		switch {
+		case a.Before(b.(time.Time)):
+			return -1
+		case a.After(b.(time.Time)):
+			return 1
+		}
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	}
+	x, y := toFloat(a), toFloat(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(value interface{}) float64 {
+	if i, ok := value.(int64); ok {
+		return float64(i)
+	}
+	return value.(float64)
+}
+
+// applyUpdate returns the document changed by the update operators, or replaced by the update
+func applyUpdate(document bson.M, update interface{}) (bson.M, error) {
+	changes, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	operators := false
+	for key := range changes {
+		operators = operators || strings.HasPrefix(key, "$")
+	}
+	if !operators {
+		changes["_id"] = document["_id"]
+		return changes, nil
+	}
+	updated := copyValue(document).(bson.M)
+	for operator, argument := range changes {
+		fields, ok := argument.(bson.M)
+		if !ok {
+			return nil, errors.New("memory store: " + operator + " needs a document")
+		}
+		for field, value := range fields {
+			switch operator {
+			case "$set":
+				setPath(updated, field, value)
+			case "$unset":
+				keys := strings.Split(field, ".")
+				parent := updated
+				if len(keys) > 1 {
+					parent, _ = lookupPathDocument(updated, strings.Join(keys[:len(keys)-1], "."))
+				}
+				if parent != nil {
+					delete(parent, keys[len(keys)-1])
+				}
+			case "$inc":
+				current, _ := lookupPath(updated, field)
+				if current == nil {
+					current = int64(0)
+				}
+				if !orderable(current, value) {
+					return nil, errors.New("memory store: $inc needs numbers")
+				}
+				if a, ok := current.(int64); ok {
+					if b, ok := value.(int64); ok {
+						setPath(updated, field, a+b)
+						continue
+					}
+				}
+				setPath(updated, field, toFloat(current)+toFloat(value))
+			default:
+				return nil, errors.New("memory store: unsupported operator " + operator)
+			}
+		}
+	}
+	return updated, nil
+}
+
+func lookupPathDocument(document bson.M, path string) (bson.M, bool) {
+	value, ok := lookupPath(document, path)
+	if !ok {
+		return nil, false
+	}
+	result, ok := value.(bson.M)
+	return result, ok
+}
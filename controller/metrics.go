@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics of the application. Devices by status and type are read from the database
+// when the metrics are scraped, see deviceCollector
+var (
+	provisioningDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ztp_provisioning_duration_seconds",
+		Help:    "Time from the registration of a device to the end of its provisioning.",
+		Buckets: []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200, 14400},
+	})
+	fileDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ztp_file_downloads_total",
+		Help: "Files downloaded by devices, by directory (scripts, configs, images) and protocol.",
+	}, []string{"directory", "protocol"})
+	fileDownloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ztp_file_download_bytes_total",
+		Help: "Bytes sent to devices, by directory and protocol.",
+	}, []string{"directory", "protocol"})
+	fileDownloadsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ztp_file_downloads_in_flight",
+		Help: "Downloads in progress, by directory and protocol.",
+	}, []string{"directory", "protocol"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ztp_http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests, by route template, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+	dhcpRegenerations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ztp_dhcp_regenerations_total",
+		Help: "DHCP configuration regenerations.",
+	})
+	dhcpRegenerationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ztp_dhcp_regeneration_failures_total",
+		Help: "DHCP configuration regenerations that logged errors.",
+	})
+	dhcpRegenerationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ztp_dhcp_regeneration_duration_seconds",
+		Help:    "Duration of the DHCP configuration regenerations, including the service restarts.",
+		Buckets: prometheus.DefBuckets,
+	})
+	notificationQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ztp_notification_queue_length",
+		Help: "Notifications waiting to be sent, including retries and digests.",
+	})
+	notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ztp_notifications_total",
+		Help: "Notifications sent, by channel type (webex, slack, email, webhook, situationManager) and result (success or failure).",
+	}, []string{"channel", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(deviceCollector{})
+}
+
+// deviceCollector counts the devices by status and type when the metrics are scraped
+type deviceCollector struct{}
+
+var devicesDesc = prometheus.NewDesc("ztp_devices", "Devices by status and device type.", []string{"status", "device_type"}, nil)
+
+// Describe sends the description of the device metric
+func (c deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- devicesDesc
+}
+
+// Collect reads the devices and sends their count by status and type
+func (c deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		Log.Error("deviceCollector (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	var devices []model.Device
+	err = session.DB("ztpDashboard").C("device").Find(nil).Select(bson.M{"status": 1, "devicetype": 1}).All(&devices)
+	if err != nil {
+		Log.Error("deviceCollector (read database)", F("error", err))
+		return
+	}
+	counts := make(map[[2]string]int)
+	for _, device := range devices {
+		counts[[2]string{device.Status, device.DeviceType.Name}]++
+	}
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+}
+
+// metricsController serves the Prometheus metrics
+type metricsController struct {
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (m metricsController) registerRoutes(r *mux.Router) {
+	r.Handle("/metrics", promhttp.Handler())
+	r.Use(instrumentHandler)
+}
+
+// instrumentHandler measures the latency of the requests matched by the router
+func instrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		started := time.Now()
+		next.ServeHTTP(recorder, r)
+		httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Observe(time.Since(started).Seconds())
+	})
+}
+
+// statusRecorder keeps the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush is needed by the event stream
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writeDownload sends a file to a device over HTTP and counts the download
+func writeDownload(w http.ResponseWriter, directory string, content []byte) {
+	fileDownloadsInFlight.WithLabelValues(directory, "http").Inc()
+	defer fileDownloadsInFlight.WithLabelValues(directory, "http").Dec()
+
+	n, err := w.Write(content)
+	fileDownloadBytes.WithLabelValues(directory, "http").Add(float64(n))
+	if err == nil {
+		fileDownloads.WithLabelValues(directory, "http").Inc()
+	}
+}
+
+// countingReader counts the bytes read, for the TFTP transfers
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	c.count += int64(n)
+	return n, err
+}
+
+// observeProvisioningDuration measures the time since the device was added
+func observeProvisioningDuration(device model.Device) {
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		Log.Error("observeProvisioningDuration (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	var added model.DeviceStatusChange
+	err = session.DB("ztpDashboard").C("deviceStatus").Find(bson.M{"hostname": device.Hostname, "detail": deviceAddedDetail}).Sort("-time").One(&added)
+	if err != nil {
+		// Devices added before the timeline existed have no registration time
+		Log.Debug("observeProvisioningDuration (read database)", F("error", err))
+		return
+	}
+	provisioningDuration.Observe(time.Since(added.Time).Seconds())
+}
+
+// countNotification records the result of a notification sent to a channel
+func countNotification(channel string, err error) {
+	if err != nil {
+		notifications.WithLabelValues(channel, "failure").Inc()
+		return
+	}
+	notifications.WithLabelValues(channel, "success").Inc()
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+)
+
+// Delivery of the queued notifications. Failed deliveries are retried with an exponential backoff
+// starting at notificationRetryBase, until notificationMaxAttempts
+const (
+	notificationQueueInterval = 5 * time.Second
+	notificationQueueBatch    = 100
+	notificationRetryBase     = 30 * time.Second
+	notificationRetryMax      = time.Hour
+	notificationMaxAttempts   = 12
+)
+
+// notificationDigest is the type of the summaries sent to the channels in digest mode
+const notificationDigest = "digest"
+
+// queuedNotification is a notification waiting to be sent to a channel. It is stored in the
+// notificationQueue collection, so notifications are not lost on restarts
+type queuedNotification struct {
+	ID           bson.ObjectId `bson:"_id"`
+	Channel      string
+	Notification Notification
+	// Digest notifications wait for the summary of the channel instead of being sent
+	Digest      bool
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+}
+
+// deliveryError is a failed delivery. Permanent errors are not retried, and retryAfter is the wait
+// asked by the channel, e.g. with a Retry-After header
+type deliveryError struct {
+	message    string
+	permanent  bool
+	retryAfter time.Duration
+}
+
+func (e *deliveryError) Error() string {
+	return e.message
+}
+
+// responseDeliveryError returns the error of a response with a status code out of 2xx. Client
+// errors are permanent, except 408 and 429
+func responseDeliveryError(service string, resp *http.Response) error {
+	err := &deliveryError{message: service + " returned status " + resp.Status}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		err.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout:
+		err.permanent = true
+	}
+	return err
+}
+
+// smtpDeliveryError marks the permanent SMTP failures (5xx replies)
+func smtpDeliveryError(err error) error {
+	if protocolError, ok := err.(*textproto.Error); ok && protocolError.Code >= 500 {
+		return &deliveryError{message: err.Error(), permanent: true}
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// notificationBackoff returns the wait before the next attempt of a notification
+func notificationBackoff(attempts int) time.Duration {
+	delay := notificationRetryBase
+	for i := 1; i < attempts && delay < notificationRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > notificationRetryMax {
+		delay = notificationRetryMax
+	}
+	return delay
+}
+
+// notificationQueue sends the queued notifications from a single goroutine
+type notificationQueue struct {
+	db   dbController
+	wake chan bool
+	// nextSend is the earliest time a channel can receive a message, from its rate limit or a
+	// Retry-After header
+	nextSend map[string]time.Time
+}
+
+// outbox is the notification queue of the application
+var outbox = &notificationQueue{wake: make(chan bool, 1), nextSend: make(map[string]time.Time)}
+
+// enqueue stores the notification for the channel. Device status changes of channels in digest
+// mode are kept for the next summary of the channel
+func (q *notificationQueue) enqueue(channel model.NotificationChannel, notification Notification) error {
+	session, err := q.db.OpenSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	collection := session.DB("ztpDashboard").C("notificationQueue")
+
+	now := time.Now().UTC()
+	item := queuedNotification{ID: bson.NewObjectId(), Channel: channel.Name, Notification: notification, NextAttempt: now, Created: now}
+	if channel.DigestInterval > 0 && notification.Type == model.NotifyDeviceStatus {
+		item.Digest = true
+		item.NextAttempt = now.Add(time.Duration(channel.DigestInterval) * time.Minute)
+		// Join the summary already waiting for the channel
+		var pending []queuedNotification
+		err = collection.Find(bson.M{"channel": channel.Name, "digest": true}).Sort("nextattempt").Limit(1).All(&pending)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			item.NextAttempt = pending[0].NextAttempt
+		}
+	}
+	err = collection.Insert(&item)
+	if err != nil {
+		return err
+	}
+	select {
+	case q.wake <- true:
+	default:
+	}
+	return nil
+}
+
+// run sends the due notifications every notificationQueueInterval, or when one is queued
+func (q *notificationQueue) run() {
+	ticker := time.NewTicker(notificationQueueInterval)
+	defer ticker.Stop()
+	for {
+		q.process()
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// process sends the notifications that are due, oldest first
+func (q *notificationQueue) process() {
+	session, err := q.db.OpenSession()
+	if err != nil {
+		Log.Error("notificationQueue (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+	collection := session.DB("ztpDashboard").C("notificationQueue")
+
+	settings, err := notificationSettings()
+	if err != nil {
+		Log.Error("notificationQueue (read settings)", F("error", err))
+		return
+	}
+	channels := make(map[string]model.NotificationChannel)
+	for _, channel := range notificationChannels(settings) {
+		if _, present := channels[channel.Name]; !present {
+			channels[channel.Name] = channel
+		}
+	}
+
+	// Channels waiting for their rate limit are left out, so their notifications do not fill the
+	// batch and hold back the other channels
+	now := time.Now().UTC()
+	limited := []string{}
+	for name, next := range q.nextSend {
+		if now.Before(next) {
+			limited = append(limited, name)
+		}
+	}
+	var items []queuedNotification
+	err = collection.Find(bson.M{"nextattempt": bson.M{"$lte": now}, "channel": bson.M{"$nin": limited}}).Sort("nextattempt").Limit(notificationQueueBatch).All(&items)
+	if err != nil {
+		Log.Error("notificationQueue (read database)", F("error", err))
+		return
+	}
+	summarized := make(map[string]bool)
+	for _, item := range items {
+		channel, present := channels[item.Channel]
+		if !present || !channel.Enabled {
+			Log.Warn("notificationQueue: channel removed or disabled, dropping notification", F("channel", item.Channel), F("type", item.Notification.Type))
+			collection.RemoveId(item.ID)
+			continue
+		}
+		if item.Digest {
+			if !summarized[item.Channel] {
+				summarized[item.Channel] = true
+				q.summarize(collection, channel)
+			}
+			continue
+		}
+		if now.Before(q.nextSend[channel.Name]) {
+			// Rate limited, the notification stays due for the next pass
+			continue
+		}
+		if channel.RateLimit > 0 {
+			q.nextSend[channel.Name] = now.Add(time.Minute / time.Duration(channel.RateLimit))
+		}
+
+		err := newNotifier(channel).Notify(item.Notification)
+		countNotification(channel.Type, err)
+		if err == nil {
+			collection.RemoveId(item.ID)
+			continue
+		}
+		q.retry(collection, channel, item, err)
+	}
+
+	if count, err := collection.Count(); err == nil {
+		notificationQueueLength.Set(float64(count))
+	}
+}
+
+// retry schedules the next attempt of a failed notification, or drops it
+func (q *notificationQueue) retry(collection storeCollection, channel model.NotificationChannel, item queuedNotification, err error) {
+	logger := Log.With(F("channel", channel.Name), F("type", item.Notification.Type), F("attempts", item.Attempts+1), F("error", err))
+	item.Attempts++
+	item.LastError = err.Error()
+	delay := notificationBackoff(item.Attempts)
+	if failure, ok := err.(*deliveryError); ok {
+		if failure.permanent {
+			logger.Error("notificationQueue: notification rejected, dropping it")
+			collection.RemoveId(item.ID)
+			return
+		}
+		if failure.retryAfter > 0 {
+			q.nextSend[channel.Name] = time.Now().UTC().Add(failure.retryAfter)
+			if failure.retryAfter > delay {
+				delay = failure.retryAfter
+			}
+		}
+	}
+	if item.Attempts >= notificationMaxAttempts {
+		logger.Error("notificationQueue: too many failed attempts, dropping notification")
+		collection.RemoveId(item.ID)
+		return
+	}
+	logger.Warn("notificationQueue: notification failed, retrying", F("retryIn", delay.String()))
+	item.NextAttempt = time.Now().UTC().Add(delay)
+	updateErr := collection.UpdateId(item.ID, &item)
+	if updateErr != nil {
+		Log.Error("notificationQueue (update database)", F("error", updateErr))
+	}
+}
+
+// summarize replaces the digest notifications of the channel by a summary, sent as a regular notification
+func (q *notificationQueue) summarize(collection storeCollection, channel model.NotificationChannel) {
+	var items []queuedNotification
+	err := collection.Find(bson.M{"channel": channel.Name, "digest": true}).Sort("created").All(&items)
+	if err != nil || len(items) == 0 {
+		if err != nil {
+			Log.Error("notificationQueue (read digest)", F("error", err))
+		}
+		return
+	}
+	lines := []string{strconv.Itoa(len(items)) + " device status changes since " + items[0].Created.Format("15:04 MST") + ":"}
+	ids := make([]bson.ObjectId, len(items))
+	for i, item := range items {
+		lines = append(lines, "- "+item.Notification.Message)
+		ids[i] = item.ID
+	}
+	now := time.Now().UTC()
+	summary := queuedNotification{
+		ID:           bson.NewObjectId(),
+		Channel:      channel.Name,
+		Notification: Notification{Type: notificationDigest, Time: now, Message: strings.Join(lines, "\n")},
+		NextAttempt:  now,
+		Created:      now,
+	}
+	err = collection.Insert(&summary)
+	if err != nil {
+		Log.Error("notificationQueue (insert digest)", F("error", err))
+		return
+	}
+	_, err = collection.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		Log.Error("notificationQueue (remove digest)", F("error", err))
+	}
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
@@ -0,0 +1,429 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// notificationTimeout limits the time spent sending a notification to a channel
+const notificationTimeout = 10 * time.Second
+
+// notificationClient is the HTTP client of the notification channels
+var notificationClient = &http.Client{Timeout: notificationTimeout}
+
+// redactedSecret replaces the secrets of the notification channels in the settings returned by the API
+const redactedSecret = "********"
+
+// webhookSignatureHeader has the HMAC-SHA256 of the webhook payload, as "sha256=<hex>"
+const webhookSignatureHeader = "X-ZTP-Signature"
+
+// Notification is a message sent to the notification channels. Message is rendered from the
+// template of the type, the other fields can be used in the templates
+type Notification struct {
+	// Type is one of the model.Notify types, used by the channels to select the notifications
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	Serial   string    `json:"serial,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	// Object is the name of the image, scope or file of the notification
+	Object string `json:"object,omitempty"`
+	// Detail has more information, e.g. the reason of a failure
+	Detail  string `json:"detail,omitempty"`
+	Message string `json:"message"`
+}
+
+// notificationTypes are the notifications sent by the dashboard, with their default message
+var notificationTypes = []model.NotificationType{
+	{Type: model.NotifyDeviceCreate, Description: "A device is added", DefaultTemplate: "New device configuration added for {{.Hostname}}{{if .Serial}} (serial {{.Serial}}){{else}} bound to a switch port{{end}}."},
+	{Type: model.NotifyDeviceUpdate, Description: "A device is changed", DefaultTemplate: "Device {{.Hostname}} updated."},
+	{Type: model.NotifyDeviceDelete, Description: "A device is removed", DefaultTemplate: "Device {{.Hostname}} removed."},
+	{Type: model.NotifyDeviceSerial, Description: "A device bound to a switch port reports its serial", DefaultTemplate: "Device {{.Hostname}} learned serial {{.Serial}} on first contact."},
+	{Type: model.NotifyDeviceStatus, Description: "The provisioning status of a device changes", DefaultTemplate: "Device {{.Hostname}} (serial {{.Serial}}) is now {{.Status}}{{if .Object}}: {{.Object}}{{end}}."},
+	{Type: model.NotifyProvisionFailed, Description: "A device reports that its provisioning failed", DefaultTemplate: "Provisioning of device {{.Hostname}} (serial {{.Serial}}) failed{{if .Detail}}: {{.Detail}}{{end}}."},
+	{Type: model.NotifyDeviceTimedOut, Description: "A device stays in a provisioning status longer than its timeout", DefaultTemplate: "Device {{.Hostname}} (serial {{.Serial}}) timed out: {{.Detail}}."},
+	{Type: model.NotifyTestSucceeded, Description: "A provisioned device passes its test suites", DefaultTemplate: "Device {{.Hostname}} (serial {{.Serial}}) is reachable. Tests succeeded."},
+	{Type: model.NotifyTestFailed, Description: "A provisioned device fails tests of its suites", DefaultTemplate: "Device {{.Hostname}} (serial {{.Serial}}) failed its tests: {{.Detail}}."},
+	{Type: model.NotifyImageCreate, Description: "An image is uploaded", DefaultTemplate: "Image {{.Object}} uploaded for {{.Detail}}."},
+	{Type: model.NotifyScopeCreate, Description: "A DHCP scope is added", DefaultTemplate: "New DHCP scope {{.Object}} ({{.Detail}}) added."},
+	{Type: model.NotifyScopeUpdate, Description: "A DHCP scope is changed", DefaultTemplate: "DHCP scope {{.Object}} updated."},
+	{Type: model.NotifyScopeDelete, Description: "A DHCP scope is removed", DefaultTemplate: "DHCP scope {{.Object}} removed."},
+	{Type: model.NotifySettingsUpdate, Description: "The settings are changed", DefaultTemplate: "Settings changed."},
+}
+
+// notificationTypeList returns the notification types with the templates of the settings
+func notificationTypeList(templates []model.NotificationTemplate) []model.NotificationType {
+	custom := make(map[string]string)
+	for _, t := range templates {
+		custom[t.Type] = t.Template
+	}
+	list := make([]model.NotificationType, len(notificationTypes))
+	for i, notificationType := range notificationTypes {
+		notificationType.Template = notificationType.DefaultTemplate
+		if t, ok := custom[notificationType.Type]; ok {
+			notificationType.Template = t
+		}
+		list[i] = notificationType
+	}
+	return list
+}
+
+// renderNotification sets the message of the notification from the template of its type. The
+// default template is used if the custom one fails
+func renderNotification(notification *Notification, templates []model.NotificationTemplate) {
+	for _, notificationType := range notificationTypeList(templates) {
+		if notificationType.Type != notification.Type {
+			continue
+		}
+		message, err := executeNotificationTemplate(notificationType.Template, *notification)
+		if err != nil && notificationType.Template != notificationType.DefaultTemplate {
+			Log.Error("renderNotification (custom template)", F("type", notification.Type), F("error", err))
+			message, err = executeNotificationTemplate(notificationType.DefaultTemplate, *notification)
+		}
+		if err != nil {
+			Log.Error("renderNotification (default template)", F("type", notification.Type), F("error", err))
+			return
+		}
+		notification.Message = message
+		return
+	}
+}
+
+// executeNotificationTemplate renders a message template with the notification
+func executeNotificationTemplate(text string, notification Notification) (string, error) {
+	t, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var message bytes.Buffer
+	err = t.Execute(&message, notification)
+	return strings.TrimSpace(message.String()), err
+}
+
+// Notifier sends notifications to a channel
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// Notify renders the message of the notification and sends it in the background to the channels
+// configured in the settings
+func Notify(notification Notification) {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	go notifyChannels(notification)
+}
+
+// notifyChannels queues the notification for each enabled channel that selects its type
+func notifyChannels(notification Notification) {
+	settings, err := notificationSettings()
+	if err != nil {
+		Log.Error("notifyChannels (read settings)", F("error", err))
+		return
+	}
+	renderNotification(&notification, settings.NotificationTemplates)
+	for _, channel := range notificationChannels(settings) {
+		if !channel.Enabled || !(eventFilter{types: channel.Events}).matches(model.Event{Type: notification.Type}) {
+			continue
+		}
+		err := outbox.enqueue(channel, notification)
+		if err != nil {
+			Log.Error("notifyChannels (queue notification)", F("channel", channel.Name), F("type", notification.Type), F("error", err))
+		}
+	}
+}
+
+// notificationSettings reads the settings, which are empty if they were never saved
+func notificationSettings() (model.Settings, error) {
+	var settings []model.Settings
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		return model.Settings{}, err
+	}
+	defer session.Close()
+
+	err = session.DB("ztpDashboard").C("settings").Find(nil).All(&settings)
+	if err != nil || len(settings) == 0 {
+		return model.Settings{}, err
+	}
+	return settings[0], nil
+}
+
+// notificationChannels returns the channels of the settings. The Webex Teams room of the settings
+// is a channel receiving every notification
+func notificationChannels(settings model.Settings) []model.NotificationChannel {
+	channels := settings.NotificationChannels
+	if settings.WebexTeamsRoomID != "" {
+		channels = append([]model.NotificationChannel{{Name: "Webex Teams", Type: model.NotificationWebex, Enabled: true, RoomID: settings.WebexTeamsRoomID}}, channels...)
+	}
+	return channels
+}
+
+// newNotifier returns the notifier of the channel type
+func newNotifier(channel model.NotificationChannel) Notifier {
+	switch channel.Type {
+	case model.NotificationWebex:
+		return webexNotifier{roomID: channel.RoomID}
+	case model.NotificationSlack:
+		return slackNotifier{url: channel.URL}
+	case model.NotificationEmail:
+		return emailNotifier{server: channel.SMTPServer, username: channel.SMTPUsername, password: channel.SMTPPassword, from: channel.From, to: channel.To}
+	case model.NotificationWebhook:
+		return webhookNotifier{url: channel.URL, secret: channel.Secret}
+	}
+	return unknownNotifier{channelType: channel.Type}
+}
+
+// validateNotificationChannels returns a message describing the first invalid channel, or an empty string
+func validateNotificationChannels(channels []model.NotificationChannel) string {
+	names := make(map[string]bool)
+	for _, channel := range channels {
+		if channel.Name == "" {
+			return "Notification channels need a name"
+		}
+		if names[channel.Name] {
+			return "Notification channel " + channel.Name + " is duplicated"
+		}
+		names[channel.Name] = true
+		if channel.RateLimit < 0 || channel.DigestInterval < 0 {
+			return "Notification channel " + channel.Name + " has a negative rate limit or digest interval"
+		}
+
+		switch channel.Type {
+		case model.NotificationWebex:
+			if channel.RoomID == "" {
+				return "Notification channel " + channel.Name + " needs a Webex Teams room ID"
+			}
+		case model.NotificationSlack, model.NotificationWebhook:
+			if !strings.HasPrefix(channel.URL, "http://") && !strings.HasPrefix(channel.URL, "https://") {
+				return "Notification channel " + channel.Name + " needs an http or https URL"
+			}
+		case model.NotificationEmail:
+			if _, _, err := net.SplitHostPort(channel.SMTPServer); err != nil {
+				return "Notification channel " + channel.Name + " needs an SMTP server as host:port"
+			}
+			if channel.From == "" || len(channel.To) == 0 {
+				return "Notification channel " + channel.Name + " needs a sender and recipients"
+			}
+		default:
+			return "Notification channel " + channel.Name + " has an unknown type " + channel.Type
+		}
+	}
+	return ""
+}
+
+// validateNotificationTemplates returns a message describing the first invalid template, or an empty string
+func validateNotificationTemplates(templates []model.NotificationTemplate) string {
+	known := make(map[string]bool)
+	for _, notificationType := range notificationTypes {
+		known[notificationType.Type] = true
+	}
+	seen := make(map[string]bool)
+	for _, t := range templates {
+		if !known[t.Type] {
+			return "Unknown notification type " + t.Type
+		}
+		if seen[t.Type] {
+			return "Notification template " + t.Type + " is duplicated"
+		}
+		seen[t.Type] = true
+		// Check the fields used by the template with an empty notification
+		_, err := executeNotificationTemplate(t.Template, Notification{Type: t.Type})
+		if err != nil {
+			return "Notification template " + t.Type + " is invalid: " + err.Error()
+		}
+	}
+	return ""
+}
+
+// redactNotificationSecrets returns a copy of the settings without the channel secrets and the
+// routing keys of the alert sinks. Slack and webhook URLs are redacted too since they carry the
+// token of the incoming webhook
+func redactNotificationSecrets(settings model.Settings) model.Settings {
+	channels := make([]model.NotificationChannel, len(settings.NotificationChannels))
+	for i, channel := range settings.NotificationChannels {
+		if channel.URL != "" && (channel.Type == model.NotificationSlack || channel.Type == model.NotificationWebhook) {
+			channel.URL = redactedSecret
+		}
+		if channel.Secret != "" {
+			channel.Secret = redactedSecret
+		}
+		if channel.SMTPPassword != "" {
+			channel.SMTPPassword = redactedSecret
+		}
+		channels[i] = channel
+	}
+	settings.NotificationChannels = channels
+	sinks := make([]model.AlertSink, len(settings.AlertSinks))
+	for i, sink := range settings.AlertSinks {
+		if sink.RoutingKey != "" {
+			sink.RoutingKey = redactedSecret
+		}
+		sinks[i] = sink
+	}
+	settings.AlertSinks = sinks
+	return settings
+}
+
+// restoreNotificationSecrets keeps the stored secrets of the channels and alert sinks saved with
+// redacted secrets
+func restoreNotificationSecrets(settings *model.Settings, before *model.Settings) {
+	if before == nil {
+		return
+	}
+	previous := make(map[string]model.NotificationChannel)
+	for _, channel := range before.NotificationChannels {
+		previous[channel.Name] = channel
+	}
+	for i := range settings.NotificationChannels {
+		channel := &settings.NotificationChannels[i]
+		if channel.URL == redactedSecret {
+			channel.URL = previous[channel.Name].URL
+		}
+		if channel.Secret == redactedSecret {
+			channel.Secret = previous[channel.Name].Secret
+		}
+		if channel.SMTPPassword == redactedSecret {
+			channel.SMTPPassword = previous[channel.Name].SMTPPassword
+		}
+	}
+	previousSinks := make(map[string]model.AlertSink)
+	for _, sink := range before.AlertSinks {
+		previousSinks[sink.Name] = sink
+	}
+	for i := range settings.AlertSinks {
+		sink := &settings.AlertSinks[i]
+		if sink.RoutingKey == redactedSecret {
+			sink.RoutingKey = previousSinks[sink.Name].RoutingKey
+		}
+	}
+}
+
+// webexNotifier posts the notifications in a Webex Teams room
+type webexNotifier struct {
+	roomID string
+}
+
+func (n webexNotifier) Notify(notification Notification) error {
+	return WebexTeamsCtl.SendMessage(n.roomID, notification.Message)
+}
+
+// slackNotifier posts the notifications to a Slack incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+func (n slackNotifier) Notify(notification Notification) error {
+	payload, err := json.Marshal(map[string]string{"text": notification.Message})
+	if err != nil {
+		return err
+	}
+	return postNotification(n.url, payload, nil)
+}
+
+// webhookNotifier posts the notifications as JSON. When a secret is set, the payload is signed
+// with HMAC-SHA256 in the X-ZTP-Signature header
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+func (n webhookNotifier) Notify(notification Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-ZTP-Event": notification.Type}
+	if n.secret != "" {
+		headers[webhookSignatureHeader] = "sha256=" + signPayload(n.secret, payload)
+	}
+	return postNotification(n.url, payload, headers)
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the payload
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postNotification posts a JSON payload and checks the status code
+func postNotification(url string, payload []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseDeliveryError("notification endpoint", resp)
+	}
+	return nil
+}
+
+// emailNotifier sends the notifications by email. The SMTP server authentication is used when a
+// username is set, and STARTTLS when the server offers it
+type emailNotifier struct {
+	server   string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (n emailNotifier) Notify(notification Notification) error {
+	host, _, err := net.SplitHostPort(n.server)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+	subject := "ZTP Dashboard: " + notification.Type
+	if notification.Hostname != "" {
+		subject += " " + notification.Hostname
+	}
+	message := "From: " + n.from + "\r\n" +
+		"To: " + strings.Join(n.to, ", ") + "\r\n" +
+		"Subject: " + headerValue(subject) + "\r\n" +
+		"Date: " + notification.Time.Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(notification.Message, "\n", "\r\n", -1) + "\r\n"
+	return smtpDeliveryError(smtp.SendMail(n.server, auth, n.from, n.to, []byte(message)))
+}
+
+// headerValue removes the line breaks that would end a mail header
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// unknownNotifier fails for channels of an unknown type stored before validation
+type unknownNotifier struct {
+	channelType string
+}
+
+func (n unknownNotifier) Notify(notification Notification) error {
+	return errors.New("unknown notification channel type " + n.channelType)
+}
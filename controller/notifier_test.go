@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo/bson"
+)
+
+// notificationMarker is in the notifications of the tests, the ones of other tests are ignored
+const notificationMarker = "notify"
+
+type recordedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// requestRecorder is an HTTP endpoint keeping the requests it receives
+type requestRecorder struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []recordedRequest
+}
+
+func newRequestRecorder(t *testing.T) *requestRecorder {
+	recorder := &requestRecorder{}
+	recorder.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		if strings.Contains(string(body), notificationMarker) {
+			recorder.requests = append(recorder.requests, recordedRequest{header: r.Header, body: body})
+		}
+	}))
+	t.Cleanup(recorder.Close)
+	return recorder
+}
+
+func (r *requestRecorder) received() []recordedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]recordedRequest{}, r.requests...)
+}
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStub is a mail server accepting every message, without authentication or STARTTLS
+type smtpStub struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	messages []smtpMessage
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP stub")
+	message := smtpMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			message.from = strings.Trim(strings.TrimPrefix(line[len("MAIL"):], " FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			message.to = append(message.to, strings.Trim(strings.TrimPrefix(line[len("RCPT"):], " TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			if strings.Contains(message.data, notificationMarker) {
+				s.mutex.Lock()
+				s.messages = append(s.messages, message)
+				s.mutex.Unlock()
+			}
+			message = smtpMessage{}
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpStub) received() []smtpMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]smtpMessage{}, s.messages...)
+}
+
+// saveTestSettings stores the settings
+func saveTestSettings(t *testing.T, settings model.Settings) {
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.DB("ztpDashboard").C("settings").Insert(&settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotificationChannels(t *testing.T) {
+	resetTestStore(t)
+	slack := newRequestRecorder(t)
+	webhook := newRequestRecorder(t)
+	mail := newSMTPStub(t)
+	saveTestSettings(t, model.Settings{NotificationChannels: []model.NotificationChannel{
+		{Name: "slack", Type: model.NotificationSlack, Enabled: true, Events: []string{model.NotifyDeviceCreate, model.NotifyDeviceStatus}, URL: slack.URL},
+		{Name: "webhook", Type: model.NotificationWebhook, Enabled: true, Events: []string{"image"}, URL: webhook.URL, Secret: "s3cret"},
+		{Name: "email", Type: model.NotificationEmail, Enabled: true, Events: []string{"device.create"}, SMTPServer: mail.listener.Addr().String(), From: "ztp@example.com", To: []string{"ops@example.com", "noc@example.com"}},
+		{Name: "disabled", Type: model.NotificationSlack, Enabled: false, URL: slack.URL},
+	}})
+
+	created := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	notifyChannels(Notification{Type: model.NotifyDeviceCreate, Time: created, Hostname: "notify1", Serial: "NOTIFY1"})
+	notifyChannels(Notification{Type: model.NotifyImageCreate, Time: created, Object: "notify.iso", Detail: "NX-OS"})
+	notifyChannels(Notification{Type: model.NotifyDeviceDelete, Time: created, Hostname: "notify1"})
+	outbox.process()
+
+	// Slack gets the device creation only, once although the disabled channel has the same URL
+	requests := slack.received()
+	if len(requests) != 1 {
+		t.Fatalf("slack received %d notifications, want 1", len(requests))
+	}
+	if contentType := requests[0].header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("slack Content-Type is %q, want application/json", contentType)
+	}
+	if body := string(requests[0].body); body != `{"text":"New device configuration added for notify1 (serial NOTIFY1)."}` {
+		t.Errorf("slack payload is %s", body)
+	}
+
+	// The webhook gets the image upload only, signed with the secret
+	requests = webhook.received()
+	if len(requests) != 1 {
+		t.Fatalf("webhook received %d notifications, want 1", len(requests))
+	}
+	if event := requests[0].header.Get("X-ZTP-Event"); event != model.NotifyImageCreate {
+		t.Errorf("webhook X-ZTP-Event is %q, want %s", event, model.NotifyImageCreate)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(requests[0].body)
+	if signature := requests[0].header.Get("X-ZTP-Signature"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("webhook X-ZTP-Signature %q does not sign the payload", signature)
+	}
+	var notification Notification
+	err := json.Unmarshal(requests[0].body, &notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Notification{Type: model.NotifyImageCreate, Time: created, Object: "notify.iso", Detail: "NX-OS", Message: "Image notify.iso uploaded for NX-OS."}
+	if notification != want {
+		t.Errorf("webhook payload is %+v, want %+v", notification, want)
+	}
+
+	// The email channel gets the device creation only, sent to every recipient
+	messages := mail.received()
+	if len(messages) != 1 {
+		t.Fatalf("mail server received %d messages, want 1", len(messages))
+	}
+	if messages[0].from != "ztp@example.com" || strings.Join(messages[0].to, ",") != "ops@example.com,noc@example.com" {
+		t.Errorf("mail sent from %s to %v", messages[0].from, messages[0].to)
+	}
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(messages[0].data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := header.Get("Subject"); subject != "ZTP Dashboard: device.create notify1" {
+		t.Errorf("mail subject is %q", subject)
+	}
+	if to := header.Get("To"); to != "ops@example.com, noc@example.com" {
+		t.Errorf("mail To header is %q", to)
+	}
+	if !strings.Contains(messages[0].data, "\n\nNew device configuration added for notify1 (serial NOTIFY1).\n") {
+		t.Errorf("mail body is %q", messages[0].data)
+	}
+
+	// Every notification was delivered
+	if queued := len(testStore.documents("notificationQueue")); queued != 0 {
+		t.Errorf("%d notifications left in the queue", queued)
+	}
+}
+
+func TestNotificationRateLimitedChannel(t *testing.T) {
+	resetTestStore(t)
+	limited := newRequestRecorder(t)
+	other := newRequestRecorder(t)
+	saveTestSettings(t, model.Settings{NotificationChannels: []model.NotificationChannel{
+		{Name: "notify-limited", Type: model.NotificationSlack, Enabled: true, URL: limited.URL, RateLimit: 1},
+		{Name: "notify-other", Type: model.NotificationSlack, Enabled: true, URL: other.URL},
+	}})
+	t.Cleanup(func() { delete(outbox.nextSend, "notify-limited") })
+
+	// More notifications than a batch wait for the limited channel, before the one of the other channel
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	collection := session.DB("ztpDashboard").C("notificationQueue")
+	due := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i <= notificationQueueBatch; i++ {
+		err = collection.Insert(&queuedNotification{ID: bson.NewObjectId(), Channel: "notify-limited", Notification: Notification{Message: "notify " + strconv.Itoa(i)}, NextAttempt: due.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = collection.Insert(&queuedNotification{ID: bson.NewObjectId(), Channel: "notify-other", Notification: Notification{Message: "notify other"}, NextAttempt: due.Add(time.Hour / 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first pass sends one notification of the limited channel, the next ones skip the channel
+	outbox.process()
+	outbox.process()
+	if received := len(limited.received()); received != 1 {
+		t.Errorf("limited channel received %d notifications, want 1", received)
+	}
+	if received := other.received(); len(received) != 1 || string(received[0].body) != `{"text":"notify other"}` {
+		t.Errorf("other channel received %d notifications, want 1", len(received))
+	}
+}
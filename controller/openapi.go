@@ -0,0 +1,552 @@
+package controller
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/gorilla/mux"
+)
+
+// openAPIParameter is a query or path parameter of an operation
+type openAPIParameter struct {
+	name        string
+	in          string
+	description string
+}
+
+// openAPIOperation documents one method of an API route. Request and response bodies are given as
+// values of the model types, their schemas are generated from the struct fields and json tags
+type openAPIOperation struct {
+	path       string
+	method     string
+	tag        string
+	summary    string
+	parameters []openAPIParameter
+	// request is the JSON body, or multipartImage for image uploads
+	request interface{}
+	// response is the JSON body of a successful request. nil means the plain text "ok"
+	response interface{}
+	status   int
+	// public operations do not need authentication
+	public bool
+}
+
+// multipartImage marks the operations that receive an image as a multipart form
+type multipartImage struct{}
+
+// noContent marks the operations that return an empty body
+type noContent struct{}
+
+// eventStream marks the operations that stream model.Event values as Server-Sent Events
+type eventStream struct{}
+
+// Common parameters
+var (
+	serialPath   = openAPIParameter{name: "serial", in: "path", description: "Serial of the device, or hostname for devices without serial"}
+	configPath   = openAPIParameter{name: "name", in: "path", description: "Name of the config"}
+	imagePath    = openAPIParameter{name: "name", in: "path", description: "Name of the image"}
+	suitePath    = openAPIParameter{name: "name", in: "path", description: "Name of the test suite"}
+	serialQuery  = openAPIParameter{name: "serial", in: "query", description: "Serial of the device"}
+	nameQuery    = openAPIParameter{name: "name", in: "query", description: "Name of the object"}
+	usernameArgs = openAPIParameter{name: "username", in: "query", description: "Name of the user"}
+)
+
+// openAPIOperations lists every operation of the API. checkOpenAPIRoutes compares it with the routes
+// registered in the router, so new routes must be added here
+var openAPIOperations = []openAPIOperation{
+	// Devices
+	{path: "/api/devices", method: http.MethodGet, tag: "devices", summary: "List devices", response: []model.Device{}, parameters: listParameters(deviceListFields)},
+	{path: "/api/devices", method: http.MethodPost, tag: "devices", summary: "Create a device", request: model.Device{}},
+	{path: "/api/devices", method: http.MethodPut, tag: "devices", summary: "Update the image, config, switch port binding and scope of a device, identified by hostname", request: model.Device{}},
+	{path: "/api/devices", method: http.MethodDelete, tag: "devices", summary: "Delete a device by serial or hostname", parameters: []openAPIParameter{serialQuery, {name: "hostname", in: "query", description: "Hostname of the device"}}},
+	{path: "/api/devices/types", method: http.MethodGet, tag: "devices", summary: "List device types", response: []model.DeviceType{}},
+	{path: "/api/devices/provisioned", method: http.MethodPut, tag: "devices", summary: "Called by devices when provisioning ends. The device is identified by its source address", parameters: []openAPIParameter{serialQuery, {name: "status", in: "query", description: "started when the script starts, failed when the provisioning failed"}, {name: "detail", in: "query", description: "Reason of the failure"}}, public: true},
+
+	// Configs and images
+	{path: "/api/configs", method: http.MethodGet, tag: "configs", summary: "List configs", response: []model.Config{}, parameters: listParameters(configListFields)},
+	{path: "/api/configs", method: http.MethodPost, tag: "configs", summary: "Create a config", request: model.Config{}},
+	{path: "/api/images", method: http.MethodGet, tag: "images", summary: "List images", response: []model.Image{}, parameters: listParameters(imageListFields)},
+	{path: "/api/images", method: http.MethodPost, tag: "images", summary: "Upload an image", request: multipartImage{}},
+
+	// Settings
+	{path: "/api/settings", method: http.MethodGet, tag: "settings", summary: "Get the settings", response: model.Settings{}},
+	{path: "/api/settings", method: http.MethodPost, tag: "settings", summary: "Replace the settings", request: model.Settings{}},
+	{path: "/api/settings/notifications", method: http.MethodGet, tag: "settings", summary: "List the notification types with their message templates", response: []model.NotificationType{}},
+
+	// DHCP scopes and leases
+	{path: "/api/scopes", method: http.MethodGet, tag: "dhcp", summary: "List DHCP scopes", response: []model.Scope{}},
+	{path: "/api/scopes", method: http.MethodPost, tag: "dhcp", summary: "Create a DHCP scope", request: model.Scope{}},
+	{path: "/api/scopes", method: http.MethodPut, tag: "dhcp", summary: "Update a DHCP scope", request: model.Scope{}},
+	{path: "/api/scopes", method: http.MethodDelete, tag: "dhcp", summary: "Delete a DHCP scope not used by devices", parameters: []openAPIParameter{nameQuery}},
+	{path: "/api/leases", method: http.MethodGet, tag: "dhcp", summary: "List active DHCP leases", response: []model.Lease{}},
+	{path: "/api/leases/unknown", method: http.MethodGet, tag: "dhcp", summary: "List DHCP clients not matching any device", response: []model.Lease{}},
+	{path: "/api/leases/adopt", method: http.MethodPost, tag: "dhcp", summary: "Create a device for an unknown DHCP client", request: model.AdoptRequest{}},
+
+	// Users, tokens and audit
+	{path: "/api/users", method: http.MethodGet, tag: "users", summary: "List users", response: []model.User{}},
+	{path: "/api/users", method: http.MethodPost, tag: "users", summary: "Create a user", request: model.User{}},
+	{path: "/api/users", method: http.MethodPut, tag: "users", summary: "Change the role or password of a user", request: model.User{}},
+	{path: "/api/users", method: http.MethodDelete, tag: "users", summary: "Delete a user", parameters: []openAPIParameter{usernameArgs}},
+	{path: "/api/users/me", method: http.MethodGet, tag: "users", summary: "Get the user or token of the request", response: model.Principal{}},
+	{path: "/api/tokens", method: http.MethodGet, tag: "users", summary: "List API tokens", response: []model.APIToken{}},
+	{path: "/api/tokens", method: http.MethodPost, tag: "users", summary: "Create an API token. The token is only returned here", request: model.APIToken{}, response: model.APIToken{}},
+	{path: "/api/tokens", method: http.MethodDelete, tag: "users", summary: "Delete an API token", parameters: []openAPIParameter{nameQuery}},
+	{path: "/api/audit", method: http.MethodGet, tag: "audit", summary: "List the audit trail. format=jsonl returns JSON lines", response: []model.AuditEntry{}, parameters: []openAPIParameter{
+		{name: "objectType", in: "query", description: "Type of the changed object"},
+		{name: "object", in: "query", description: "Name of the changed object"},
+		{name: "actor", in: "query", description: "User or token that made the change"},
+		{name: "from", in: "query", description: "RFC 3339 time of the oldest entry"},
+		{name: "to", in: "query", description: "RFC 3339 time of the newest entry"},
+		{name: "format", in: "query", description: "jsonl to export JSON lines"},
+	}},
+	{path: "/api/events", method: http.MethodGet, tag: "events", summary: "Stream events as Server-Sent Events. Each data line is an Event. Send Last-Event-ID to get the events missed while disconnected", response: eventStream{}, parameters: []openAPIParameter{
+		{name: "device", in: "query", description: "Only stream the events of the device with this hostname or serial"},
+		{name: "type", in: "query", description: "Comma separated event types or prefixes, e.g. device.status,config"},
+	}},
+	{path: "/api/alerts", method: http.MethodGet, tag: "integrations", summary: "List the firing alerts", response: []model.Alert{}},
+	{path: webexWebhookPath, method: http.MethodPost, tag: "integrations", summary: "Webex Teams webhook of the bot commands, signed with WEBEX_WEBHOOK_SECRET in X-Spark-Signature", request: webexWebhookEvent{}, public: true},
+	{path: "/api/openapi.json", method: http.MethodGet, tag: "api", summary: "This document", response: map[string]interface{}{}, public: true},
+
+	// Versioned API
+	{path: "/api/v1/devices", method: http.MethodGet, tag: "v1", summary: "List devices", response: []model.Device{}, parameters: listParameters(deviceListFields)},
+	{path: "/api/v1/devices", method: http.MethodPost, tag: "v1", summary: "Create a device. Device type, image and config are referenced by name", request: model.Device{}, response: model.Device{}, status: http.StatusCreated},
+	{path: "/api/v1/devices/{serial}", method: http.MethodGet, tag: "v1", summary: "Get a device", parameters: []openAPIParameter{serialPath}, response: model.Device{}},
+	{path: "/api/v1/devices/{serial}", method: http.MethodPut, tag: "v1", summary: "Replace a device", parameters: []openAPIParameter{serialPath}, request: model.Device{}, response: model.Device{}},
+	{path: "/api/v1/devices/{serial}", method: http.MethodPatch, tag: "v1", summary: "Change the fields of a device present in the body", parameters: []openAPIParameter{serialPath}, request: model.Device{}, response: model.Device{}},
+	{path: "/api/v1/devices/{serial}", method: http.MethodDelete, tag: "v1", summary: "Delete a device", parameters: []openAPIParameter{serialPath}, response: noContent{}, status: http.StatusNoContent},
+	{path: "/api/v1/devices/{serial}/timeline", method: http.MethodGet, tag: "v1", summary: "List the statuses reached by a device, oldest first", parameters: []openAPIParameter{serialPath}, response: []model.DeviceStatusChange{}},
+	{path: "/api/v1/configs", method: http.MethodGet, tag: "v1", summary: "List configs", response: []model.Config{}, parameters: listParameters(configListFields)},
+	{path: "/api/v1/configs", method: http.MethodPost, tag: "v1", summary: "Create a config", request: model.Config{}, response: model.Config{}, status: http.StatusCreated},
+	{path: "/api/v1/configs/{name}", method: http.MethodGet, tag: "v1", summary: "Get a config", parameters: []openAPIParameter{configPath}, response: model.Config{}},
+	{path: "/api/v1/configs/{name}", method: http.MethodPut, tag: "v1", summary: "Replace a config", parameters: []openAPIParameter{configPath}, request: model.Config{}, response: model.Config{}},
+	{path: "/api/v1/configs/{name}", method: http.MethodPatch, tag: "v1", summary: "Change the fields of a config present in the body", parameters: []openAPIParameter{configPath}, request: model.Config{}, response: model.Config{}},
+	{path: "/api/v1/configs/{name}", method: http.MethodDelete, tag: "v1", summary: "Delete a config not used by devices", parameters: []openAPIParameter{configPath}, response: noContent{}, status: http.StatusNoContent},
+	{path: "/api/v1/images", method: http.MethodGet, tag: "v1", summary: "List images", response: []model.Image{}, parameters: listParameters(imageListFields)},
+	{path: "/api/v1/images", method: http.MethodPost, tag: "v1", summary: "Upload an image", request: multipartImage{}, response: model.Image{}, status: http.StatusCreated},
+	{path: "/api/v1/images/{name}", method: http.MethodGet, tag: "v1", summary: "Get an image", parameters: []openAPIParameter{imagePath}, response: model.Image{}},
+	{path: "/api/v1/images/{name}", method: http.MethodPut, tag: "v1", summary: "Replace the image file and device type", parameters: []openAPIParameter{imagePath}, request: multipartImage{}, response: model.Image{}},
+	{path: "/api/v1/images/{name}", method: http.MethodPatch, tag: "v1", summary: "Change the device type of an image", parameters: []openAPIParameter{imagePath}, request: model.Image{}, response: model.Image{}},
+	{path: "/api/v1/images/{name}", method: http.MethodDelete, tag: "v1", summary: "Delete an image not used by devices", parameters: []openAPIParameter{imagePath}, response: noContent{}, status: http.StatusNoContent},
+	{path: "/api/v1/devices/{serial}/tests", method: http.MethodGet, tag: "v1", summary: "List the test runs of a device, newest first", parameters: []openAPIParameter{serialPath, {name: "limit", in: "query", description: "Maximum number of runs, 50 by default"}}, response: []model.TestRun{}},
+	{path: "/api/v1/devices/{serial}/tests", method: http.MethodPost, tag: "v1", summary: "Run the test suites of a device again. The runs are returned when they start and are finished when their finish time is set", parameters: []openAPIParameter{serialPath, {name: "suite", in: "query", description: "Only run this suite"}}, response: []model.TestRun{}, status: http.StatusAccepted},
+	{path: "/api/v1/testsuites", method: http.MethodGet, tag: "v1", summary: "List test suites", response: []model.TestSuite{}},
+	{path: "/api/v1/testsuites", method: http.MethodPost, tag: "v1", summary: "Create a test suite", request: model.TestSuite{}, response: model.TestSuite{}, status: http.StatusCreated},
+	{path: "/api/v1/testsuites/{name}", method: http.MethodGet, tag: "v1", summary: "Get a test suite", parameters: []openAPIParameter{suitePath}, response: model.TestSuite{}},
+	{path: "/api/v1/testsuites/{name}", method: http.MethodPut, tag: "v1", summary: "Replace a test suite", parameters: []openAPIParameter{suitePath}, request: model.TestSuite{}, response: model.TestSuite{}},
+	{path: "/api/v1/testsuites/{name}", method: http.MethodDelete, tag: "v1", summary: "Delete a test suite", parameters: []openAPIParameter{suitePath}, response: noContent{}, status: http.StatusNoContent},
+}
+
+// listParameters documents the filter, sort and page parameters of a list endpoint. The total
+// count is returned in the X-Total-Count header
+func listParameters(fields map[string]listField) []openAPIParameter {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parameters := []openAPIParameter{}
+	for _, name := range names {
+		description := "Only return the objects with this " + name
+		if fields[name].prefix {
+			description = "Only return the objects whose " + name + " starts with this value"
+		}
+		parameters = append(parameters, openAPIParameter{name: name, in: "query", description: description})
+	}
+	return append(parameters,
+		openAPIParameter{name: "sort", in: "query", description: "Sort by this field: " + strings.Join(names, ", ")},
+		openAPIParameter{name: "order", in: "query", description: "asc (default) or desc"},
+		openAPIParameter{name: "limit", in: "query", description: "Maximum number of objects returned, up to " + strconv.Itoa(maxListLimit)},
+		openAPIParameter{name: "offset", in: "query", description: "Number of objects skipped"},
+	)
+}
+
+// openAPIController serves the OpenAPI 3 document of the API
+type openAPIController struct {
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (o openAPIController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/openapi.json", o.handleOpenAPI)
+}
+
+// handleOpenAPI returns the OpenAPI document
+func (o openAPIController) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	js, err := json.MarshalIndent(OpenAPIDocument(), "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleOpenAPI (encode json)", F("error", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// OpenAPIDocument builds the OpenAPI 3 document from openAPIOperations and the model types
+func OpenAPIDocument() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+
+	for _, operation := range openAPIOperations {
+		if paths[operation.path] == nil {
+			paths[operation.path] = make(map[string]interface{})
+		}
+		paths[operation.path][strings.ToLower(operation.method)] = openAPIOperationObject(operation, schemas)
+	}
+	schemas["APIError"] = openAPISchema(reflect.TypeOf(model.APIError{}), schemas)
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "ZTP Dashboard API",
+			"version":     "1.0.0",
+			"description": "Zero touch provisioning of Cisco IOS-XR and NX-OS devices. The /api/v1 routes return errors with the APIError body, the other routes with plain text.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"cookieAuth": []string{}},
+		},
+	}
+}
+
+// openAPIOperationObject builds the operation object and adds the schemas it uses
+func openAPIOperationObject(operation openAPIOperation, schemas map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"tags":    []string{operation.tag},
+		"summary": operation.summary,
+	}
+	if operation.public {
+		result["security"] = []interface{}{}
+	}
+
+	parameters := []interface{}{}
+	for _, parameter := range operation.parameters {
+		parameters = append(parameters, map[string]interface{}{
+			"name":        parameter.name,
+			"in":          parameter.in,
+			"description": parameter.description,
+			"required":    parameter.in == "path",
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(parameters) > 0 {
+		result["parameters"] = parameters
+	}
+
+	switch operation.request.(type) {
+	case nil:
+	case multipartImage:
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"name":       map[string]interface{}{"type": "string"},
+							"deviceType": map[string]interface{}{"type": "string"},
+							"file":       map[string]interface{}{"type": "string", "format": "binary"},
+						},
+					},
+				},
+			},
+		}
+	default:
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(operation.request), schemas)},
+			},
+		}
+	}
+
+	status := operation.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch operation.response.(type) {
+	case nil:
+		success["content"] = map[string]interface{}{
+			"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	case noContent:
+	case eventStream:
+		success["content"] = map[string]interface{}{
+			"text/event-stream": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(model.Event{}), schemas)},
+		}
+	default:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(operation.response), schemas)},
+		}
+	}
+	responses := map[string]interface{}{strconv.Itoa(status): success}
+	if strings.HasPrefix(operation.path, "/api/v1/") {
+		responses["default"] = map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/APIError"}},
+			},
+		}
+	}
+	result["responses"] = responses
+	return result
+}
+
+// openAPISchema returns the schema of a Go type. Structs are added to schemas and referenced by name
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if _, present := schemas[t.Name()]; !present {
+			// Placeholder so recursive types do not loop
+			schemas[t.Name()] = nil
+			properties := make(map[string]interface{})
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if name == "-" || field.PkgPath != "" {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+				properties[name] = openAPISchema(field.Type, schemas)
+			}
+			schemas[t.Name()] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// OpenAPIDrift compares the /api operations registered in the router with the documented ones. The
+// methods of a route are the ones its handler switches on, read from the Go files of sourceDir. It
+// returns a description of each operation that is not documented and each documented operation
+// that is not registered
+func OpenAPIDrift(r *mux.Router, sourceDir string) ([]string, error) {
+	methods, err := handlerMethods(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	documented := make(map[string]bool)
+	for _, operation := range openAPIOperations {
+		documented[operation.method+" "+operation.path] = true
+	}
+
+	drift := []string{}
+	registered := make(map[string]bool)
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		// Prefix routes (e.g. the /api/v1/ not found handler) are not operations
+		if err != nil || !strings.HasPrefix(path, "/api/") || strings.HasSuffix(path, "/") {
+			return nil
+		}
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			name := handlerName(route.GetHandler())
+			routeMethods = methods[name]
+			if len(routeMethods) == 0 {
+				drift = append(drift, "Route "+path+": methods of handler "+name+" not found")
+			}
+		}
+		for _, method := range routeMethods {
+			registered[method+" "+path] = true
+		}
+		return nil
+	})
+
+	for operation := range registered {
+		if !documented[operation] {
+			drift = append(drift, "Route "+operation+" is not documented in the OpenAPI document")
+		}
+	}
+	for operation := range documented {
+		if !registered[operation] {
+			drift = append(drift, "Documented operation "+operation+" is not registered")
+		}
+	}
+	sort.Strings(drift)
+	return drift, nil
+}
+
+// httpMethods maps the method constants of net/http to the methods
+var httpMethods = map[string]string{
+	"MethodGet":     http.MethodGet,
+	"MethodHead":    http.MethodHead,
+	"MethodPost":    http.MethodPost,
+	"MethodPut":     http.MethodPut,
+	"MethodPatch":   http.MethodPatch,
+	"MethodDelete":  http.MethodDelete,
+	"MethodOptions": http.MethodOptions,
+}
+
+// handlerMethods returns the methods handled by each function of the Go files of dir, by name such
+// as apiV1Controller.handleDevices. They are the cases of the switch statements on the method of
+// the request, and the methods compared with it, e.g. in r.Method != http.MethodGet
+func handlerMethods(dir string) (map[string][]string, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	methods := make(map[string][]string)
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				function, ok := decl.(*ast.FuncDecl)
+				if !ok || function.Body == nil {
+					continue
+				}
+				name := function.Name.Name
+				if function.Recv != nil && len(function.Recv.List) == 1 {
+					receiver := function.Recv.List[0].Type
+					if star, ok := receiver.(*ast.StarExpr); ok {
+						receiver = star.X
+					}
+					if ident, ok := receiver.(*ast.Ident); ok {
+						name = ident.Name + "." + name
+					}
+				}
+				if found := requestMethods(function); len(found) > 0 {
+					methods[name] = found
+				}
+			}
+		}
+	}
+	return methods, nil
+}
+
+// requestMethods returns the methods a handler function compares the method of its request with
+func requestMethods(function *ast.FuncDecl) []string {
+	// Name of the *http.Request parameter
+	request := ""
+	for _, param := range function.Type.Params.List {
+		if star, ok := param.Type.(*ast.StarExpr); ok {
+			if selector, ok := star.X.(*ast.SelectorExpr); ok && selector.Sel.Name == "Request" && len(param.Names) == 1 {
+				request = param.Names[0].Name
+			}
+		}
+	}
+	if request == "" {
+		return nil
+	}
+	isRequestMethod := func(expr ast.Expr) bool {
+		selector, ok := expr.(*ast.SelectorExpr)
+		if !ok || selector.Sel.Name != "Method" {
+			return false
+		}
+		ident, ok := selector.X.(*ast.Ident)
+		return ok && ident.Name == request
+	}
+
+	seen := make(map[string]bool)
+	var found []string
+	add := func(expr ast.Expr) {
+		method := ""
+		switch value := expr.(type) {
+		case *ast.SelectorExpr:
+			method = httpMethods[value.Sel.Name]
+		case *ast.BasicLit:
+			method, _ = strconv.Unquote(value.Value)
+		}
+		if method != "" && !seen[method] {
+			seen[method] = true
+			found = append(found, method)
+		}
+	}
+	ast.Inspect(function.Body, func(node ast.Node) bool {
+		switch statement := node.(type) {
+		case *ast.SwitchStmt:
+			if statement.Tag == nil || !isRequestMethod(statement.Tag) {
+				return true
+			}
+			for _, clause := range statement.Body.List {
+				for _, expr := range clause.(*ast.CaseClause).List {
+					add(expr)
+				}
+			}
+		case *ast.BinaryExpr:
+			if statement.Op != token.EQL && statement.Op != token.NEQ {
+				return true
+			}
+			if isRequestMethod(statement.X) {
+				add(statement.Y)
+			} else if isRequestMethod(statement.Y) {
+				add(statement.X)
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// handlerName returns the name of the function of a handler as used by handlerMethods, e.g.
+// apiV1Controller.handleDevices for a.handleDevices
+func handlerName(handler http.Handler) string {
+	value := reflect.ValueOf(handler)
+	if value.Kind() != reflect.Func {
+		return value.Type().String()
+	}
+	name := runtime.FuncForPC(value.Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimPrefix(name, "controller.")
+	name = strings.TrimSuffix(name, "-fm")
+	return strings.NewReplacer("(*", "", ")", "").Replace(name)
+}
+
+// checkOpenAPIRoutes logs the differences between the router and the OpenAPI document
+func checkOpenAPIRoutes(r *mux.Router) {
+	drift, err := OpenAPIDrift(r, basePath+"/controller")
+	if err != nil {
+		Log.Error("checkOpenAPIRoutes (read handlers)", F("error", err))
+		return
+	}
+	for _, message := range drift {
+		Log.Error("checkOpenAPIRoutes: " + message)
+	}
+}
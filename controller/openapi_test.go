@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	r := mux.NewRouter()
+	registerAllRoutes(r)
+	drift, err := OpenAPIDrift(r, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range drift {
+		t.Error(message)
+	}
+}
+
+func TestOpenAPIDriftComparesMethods(t *testing.T) {
+	r := mux.NewRouter()
+	registerAllRoutes(r)
+	// A method the handler does not document, given with a matcher
+	r.HandleFunc("/api/v1/devices", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodOptions)
+	drift, err := OpenAPIDrift(r, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], "OPTIONS /api/v1/devices is not documented") {
+		t.Errorf("drift = %q, want the undocumented OPTIONS route", drift)
+	}
+
+	// Documented operations of handlers that are not registered
+	drift, err = OpenAPIDrift(mux.NewRouter(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != len(openAPIOperations) {
+		t.Errorf("got %d drift messages for an empty router, want %d", len(drift), len(openAPIOperations))
+	}
+}
+
+func TestRequestMethods(t *testing.T) {
+	methods, err := handlerMethods(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		// switch r.Method
+		"apiV1Controller.handleDevice": {http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		// if r.Method != http.MethodGet
+		"openAPIController.handleOpenAPI": {http.MethodGet},
+	}
+	for name, want := range tests {
+		if got := methods[name]; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("methods of %s = %v, want %v", name, got, want)
+		}
+	}
+}
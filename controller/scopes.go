@@ -0,0 +1,365 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// Default lease times used when a scope does not define them
+const defaultLeaseTime = 900
+const defaultMaxLeaseTime = 900
+
+// scopeController manages the DHCP scopes (subnets and sites) served by the dashboard
+type scopeController struct {
+	db dbController
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (s scopeController) registerRoutes(r *mux.Router) {
+	r.HandleFunc("/api/scopes", s.handleAPIScopes)
+}
+
+// ScopeNetwork parses the subnet of the scope
+func ScopeNetwork(scope model.Scope) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(scope.Subnet)
+	return network, err
+}
+
+// IsIPv6Scope tells if the scope serves DHCPv6 clients
+func IsIPv6Scope(scope model.Scope) bool {
+	network, err := ScopeNetwork(scope)
+	if err != nil {
+		return false
+	}
+	return network.IP.To4() == nil
+}
+
+// defaultScopes builds the scopes taken from environment variables. They are used for devices
+// without scope assigned
+func (s scopeController) defaultScopes() (v4 *model.Scope, v6 *model.Scope) {
+	if os.Getenv("DHCP_SUBNET") != "" {
+		prefix, _ := net.IPMask(net.ParseIP(os.Getenv("DHCP_SUBNET_NETMASK")).To4()).Size()
+		v4 = &model.Scope{
+			Name:        "default",
+			Subnet:      (&net.IPNet{IP: net.ParseIP(os.Getenv("DHCP_SUBNET")), Mask: net.CIDRMask(prefix, 32)}).String(),
+			NameServers: splitList(os.Getenv("DHCP_NAMESERVERS")),
+			Domain:      os.Getenv("DHCP_DOMAIN"),
+		}
+	}
+	if os.Getenv("DHCP6_SUBNET") != "" {
+		v6 = &model.Scope{
+			Name:        "default",
+			Subnet:      os.Getenv("DHCP6_SUBNET") + "/" + os.Getenv("DHCP6_SUBNET_NETMASK"),
+			NameServers: splitList(os.Getenv("DHCP6_NAMESERVERS")),
+			Domain:      os.Getenv("DHCP6_DOMAIN"),
+		}
+		if v6.Domain == "" {
+			v6.Domain = os.Getenv("DHCP_DOMAIN")
+		}
+	}
+	return v4, v6
+}
+
+// splitList splits a list of values separated by commas or spaces
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
+
+// GetScopes returns all scopes defined in database
+func (s scopeController) GetScopes() ([]model.Scope, error) {
+	var scopes []model.Scope
+
+	// Open database
+	session, err := s.db.OpenSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("scope")
+
+	err = dbCollection.Find(nil).All(&scopes)
+	if err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// ScopeForDevice returns the scope the device belongs to. Devices without scope use the default
+// scope of their address family
+func (s scopeController) ScopeForDevice(device model.Device, scopes []model.Scope) *model.Scope {
+	if device.Scope == "" {
+		v4, v6 := s.defaultScopes()
+		if net.ParseIP(device.Fixedip) != nil && net.ParseIP(device.Fixedip).To4() == nil {
+			return v6
+		}
+		return v4
+	}
+	for i := range scopes {
+		if scopes[i].Name == device.Scope {
+			return &scopes[i]
+		}
+	}
+	return nil
+}
+
+// validateScope checks the attributes of a scope. An error message is returned if the scope is not valid
+func (s scopeController) validateScope(scope *model.Scope) string {
+	if scope.Name == "" {
+		return "Scope name is required"
+	}
+	if scope.Name == "default" {
+		return "Scope name default is reserved for the scopes taken from environment variables"
+	}
+	network, err := ScopeNetwork(*scope)
+	if err != nil {
+		return "Invalid subnet " + scope.Subnet + ". Use CIDR notation"
+	}
+	isIPv6 := network.IP.To4() == nil
+	if scope.Gateway != "" {
+		if isIPv6 {
+			return "IPv6 scopes cannot have a gateway. Routers are announced by router advertisements"
+		}
+		if !network.Contains(net.ParseIP(scope.Gateway)) {
+			return "Gateway " + scope.Gateway + " is not inside subnet " + scope.Subnet
+		}
+	}
+	for _, server := range scope.NameServers {
+		ip := net.ParseIP(server)
+		if ip == nil || (ip.To4() == nil) != isIPv6 {
+			return "Invalid name server " + server
+		}
+	}
+	if scope.ZtpServer != "" {
+		ip := net.ParseIP(scope.ZtpServer)
+		if ip == nil || (ip.To4() == nil) != isIPv6 {
+			return "Invalid ZTP server address " + scope.ZtpServer
+		}
+	}
+	if scope.DefaultLeaseTime < 0 || scope.MaxLeaseTime < 0 {
+		return "Lease times cannot be negative"
+	}
+	if scope.DefaultLeaseTime == 0 {
+		scope.DefaultLeaseTime = defaultLeaseTime
+	}
+	if scope.MaxLeaseTime == 0 {
+		scope.MaxLeaseTime = defaultMaxLeaseTime
+	}
+	if scope.MaxLeaseTime < scope.DefaultLeaseTime {
+		return "Max lease time cannot be lower than default lease time"
+	}
+	if message := validateRanges(*scope, network); message != "" {
+		return message
+	}
+	// Store the subnet normalized
+	scope.Subnet = network.String()
+	return ""
+}
+
+// handleAPIScopes will be executed when a request to /api/scopes is done
+func (s scopeController) handleAPIScopes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	// If method is POST, create a new object
+	case http.MethodPost:
+		// Decode the request body into an Scope model.
+		dec := json.NewDecoder(r.Body)
+		scope := &model.Scope{}
+		err := dec.Decode(scope)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (decode json)", F("error", err))
+			return
+		}
+
+		message := s.validateScope(scope)
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(message))
+			return
+		}
+
+		// Open database
+		session, err := s.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+		dbCollection := session.DB("ztpDashboard").C("scope")
+
+		// Check if the name has been used before
+		count, err := dbCollection.Find(bson.M{"name": scope.Name}).Count()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (read database)", F("error", err))
+			return
+		}
+		if count > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Scope name " + scope.Name + " already in use"))
+			return
+		}
+
+		// Insert new scope in Database
+		err = dbCollection.Insert(&scope)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (insert database)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditCreate, "scope", scope.Name, nil, scope)
+
+		// Regenerate config file and restart dhcp service
+		go dhcpController.GenerateConfigFiles()
+
+		// Send notification
+		Notify(Notification{Type: model.NotifyScopeCreate, Object: scope.Name, Detail: scope.Subnet})
+
+		// Return ok message
+		w.Write([]byte("ok"))
+		break
+	// If method is GET, return all objects
+	case http.MethodGet:
+		scopes, err := s.GetScopes()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (read database)", F("error", err))
+			return
+		}
+		if scopes == nil {
+			scopes = []model.Scope{}
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(scopes)
+		break
+	// If method is PUT, update the scope with the same name
+	case http.MethodPut:
+		dec := json.NewDecoder(r.Body)
+		scope := &model.Scope{}
+		err := dec.Decode(scope)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (decode json)", F("error", err))
+			return
+		}
+
+		message := s.validateScope(scope)
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(message))
+			return
+		}
+
+		// Open database
+		session, err := s.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+
+		var before model.Scope
+		err = session.DB("ztpDashboard").C("scope").Find(bson.M{"name": scope.Name}).One(&before)
+		if err == nil {
+			err = session.DB("ztpDashboard").C("scope").Update(bson.M{"name": scope.Name}, &scope)
+		}
+		if err == mgo.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Scope " + scope.Name + " not found"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (update database)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditUpdate, "scope", scope.Name, before, scope)
+
+		// Regenerate config file and restart dhcp service
+		go dhcpController.GenerateConfigFiles()
+
+		// Send notification
+		Notify(Notification{Type: model.NotifyScopeUpdate, Object: scope.Name, Detail: scope.Subnet})
+
+		// Return ok message
+		w.Write([]byte("ok"))
+		break
+	case http.MethodDelete:
+		scopeName := r.URL.Query().Get("name")
+		if scopeName == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Name parameter not found"))
+			return
+		}
+
+		// Open database
+		session, err := s.db.OpenSession()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (open database)", F("error", err))
+			return
+		}
+		defer session.Close()
+
+		// Scopes in use cannot be removed
+		count, err := session.DB("ztpDashboard").C("device").Find(bson.M{"scope": scopeName}).Count()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (read database)", F("error", err))
+			return
+		}
+		if count > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Scope " + scopeName + " is assigned to devices"))
+			return
+		}
+
+		var before model.Scope
+		err = session.DB("ztpDashboard").C("scope").Find(bson.M{"name": scopeName}).One(&before)
+		if err == mgo.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Scope " + scopeName + " not found"))
+			return
+		}
+		if err == nil {
+			err = session.DB("ztpDashboard").C("scope").Remove(bson.M{"name": scopeName})
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPIScopes (delete database)", F("error", err))
+			return
+		}
+		auditCtl.Record(r, model.AuditDelete, "scope", scopeName, before, nil)
+
+		// Regenerate config file and restart dhcp service
+		go dhcpController.GenerateConfigFiles()
+
+		// Send notification
+		Notify(Notification{Type: model.NotifyScopeDelete, Object: scopeName})
+
+		w.Write([]byte("ok"))
+		break
+	}
+}
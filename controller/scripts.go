@@ -10,7 +10,6 @@ import (
 	"strings"
 
 	"github.com/CiscoSE/ztp-dashboard/model"
-	"github.com/globalsign/mgo/bson"
 	"github.com/gorilla/mux"
 )
 
@@ -25,10 +24,14 @@ type ScriptController struct {
 type xrZtpConfig struct {
 	ServerURL string
 	ConfigURL string
+	// CACert and PinnedPubKey make curl trust the self signed certificate of the server, and only it
+	CACert       template.HTML
+	PinnedPubKey template.HTML
 }
 
 type nxPoapConfig struct {
 	ServerIP   string
+	ServerURL  string
 	ConfigName string
 	ImageName  string
 }
@@ -47,119 +50,83 @@ func (s ScriptController) handleScriptFiles(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
-		CustomLog("handleImageFiles (reading image file): "+err.Error(), ErrorSeverity)
+		requestLog(r).Error("handleImageFiles (reading image file)", F("error", err))
 		return
 	}
 
-	var device model.Device
-	// Open database
-	session, err := s.db.OpenSession()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		go CustomLog("handleScriptFiles (open database): "+err.Error(), ErrorSeverity)
-		return
-	}
-	defer session.Close()
-	dbCollection := session.DB("ztpDashboard").C("device")
+	// Update the device status
+	updateDownloadStatus(remoteIP, "scripts", requestVars["scriptName"])
 
-	// If device not found log the error and continue. Otherwhise update database
-	err = dbCollection.Find(bson.M{"fixedip": remoteIP}).One(&device)
-	if err != nil {
-		go CustomLog("handleScriptFiles (Find request device): "+remoteIP+" "+err.Error(), DebugSeverity)
-	} else {
-		// Only do update if device status is different from desired
-		if device.Status != "Running init script" {
-			go CustomLog("handleScriptFiles: Updating device "+device.Hostname+" (serial "+device.Serial+") status to 'Running init script'", DebugSeverity)
-			device.Status = "Running init script"
-			dbCollection.Update(bson.M{"fixedip": remoteIP}, &device)
-			// Notify status change
-			go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") is executing script " + requestVars["scriptName"])
-		}
-	}
-	w.Write(content)
+	writeDownload(w, "scripts", content)
 }
 
-// GenerateNXPoapScript creates the day0 script for nexus devices
-func (s ScriptController) GenerateNXPoapScript(device model.Device, isIPv6 bool) {
-	var err error
-	var serverIP string
-
-	if isIPv6 {
-		serverIP, err = s.interfacesCtl.GetFirstIPv6()
-	} else {
-		serverIP, err = s.interfacesCtl.GetFirstIPv4()
-	}
-	if err != nil {
-		go CustomLog("GenerateNXPoapScript (get ServerIP): "+err.Error(), ErrorSeverity)
-		return
-	}
+// GenerateNXPoapScript creates the day0 script for nexus devices. serverIP is the address the
+// device uses to reach this server
+func (s ScriptController) GenerateNXPoapScript(device model.Device, serverIP string) {
 	if serverIP == "" {
-		go CustomLog("GenerateNXPoapScript (No local server IP. Cannot build POAP script)", ErrorSeverity)
+		Log.Error("GenerateNXPoapScript (No local server IP. Cannot build POAP script)")
 		return
 	}
 	poapConfig := &nxPoapConfig{
 		ServerIP:   serverIP,
+		ServerURL:  deviceServerURL(device, serverIP),
 		ImageName:  device.Image.Name,
 		ConfigName: device.Config.Name + ".conf",
 	}
 	t, err := template.ParseFiles(s.nxPythonTemplate)
 
 	if err != nil {
-		go CustomLog("GenerateNXPoapScript (Parse nxPythonTemplate): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateNXPoapScript (Parse nxPythonTemplate)", F("error", err))
 	}
 	buf1 := new(bytes.Buffer)
 	err = t.Execute(buf1, poapConfig)
 	if err != nil {
-		go CustomLog("GenerateNXPoapScript (Execute nxPythonTemplate): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateNXPoapScript (Execute nxPythonTemplate)", F("error", err))
 	}
 	result := buf1.String()
-	err = ioutil.WriteFile(basePath+"/public/scripts/"+device.Serial+".py", []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
+	err = ioutil.WriteFile(basePath+"/public/scripts/"+device.ScriptName()+".py", []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
 	if err != nil {
-		go CustomLog("GenerateNXPoapScript (Write"+device.Serial+".py into disk): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateNXPoapScript (Write script into disk)", append(deviceFields(device.Hostname, device.Serial), F("file", device.ScriptName()+".py"), F("error", err))...)
 	}
 }
 
-// GenerateXRZtpScript creates the shell script to be used by XR devices
-func (s ScriptController) GenerateXRZtpScript(device model.Device, isIPv6 bool) {
-	var err error
-	var serverIP string
-
-	if isIPv6 {
-		serverIP, err = s.interfacesCtl.GetFirstIPv6()
-	} else {
-		serverIP, err = s.interfacesCtl.GetFirstIPv4()
-	}
-
-	if err != nil {
-		go CustomLog("GenerateXRZtpScript (get ServerIP): "+err.Error(), ErrorSeverity)
-		return
-	}
+// GenerateXRZtpScript creates the shell script to be used by XR devices. serverIP is the address the
+// device uses to reach this server
+func (s ScriptController) GenerateXRZtpScript(device model.Device, serverIP string) {
 	if serverIP == "" {
-		go CustomLog("GenerateXRZtpScript (No local server IP. Cannot build POAP script)", ErrorSeverity)
+		Log.Error("GenerateXRZtpScript (No local server IP. Cannot build POAP script)")
 		return
 	}
 	shellConfig := &xrZtpConfig{
-		ServerURL: "http://" + serverIP + ":" + os.Getenv("APP_WEB_PORT"),
+		ServerURL: deviceServerURL(device, serverIP),
 		ConfigURL: device.Config.Locationurl,
 	}
+	if deviceSupportsTLS(device) && tlsSelfSigned() {
+		cert, pin, err := selfSignedPin()
+		if err != nil {
+			Log.Error("GenerateXRZtpScript (read self signed certificate)", F("error", err))
+			return
+		}
+		shellConfig.CACert = template.HTML(cert)
+		shellConfig.PinnedPubKey = template.HTML(pin)
+	}
 
 	t, err := template.ParseFiles(s.xrShellTemplate)
 
 	if err != nil {
-		go CustomLog("GenerateXRZtpScript (Parse xrShell Template): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateXRZtpScript (Parse xrShell Template)", F("error", err))
 		return
 	}
 	buf1 := new(bytes.Buffer)
 	err = t.Execute(buf1, shellConfig)
 	if err != nil {
-		go CustomLog("GenerateXRZtpScript (Execute xrShell Template): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateXRZtpScript (Execute xrShell Template)", F("error", err))
 		return
 	}
 	result := buf1.String()
-	err = ioutil.WriteFile(basePath+"/public/scripts/"+device.Serial+".sh", []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
+	err = ioutil.WriteFile(basePath+"/public/scripts/"+device.ScriptName()+".sh", []byte(strings.Replace(result, "&#34;", "\"", -1)), 0644)
 	if err != nil {
-		go CustomLog("GenerateXRZtpScript (write "+device.Serial+".sh into disk): "+err.Error(), ErrorSeverity)
+		Log.Error("GenerateXRZtpScript (write script into disk)", append(deviceFields(device.Hostname, device.Serial), F("file", device.ScriptName()+".sh"), F("error", err))...)
 	}
 }
 
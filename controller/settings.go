@@ -20,6 +20,7 @@ type settingsController struct {
 func (n settingsController) registerRoutes(r *mux.Router) {
 	r.HandleFunc("/ng/settings", n.handleSettings)
 	r.HandleFunc("/api/settings", n.handleAPISettings)
+	r.HandleFunc("/api/settings/notifications", n.handleAPINotificationTypes)
 
 }
 
@@ -39,7 +40,7 @@ func (n settingsController) handleAPISettings(w http.ResponseWriter, r *http.Req
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPISettings (decode jason): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPISettings (decode jason)", F("error", err))
 			return
 		}
 
@@ -48,19 +49,51 @@ func (n settingsController) handleAPISettings(w http.ResponseWriter, r *http.Req
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPISettings (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPISettings (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
 
 		dbCollection := session.DB("ztpDashboard").C("settings")
 
+		// Previous settings for the audit trail
+		var before *model.Settings
+		count, err := dbCollection.Find(nil).Count()
+		if err == nil && count > 0 {
+			before = &model.Settings{}
+			err = dbCollection.Find(nil).One(before)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			requestLog(r).Error("handleAPISettings (read database)", F("error", err))
+			return
+		}
+		// Secrets are returned redacted, saving them unchanged keeps the stored ones. The settings
+		// are checked with the restored values
+		restoreNotificationSecrets(settings, before)
+		message := validateNotificationChannels(settings.NotificationChannels)
+		if message == "" {
+			message = validateNotificationTemplates(settings.NotificationTemplates)
+		}
+		if message == "" {
+			message = validateAlertSinks(settings.AlertSinks)
+		}
+		if message == "" {
+			message = validateProvisioningTimeouts(settings.ProvisioningTimeouts)
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(message))
+			return
+		}
+
 		// Delete previous settings
 		_, err = dbCollection.RemoveAll(bson.M{})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPISettings (remove previous settings): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPISettings (remove previous settings)", F("error", err))
 			return
 		}
 
@@ -69,12 +102,22 @@ func (n settingsController) handleAPISettings(w http.ResponseWriter, r *http.Req
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPISettings (insert database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPISettings (insert database)", F("error", err))
 			return
 		}
+		action := model.AuditUpdate
+		if before == nil {
+			action = model.AuditCreate
+		}
+		var redactedBefore *model.Settings
+		if before != nil {
+			redacted := redactNotificationSecrets(*before)
+			redactedBefore = &redacted
+		}
+		auditCtl.Record(r, action, "settings", "settings", redactedBefore, redactNotificationSecrets(*settings))
 
 		// Send notification
-		go WebexTeamsCtl.SendMessage("#Settings changed \\n Situation manager URL: " + settings.SituationMgrURL + " \\n\\n New webex team room: " + settings.WebexTeamsRoomID)
+		Notify(Notification{Type: model.NotifySettingsUpdate})
 
 		// Return ok message
 		w.Write([]byte("ok"))
@@ -87,7 +130,7 @@ func (n settingsController) handleAPISettings(w http.ResponseWriter, r *http.Req
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
-			go CustomLog("handleAPISettings (open database): "+err.Error(), ErrorSeverity)
+			requestLog(r).Error("handleAPISettings (open database)", F("error", err))
 			return
 		}
 		defer session.Close()
@@ -102,15 +145,32 @@ func (n settingsController) handleAPISettings(w http.ResponseWriter, r *http.Req
 		} else {
 			err = dbCollection.Find(nil).One(&settings)
 			if err != nil {
-				go CustomLog("handleAPISettings (read database): "+err.Error(), ErrorSeverity)
+				requestLog(r).Error("handleAPISettings (read database)", F("error", err))
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte(err.Error()))
 				return
 			}
 		}
 		enc := json.NewEncoder(w)
-		enc.Encode(settings)
+		enc.Encode(redactNotificationSecrets(settings))
 
 		break
 	}
 }
+
+// handleAPINotificationTypes returns the notification types with their default and current templates
+func (n settingsController) handleAPINotificationTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	settings, err := notificationSettings()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		requestLog(r).Error("handleAPINotificationTypes (read database)", F("error", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationTypeList(settings.NotificationTemplates))
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+// settingsRequest sends a request to /api/settings with the token and returns the body
+func settingsRequest(t *testing.T, url string, method string, token string, body []byte) []byte {
+	req, err := http.NewRequest(method, url+"/api/settings", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s /api/settings returned %d: %s", method, resp.StatusCode, content)
+	}
+	return content
+}
+
+func TestSettingsRedactsChannelURLs(t *testing.T) {
+	resetTestStore(t)
+	slackURL := "https://hooks.slack.com/services/T000/B000/slack-token"
+	webhookURL := "https://example.com/hook?token=webhook-token"
+	saveTestSettings(t, model.Settings{NotificationChannels: []model.NotificationChannel{
+		{Name: "slack", Type: model.NotificationSlack, Enabled: true, URL: slackURL},
+		{Name: "webhook", Type: model.NotificationWebhook, Enabled: true, URL: webhookURL, Secret: "s3cret"},
+	}})
+	server := newTestServer(t, nil)
+
+	// A read-only token does not see the URLs, which give access to the channels
+	settings := settingsRequest(t, server.URL, http.MethodGet, addTestToken(t, model.ReadOnlyRole), nil)
+	for _, secret := range []string{"slack-token", "webhook-token", "s3cret"} {
+		if strings.Contains(string(settings), secret) {
+			t.Errorf("settings read with a read-only token contain %s: %s", secret, settings)
+		}
+	}
+
+	// Saving the redacted settings unchanged keeps the stored URLs
+	settingsRequest(t, server.URL, http.MethodPost, addTestToken(t, model.AdminRole), settings)
+	var stored model.Settings
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	err = session.DB("ztpDashboard").C("settings").Find(nil).One(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.NotificationChannels) != 2 || stored.NotificationChannels[0].URL != slackURL || stored.NotificationChannels[1].URL != webhookURL || stored.NotificationChannels[1].Secret != "s3cret" {
+		t.Errorf("stored channels are %+v", stored.NotificationChannels)
+	}
+}
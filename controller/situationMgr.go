@@ -3,126 +3,283 @@ package controller
 import (
 	"bytes"
 	"crypto/tls"
-	"html/template"
-	"log"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/CiscoSE/ztp-dashboard/model"
 	"github.com/globalsign/mgo/bson"
 )
 
+// situationMgrLogBuffer is the number of errors waiting to be sent to Situation Manager
+const situationMgrLogBuffer = 100
+
+// situationMgrDedupWindow is the default time an event is not sent again with the same severity
+const situationMgrDedupWindow = 15 * time.Minute
+
+// Situation Manager severities
+const (
+	situationClear         = 0
+	situationIndeterminate = 1
+	situationWarning       = 2
+	situationMinor         = 3
+	situationMajor         = 4
+	situationCritical      = 5
+)
+
+// situationSeverities maps the log levels to the Situation Manager severities
+var situationSeverities = map[Level]int{
+	LevelDebug: situationIndeterminate,
+	LevelInfo:  situationWarning,
+	LevelWarn:  situationMinor,
+	LevelError: situationCritical,
+}
+
+// Kinds of the device events. A device has at most one open event of each kind
+const (
+	situationProvisioning = "provisioning"
+	situationReachability = "reachability"
+	// situationLog is the kind of the errors logged by the application, which are never cleared
+	situationLog = "log"
+)
+
 // SituationMgrController encapsulates all request to Cisco situation manager
 type SituationMgrController struct {
 	db           dbController
 	InterfaceCtl interfaceController
 }
 
-// eventTemplateParams encapsulates all variables for the event template
-type eventTemplateParams struct {
-	Description string
-	Source      string
+// situationEvent is the payload of an event
+type situationEvent struct {
+	Signature   string `json:"signature"`
+	SourceID    string `json:"source_id"`
+	ExternalID  string `json:"external_id"`
+	Manager     string `json:"manager"`
+	Source      string `json:"source"`
+	Class       string `json:"class"`
+	Agent       string `json:"agent"`
+	Type        string `json:"type"`
+	Severity    int    `json:"severity"`
+	Description string `json:"description"`
+	AgentTime   int64  `json:"agent_time"`
 }
 
-// SendEvent uses register a new event into situation manager with the given description
-// All logs are printed directly since the debug handler uses this method
-func (s SituationMgrController) SendEvent(pDescription string) {
-	// Create request
+// situationSent is the last event sent with a signature, stored in the situationEvent collection.
+// It deduplicates the events and tells which device events have to be cleared
+type situationSent struct {
+	Signature   string `bson:"_id"`
+	Kind        string
+	Hostname    string
+	Serial      string
+	Severity    int
+	Description string
+	LastSent    time.Time
+}
 
-	ip, err := s.InterfaceCtl.GetFirstIPv4()
-	if err != nil {
-		log.Print(ErrorSeverity + " (SendEvent): Cannot retrieve IPv4 address. Trying with IPv6: " + err.Error())
-	}
-	if ip == "" {
+// situationMgrClient verifies the certificate of Situation Manager with the system CAs and the
+// bundle of SITUATION_MGR_CA_BUNDLE. It is created on first use
+var situationMgrClient struct {
+	sync.Once
+	client *http.Client
+	err    error
+}
 
-		log.Print(ErrorSeverity + " (SendEvent): Empty IPv4 address returned. Trying with IPv6 " + err.Error())
-		ip, err = s.InterfaceCtl.GetFirstIPv6()
+// situationHTTPClient returns the client used to send the events
+func situationHTTPClient() (*http.Client, error) {
+	situationMgrClient.Do(func() {
+		bundle := os.Getenv("SITUATION_MGR_CA_BUNDLE")
+		if bundle == "" {
+			situationMgrClient.client = &http.Client{Timeout: notificationTimeout}
+			return
+		}
+		pem, err := ioutil.ReadFile(bundle)
 		if err != nil {
-			log.Print(ErrorSeverity + " (SendEvent): Cannot retrieve IPv6 address. No IP addresses found. " + err.Error())
+			situationMgrClient.err = err
 			return
 		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			situationMgrClient.err = errors.New("no certificate found in " + bundle)
+			return
+		}
+		situationMgrClient.client = &http.Client{
+			Timeout:   notificationTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	})
+	return situationMgrClient.client, situationMgrClient.err
+}
+
+// situationDedupWindow reads SITUATION_MGR_DEDUP_WINDOW, a duration such as 30m
+func situationDedupWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("SITUATION_MGR_DEDUP_WINDOW"))
+	if err != nil || window < 0 {
+		return situationMgrDedupWindow
 	}
+	return window
+}
 
-	// 'buf' is an io.Writter to capture the template execution output
-	payload := new(bytes.Buffer)
-	templateParams := &eventTemplateParams{
-		Description: pDescription,
-		Source:      ip,
+// deviceSignature identifies the events of a kind for a device
+func deviceSignature(device model.Device, kind string) string {
+	return "ztp-dashboard::" + kind + "::" + device.ScriptName()
+}
+
+// SendLogEvent registers an error logged by the application. It is called by the log hook of the
+// errors, so it logs with hookLog to avoid loops
+func (s SituationMgrController) SendLogEvent(entry LogEntry) {
+	s.sendEvent(situationSent{
+		Signature:   "ztp-dashboard::" + situationLog + "::" + entry.Message,
+		Kind:        situationLog,
+		Severity:    situationSeverities[entry.Level],
+		Description: entry.Message,
+	})
+}
+
+// RaiseDeviceEvent opens or updates the event of a kind for a device, e.g. a failed provisioning.
+// The level is mapped to the Situation Manager severity
+func (s SituationMgrController) RaiseDeviceEvent(device model.Device, kind string, level Level, description string) {
+	s.sendEvent(situationSent{
+		Signature:   deviceSignature(device, kind),
+		Kind:        kind,
+		Hostname:    device.Hostname,
+		Serial:      device.Serial,
+		Severity:    situationSeverities[level],
+		Description: description,
+	})
+}
+
+// ClearDeviceEvents sends a clear event for each kind with an open event for the device
+func (s SituationMgrController) ClearDeviceEvents(device model.Device, description string, kinds ...string) {
+	for _, kind := range kinds {
+		s.sendEvent(situationSent{
+			Signature:   deviceSignature(device, kind),
+			Kind:        kind,
+			Hostname:    device.Hostname,
+			Serial:      device.Serial,
+			Severity:    situationClear,
+			Description: description,
+		})
 	}
-	// Read the json template file
-	t, err := template.ParseFiles(basePath + "/jsonTemplates/addSituationMgrEvent.json")
+}
+
+// sendEvent posts an event unless the same signature was sent with the same severity within the
+// dedup window. Clear events are only sent for the signatures with an open event
+func (s SituationMgrController) sendEvent(event situationSent) {
+	logger := hookLog("situationManager")
+	settings, err := notificationSettings()
 	if err != nil {
-		log.Print(ErrorSeverity + " (SendEvent): Cannot read event template. " + err.Error())
+		logger.Error("sendEvent: Cannot read settings", F("error", err))
 		return
 	}
-
-	err = t.Execute(payload, templateParams)
-	if err != nil {
-		log.Print(ErrorSeverity + " (SendEvent): Cannot create event payload. " + err.Error())
+	// Nothing is sent when situation manager is not configured
+	if settings.SituationMgrURL == "" {
 		return
 	}
-	s.makeCall("POST", "", payload.Bytes())
-}
-
-// Single point to make calls to webex teams
-func (s SituationMgrController) makeCall(method string, url string, payload []byte) (*http.Response, error) {
-	// Get Situation Manager URL from database
 
-	// Open database
 	session, err := s.db.OpenSession()
 	if err != nil {
-		log.Print(ErrorSeverity + " (makeCall): Cannot open database. " + err.Error())
-		return nil, err
+		logger.Error("sendEvent: Cannot open database", F("error", err))
+		return
 	}
 	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("situationEvent")
 
-	dbCollection := session.DB("ztpDashboard").C("settings")
-
-	settingsCollection := dbCollection.Find(bson.M{})
-	count, err := settingsCollection.Count()
+	now := time.Now().UTC()
+	var previous []situationSent
+	err = dbCollection.FindId(event.Signature).All(&previous)
 	if err != nil {
-		log.Print(ErrorSeverity + " (makeCall): Cannot read database. " + err.Error())
-		return nil, err
+		logger.Error("sendEvent: Cannot read database", F("error", err))
+		return
 	}
-	if count == 0 {
-		CustomLog("(makeCall): No settings in database, have you configure the settings?", DebugSeverity)
-		return nil, nil
+	if event.Severity == situationClear && len(previous) == 0 {
+		return
+	}
+	if event.Severity != situationClear && len(previous) > 0 && previous[0].Severity == event.Severity && now.Sub(previous[0].LastSent) < situationDedupWindow() {
+		logger.Debug("sendEvent: Duplicated event not sent", F("signature", event.Signature))
+		return
 	}
 
-	var settings model.Settings
-	err = settingsCollection.One(&settings)
+	err = s.post(settings.SituationMgrURL, event, now)
+	countNotification("situationManager", err)
 	if err != nil {
-		log.Print(ErrorSeverity + " (makeCall): Cannot parse settings from database:" + err.Error())
-		return nil, err
+		logger.Error("sendEvent: Cannot send event", F("signature", event.Signature), F("error", err))
+		return
 	}
 
-	// Check if settings have been correctly configured
-	if settings.SituationMgrURL == "" {
-		CustomLog("makeCall (SituationMgrURL): Cannot send event, no SitMgr URL configured", DebugSeverity)
-		return nil, nil
+	if event.Severity == situationClear {
+		err = dbCollection.RemoveId(event.Signature)
+	} else {
+		event.LastSent = now
+		_, err = dbCollection.UpsertId(event.Signature, &event)
 	}
-	// Send the request
-	callURL := settings.SituationMgrURL
-
-	CustomLog("Making call to situation manager -> "+method+": "+callURL, DebugSeverity)
-	CustomLog("Payload -> "+string(payload[:]), DebugSeverity)
-
-	// Create request
-	req, _ := http.NewRequest(method, callURL, bytes.NewBuffer(payload))
-
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
+	if err != nil {
+		logger.Error("sendEvent: Cannot update database", F("error", err))
+	}
+	// Application errors are never cleared, their records are only kept for the dedup window
+	_, err = dbCollection.RemoveAll(bson.M{"kind": situationLog, "lastsent": bson.M{"$lt": now.Add(-situationDedupWindow())}})
+	if err != nil {
+		logger.Error("sendEvent: Cannot remove old events", F("error", err))
+	}
+}
 
-	// Create transport that allows https connections with self signed  certificates
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// post sends an event to the URL of the settings. The source is the first address of the server
+func (s SituationMgrController) post(url string, event situationSent, now time.Time) error {
+	logger := hookLog("situationManager")
+	ip, err := s.InterfaceCtl.GetFirstIPv4()
+	if err != nil {
+		logger.Error("post: Cannot retrieve IPv4 address. Trying with IPv6", F("error", err))
+	}
+	if ip == "" {
+		logger.Debug("post: Empty IPv4 address returned. Trying with IPv6")
+		ip, err = s.InterfaceCtl.GetFirstIPv6()
+		if err != nil {
+			return err
+		}
 	}
 
-	// Set up client
-	client := &http.Client{Transport: tr}
+	payload, err := json.Marshal(situationEvent{
+		Signature:   event.Signature,
+		SourceID:    "ztp-dashboard.cisco.com",
+		ExternalID:  "ztp-dashboard.cisco.com",
+		Manager:     "ZTP Dashboard",
+		Source:      ip,
+		Class:       "Zero Touch Provisioning",
+		Agent:       "ZTP Dashboard",
+		Type:        "Application",
+		Severity:    event.Severity,
+		Description: event.Description,
+		AgentTime:   now.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	client, err := situationHTTPClient()
+	if err != nil {
+		return err
+	}
 
-	// Do request
+	logger.Debug("Making call to situation manager", F("url", url))
+	logger.Debug("Situation manager payload", F("payload", string(payload)))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(req)
-
-	// Return the results
-	return resp, err
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("situation manager returned status " + resp.Status)
+	}
+	return nil
 }
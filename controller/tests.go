@@ -1,66 +1,444 @@
 package controller
 
 import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CiscoSE/ztp-dashboard/model"
 	"github.com/globalsign/mgo/bson"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/tatsushid/go-fastping"
 )
 
+// Triggers of the test runs
+const (
+	testTriggerProvisioned = "provisioned"
+	testTriggerAPI         = "api"
+	testTriggerBot         = "bot"
+)
+
+// defaultTestTimeout is used for the tests without timeout
+const defaultTestTimeout = 10 * time.Second
+
+// testMaxOutput limits the HTTP body and the command output read by the tests
+const testMaxOutput = 1024 * 1024
+
+// testsFailedStatus is the status of the devices that failed some of their tests. Devices that
+// failed every test are Unreachable
+const testsFailedStatus = "Tests failed"
+
+// defaultTestSuite is run on the devices without test suite
+var defaultTestSuite = model.TestSuite{Name: "default", Tests: []model.DeviceTest{{Name: "ping", Kind: model.TestICMP}}}
+
+// deviceTesters run the tests by kind. They return a short description of the success, or the
+// reason of the failure
+var deviceTesters = map[string]func(device model.Device, test model.DeviceTest, timeout time.Duration) (string, error){
+	model.TestICMP: testICMP,
+	model.TestTCP:  testTCP,
+	model.TestHTTP: testHTTP,
+	model.TestSSH:  testSSH,
+}
+
+// imageVersionPattern finds the version in the name of an image
+var imageVersionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// TestController runs the test suites on the provisioned devices and stores the results in the
+// testRun collection
 type TestController struct {
 	db dbController
 }
 
-// TestDevice executes an ping command. Needs root priveledge
-func (t TestController) TestDevice(device model.Device) {
-	deviceReplied := false
-	p := fastping.NewPinger()
-	ra, err := net.ResolveIPAddr("ip4:icmp", device.Fixedip)
+// TestDevice runs the test suites of the device and updates its status
+func (t TestController) TestDevice(device model.Device, trigger string) []model.TestRun {
+	runs, suites, err := t.startRuns(device, "", trigger)
+	if err != nil {
+		Log.Error("TestDevice (start runs)", append(deviceFields(device.Hostname, device.Serial), F("error", err))...)
+		return nil
+	}
+	return t.finishRuns(device, runs, suites)
+}
+
+// suitesOf returns the suites of the device type and of the device, or the default suite. A suite
+// name selects that suite only
+func (t TestController) suitesOf(session storeSession, device model.Device, suiteName string) ([]model.TestSuite, error) {
+	var suites []model.TestSuite
+	dbCollection := session.DB("ztpDashboard").C("testSuite")
+	if suiteName != "" {
+		if suiteName == defaultTestSuite.Name {
+			return []model.TestSuite{defaultTestSuite}, nil
+		}
+		err := dbCollection.Find(bson.M{"name": suiteName}).All(&suites)
+		return suites, err
+	}
+
+	selectors := []bson.M{{"devicetypes": device.DeviceType.Name}, {"devices": device.Hostname}}
+	if device.Serial != "" {
+		selectors = append(selectors, bson.M{"devices": device.Serial})
+	}
+	err := dbCollection.Find(bson.M{"$or": selectors}).Sort("name").All(&suites)
 	if err != nil {
-		go CustomLog("TestDevice (resolve address): "+err.Error(), ErrorSeverity)
+		return nil, err
+	}
+	if len(suites) == 0 {
+		suites = []model.TestSuite{defaultTestSuite}
 	}
+	return suites, nil
+}
 
+// startRuns stores the runs of the suites of the device before they are run, so they can be
+// followed through the API. A run is finished when its finish time is set
+func (t TestController) startRuns(device model.Device, suiteName string, trigger string) ([]model.TestRun, []model.TestSuite, error) {
 	session, err := t.db.OpenSession()
 	if err != nil {
-		go CustomLog("TestDevice (open database): "+err.Error(), ErrorSeverity)
-		return
+		return nil, nil, err
 	}
 	defer session.Close()
-	dbCollection := session.DB("ztpDashboard").C("device")
 
-	p.AddIPAddr(ra)
-	p.OnRecv = func(addr *net.IPAddr, rtt time.Duration) {
-		go CustomLog("Response from "+device.Fixedip+" received", DebugSeverity)
-		// Only do update if device status is different from desired
-		if device.Status != "Reachable" {
-			go CustomLog("TestDevice: Updating device "+device.Serial+" status to 'Reachable'", DebugSeverity)
-			device.Status = "Reachable"
-			dbCollection.Update(bson.M{"fixedip": device.Fixedip}, &device)
-
-			// Send notification
-			go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") is reachable. Test succeded")
-		}
-		deviceReplied = true
-	}
-	p.OnIdle = func() {
-		if !deviceReplied {
-			go CustomLog("TestDevice (Idle): Cannot get a response from "+device.Fixedip, ErrorSeverity)
-			// Only do update if device status is different from desired
-			if device.Status != "Unreachable" {
-				go CustomLog("TestDevice: Updating device "+device.Serial+" status to 'Unreachable'", DebugSeverity)
-				device.Status = "Unreachable"
-				dbCollection.Update(bson.M{"fixedip": device.Fixedip}, &device)
-
-				// Send notification
-				go WebexTeamsCtl.SendMessage("Device " + device.Hostname + " (serial " + device.Serial + ") unreachable. Test failed")
+	suites, err := t.suitesOf(session, device, suiteName)
+	if err != nil {
+		return nil, nil, err
+	}
+	runs := make([]model.TestRun, len(suites))
+	for i, suite := range suites {
+		runs[i] = model.TestRun{
+			ID:       bson.NewObjectId().Hex(),
+			Hostname: device.Hostname,
+			Serial:   device.Serial,
+			Suite:    suite.Name,
+			Trigger:  trigger,
+			Started:  time.Now().UTC(),
+			Results:  []model.TestResult{},
+		}
+		err = session.DB("ztpDashboard").C("testRun").Insert(&runs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return runs, suites, nil
+}
+
+// finishRuns runs the tests of the started runs, stores their results and updates the status of
+// the device
+func (t TestController) finishRuns(device model.Device, runs []model.TestRun, suites []model.TestSuite) []model.TestRun {
+	session, err := t.db.OpenSession()
+	if err != nil {
+		Log.Error("finishRuns (open database)", F("error", err))
+		return nil
+	}
+	defer session.Close()
+	dbCollection := session.DB("ztpDashboard").C("testRun")
+
+	for i, suite := range suites {
+		runs[i].Passed = true
+		for _, test := range suite.Tests {
+			result := runTest(device, test)
+			runs[i].Passed = runs[i].Passed && result.Passed
+			runs[i].Results = append(runs[i].Results, result)
+		}
+		runs[i].Finished = time.Now().UTC()
+		err = dbCollection.UpdateId(runs[i].ID, &runs[i])
+		if err != nil {
+			Log.Error("finishRuns (update database)", append(deviceFields(device.Hostname, device.Serial), F("suite", suite.Name), F("error", err))...)
+		}
+		Log.Debug("finishRuns: Suite finished", append(deviceFields(device.Hostname, device.Serial), F("suite", suite.Name), F("passed", runs[i].Passed))...)
+	}
+	t.updateStatus(session, device, runs)
+	return runs
+}
+
+// updateStatus sets the status of the device from the results of the runs: Reachable when every
+// test passed, Unreachable when every test failed and Tests failed otherwise
+func (t TestController) updateStatus(session storeSession, device model.Device, runs []model.TestRun) {
+	var failed []string
+	total := 0
+	for _, run := range runs {
+		for _, result := range run.Results {
+			total++
+			if !result.Passed {
+				failed = append(failed, run.Suite+"/"+result.Name)
 			}
 		}
 	}
+	status := "Reachable"
+	detail := "Tests succeeded"
+	if len(failed) > 0 {
+		status = testsFailedStatus
+		if len(failed) == total {
+			status = "Unreachable"
+		}
+		detail = strconv.Itoa(len(failed)) + " of " + strconv.Itoa(total) + " tests failed: " + strings.Join(failed, ", ")
+	}
+
+	// Only do update if device status is different from desired
+	if device.Status == status {
+		return
+	}
+	Log.Debug("TestDevice: Updating device status", append(deviceFields(device.Hostname, device.Serial), F("status", status))...)
+	device.Status = status
+	err := session.DB("ztpDashboard").C("device").Update(bson.M{"hostname": device.Hostname}, bson.M{"$set": bson.M{"status": status}})
+	if err != nil {
+		Log.Error("TestDevice (update database)", append(deviceFields(device.Hostname, device.Serial), F("error", err))...)
+		return
+	}
+	recordDeviceStatus(device, detail)
+
+	// Send notification
+	if len(failed) == 0 {
+		go SituationMgrCtl.ClearDeviceEvents(device, "Device "+device.Hostname+" reachable", situationProvisioning, situationReachability)
+		Notify(Notification{Type: model.NotifyTestSucceeded, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status})
+		return
+	}
+	go SituationMgrCtl.RaiseDeviceEvent(device, situationReachability, LevelError, "Device "+device.Hostname+" failed its tests after provisioning: "+detail)
+	Notify(Notification{Type: model.NotifyTestFailed, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status, Detail: detail})
+}
+
+// runTest runs one test on the device
+func runTest(device model.Device, test model.DeviceTest) model.TestResult {
+	result := model.TestResult{Name: test.Name, Kind: test.Kind}
+	tester, present := deviceTesters[test.Kind]
+	if !present {
+		result.Detail = "unknown test kind " + test.Kind
+		return result
+	}
+	if device.Fixedip == "" {
+		result.Detail = "the device has no address"
+		return result
+	}
+	timeout := defaultTestTimeout
+	if test.Timeout > 0 {
+		timeout = time.Duration(test.Timeout) * time.Second
+	}
+
+	started := time.Now()
+	detail, err := tester(device, test, timeout)
+	result.Duration = int64(time.Since(started) / time.Millisecond)
+	result.Passed = err == nil
+	result.Detail = detail
+	if err != nil {
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// testPort returns the port of the test, or the default one
+func testPort(test model.DeviceTest, defaultPort int) string {
+	if test.Port > 0 {
+		return strconv.Itoa(test.Port)
+	}
+	return strconv.Itoa(defaultPort)
+}
+
+// testICMP pings the device. Raw sockets need root privileges, TEST_PING_UNPRIVILEGED=on uses the
+// unprivileged ICMP sockets of Linux instead
+func testICMP(device model.Device, test model.DeviceTest, timeout time.Duration) (string, error) {
+	ra, err := net.ResolveIPAddr("ip", device.Fixedip)
+	if err != nil {
+		return "", err
+	}
+	p := fastping.NewPinger()
+	if os.Getenv("TEST_PING_UNPRIVILEGED") == "on" {
+		p.Network("udp")
+	}
+	p.MaxRTT = timeout
+	p.AddIPAddr(ra)
+	var replied time.Duration
+	p.OnRecv = func(addr *net.IPAddr, rtt time.Duration) {
+		replied = rtt
+	}
 	err = p.Run()
 	if err != nil {
-		go CustomLog("MakePing (Run): Cannot run ping to "+device.Fixedip+": "+err.Error(), ErrorSeverity)
+		return "", err
+	}
+	if replied == 0 {
+		return "", errors.New("no reply to ping")
+	}
+	return "reply in " + replied.String(), nil
+}
+
+// testTCP opens a connection to the port of the test
+func testTCP(device model.Device, test model.DeviceTest, timeout time.Duration) (string, error) {
+	address := net.JoinHostPort(device.Fixedip, testPort(test, 0))
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return address + " open", nil
+}
+
+// testHTTP gets the path of the test and checks the status code and the body
+func testHTTP(device model.Device, test model.DeviceTest, timeout time.Duration) (string, error) {
+	scheme, port := "http", 80
+	if test.TLS {
+		scheme, port = "https", 443
+	}
+	urlPath := test.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	url := scheme + "://" + net.JoinHostPort(device.Fixedip, testPort(test, port)) + urlPath
+
+	// Freshly provisioned devices use self signed certificates. Each test has its own transport,
+	// so the connection is not kept open after the test
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DisableKeepAlives: true},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, testMaxOutput))
+	if err != nil {
+		return "", err
+	}
+	if (test.ExpectStatus == 0 && resp.StatusCode >= 400) || (test.ExpectStatus != 0 && resp.StatusCode != test.ExpectStatus) {
+		return "", errors.New(url + " returned status " + resp.Status)
+	}
+	err = checkTestOutput(device, test, string(body))
+	if err != nil {
+		return "", err
+	}
+	return url + " returned status " + resp.Status, nil
+}
+
+// testSSH logs in with TEST_SSH_USERNAME and TEST_SSH_PASSWORD or the private key of TEST_SSH_KEY,
+// and runs the command of the test. Host keys are not checked, they change when devices are
+// provisioned again
+func testSSH(device model.Device, test model.DeviceTest, timeout time.Duration) (string, error) {
+	config := &ssh.ClientConfig{
+		User:            os.Getenv("TEST_SSH_USERNAME"),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	}
+	if keyFile := os.Getenv("TEST_SSH_KEY"); keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if password := os.Getenv("TEST_SSH_PASSWORD"); password != "" {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+
+	address := net.JoinHostPort(device.Fixedip, testPort(test, 22))
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// The deadline also limits the time spent by the command
+	conn.SetDeadline(time.Now().Add(timeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		return "", err
+	}
+	client := ssh.NewClient(sshConn, channels, requests)
+	defer client.Close()
+	if test.Command == "" {
+		return "logged in as " + config.User, nil
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	output, err := session.CombinedOutput(test.Command)
+	if err != nil {
+		return "", errors.New(test.Command + ": " + err.Error())
+	}
+	if len(output) > testMaxOutput {
+		output = output[:testMaxOutput]
+	}
+	err = checkTestOutput(device, test, string(output))
+	if err != nil {
+		return "", err
+	}
+	return test.Command + " succeeded", nil
+}
+
+// checkTestOutput checks the HTTP body or the command output against the expectations of the test
+func checkTestOutput(device model.Device, test model.DeviceTest, output string) error {
+	if test.Expect != "" {
+		pattern, err := regexp.Compile(test.Expect)
+		if err != nil {
+			return err
+		}
+		if !pattern.MatchString(output) {
+			return errors.New("output does not match " + test.Expect)
+		}
+	}
+	if test.ExpectImage {
+		// An empty version is in every output
+		if device.Image.Name == "" {
+			return errors.New("device has no image")
+		}
+		version := imageVersion(device.Image.Name)
+		if !strings.Contains(output, version) {
+			return errors.New("output does not contain the image version " + version)
+		}
+	}
+	return nil
+}
+
+// imageVersion returns the version in the name of an image, or the name without extension
+func imageVersion(name string) string {
+	if version := imageVersionPattern.FindString(name); version != "" {
+		return version
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// validateTestSuite returns a message if the suite is not valid, or an empty string
+func validateTestSuite(suite model.TestSuite) string {
+	if !validFileName(suite.Name) {
+		return "Invalid suite name"
+	}
+	if suite.Name == defaultTestSuite.Name {
+		return "Suite name " + defaultTestSuite.Name + " is reserved"
+	}
+	if len(suite.Tests) == 0 {
+		return "Suite " + suite.Name + " has no tests"
+	}
+	for _, test := range suite.Tests {
+		if test.Name == "" {
+			return "Tests need a name"
+		}
+		if _, present := deviceTesters[test.Kind]; !present {
+			return "Test " + test.Name + " has an unknown kind " + test.Kind
+		}
+		if test.Port < 0 || test.Port > 65535 || test.Timeout < 0 {
+			return "Test " + test.Name + " has an invalid port or timeout"
+		}
+		if test.Kind == model.TestTCP && test.Port == 0 {
+			return "Test " + test.Name + " needs a port"
+		}
+		if test.Expect != "" {
+			if _, err := regexp.Compile(test.Expect); err != nil {
+				return "Test " + test.Name + " has an invalid expected output: " + err.Error()
+			}
+		}
+		if test.Kind == model.TestSSH && test.Command == "" && (test.Expect != "" || test.ExpectImage) {
+			return "Test " + test.Name + " needs a command to check its output"
+		}
 	}
+	return ""
 }
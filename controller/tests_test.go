@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+func TestCheckTestOutputImage(t *testing.T) {
+	test := model.DeviceTest{Name: "version", Kind: model.TestSSH, Command: "show version", ExpectImage: true}
+	output := "Cisco IOS XR Software, Version 7.3.2"
+
+	cases := []struct {
+		image string
+		err   string
+	}{
+		{"xrv9k-fullk9-x-7.3.2.iso", ""},
+		{"xrv9k-fullk9-x-7.5.1.iso", "output does not contain the image version 7.5.1"},
+		{"", "device has no image"},
+	}
+	for _, c := range cases {
+		err := checkTestOutput(model.Device{Image: model.Image{Name: c.image}}, test, output)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("image %q: got error %v, want %q", c.image, err, c.err)
+		}
+	}
+}
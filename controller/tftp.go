@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TFTP opcodes (RFC 1350) and option acknowledgment (RFC 2347)
+const (
+	tftpOpRRQ   = 1
+	tftpOpWRQ   = 2
+	tftpOpDATA  = 3
+	tftpOpACK   = 4
+	tftpOpERROR = 5
+	tftpOpOACK  = 6
+)
+
+// TFTP error codes
+const (
+	tftpErrNotDefined      = 0
+	tftpErrFileNotFound    = 1
+	tftpErrAccessViolation = 2
+	tftpErrIllegalOp       = 4
+	tftpErrUnknownTID      = 5
+	tftpErrOptionRefused   = 8
+)
+
+const (
+	tftpDefaultBlockSize = 512
+	tftpMinBlockSize     = 8
+	tftpMaxBlockSize     = 65464
+	tftpDefaultTimeout   = 5 * time.Second
+	tftpRetries          = 5
+)
+
+// TFTPServer is a read only TFTP server (RFC 1350 with the blksize and tsize options of RFC 2348
+// and RFC 2349) that serves the generated scripts, configs and images from the public directory.
+// NX-OS devices use it to download their POAP script
+type TFTPServer struct {
+	Root string
+	Port string
+}
+
+// tftpRequest is a read or write request with its options
+type tftpRequest struct {
+	opcode   uint16
+	filename string
+	mode     string
+	options  map[string]string
+	// Order of the options as sent by the client
+	optionNames []string
+}
+
+// ListenAndServe listens on the UDP port of the server and handles each read request in its
+// own transfer socket, as defined by RFC 1350
+func (t TFTPServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", ":"+t.Port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	Log.Info("TFTP server listening", F("port", t.Port))
+
+	buffer := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		request, err := parseTFTPRequest(buffer[:n])
+		if err != nil {
+			Log.Debug("TFTP (parse request)", F("remote", addr.String()), F("error", err))
+			conn.WriteTo(tftpErrorPacket(tftpErrIllegalOp, err.Error()), addr)
+			continue
+		}
+		go t.handleRequest(request, addr.(*net.UDPAddr))
+	}
+}
+
+// parseTFTPRequest decodes a RRQ or WRQ packet
+func parseTFTPRequest(packet []byte) (*tftpRequest, error) {
+	if len(packet) < 4 {
+		return nil, errors.New("Packet too short")
+	}
+	request := &tftpRequest{
+		opcode:  binary.BigEndian.Uint16(packet),
+		options: make(map[string]string),
+	}
+	if request.opcode != tftpOpRRQ && request.opcode != tftpOpWRQ {
+		return nil, errors.New("Unexpected opcode " + strconv.Itoa(int(request.opcode)))
+	}
+	fields := bytes.Split(packet[2:], []byte{0})
+	// Last field is empty since the packet ends with a zero byte
+	if len(fields) < 3 {
+		return nil, errors.New("Malformed request")
+	}
+	request.filename = string(fields[0])
+	request.mode = strings.ToLower(string(fields[1]))
+	for i := 2; i+1 < len(fields); i += 2 {
+		name := strings.ToLower(string(fields[i]))
+		if name == "" {
+			break
+		}
+		request.options[name] = string(fields[i+1])
+		request.optionNames = append(request.optionNames, name)
+	}
+	return request, nil
+}
+
+// tftpErrorPacket builds an ERROR packet
+func tftpErrorPacket(code uint16, message string) []byte {
+	packet := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(packet, tftpOpERROR)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, message...)
+	return append(packet, 0)
+}
+
+// resolvePath maps the requested file name to a file under the public directory. Devices request
+// paths like "public/scripts/<serial>.py", "/tftpboot/public/scripts/<serial>.py" or
+// "scripts/<serial>.py". Only the scripts, configs and images directories are served.
+// It returns the directory and file name of the request
+func (t TFTPServer) resolvePath(filename string) (string, string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+strings.Replace(filename, "\\", "/", -1)), "/")
+	for _, prefix := range []string{"tftpboot/", "tftboot/", "public/"} {
+		clean = strings.TrimPrefix(clean, prefix)
+	}
+	parts := strings.SplitN(clean, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || strings.Contains(parts[1], "/") {
+		return "", "", errors.New("Access violation")
+	}
+	if _, present := downloadStatuses[parts[0]]; !present {
+		return "", "", errors.New("Access violation")
+	}
+	return parts[0], parts[1], nil
+}
+
+// handleRequest serves a single read request. Write requests are refused since the server is read only
+func (t TFTPServer) handleRequest(request *tftpRequest, addr *net.UDPAddr) {
+	// Each transfer uses its own socket (transfer identifier)
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		Log.Error("TFTP (open transfer socket)", F("error", err))
+		return
+	}
+	defer conn.Close()
+
+	if request.opcode == tftpOpWRQ {
+		conn.WriteToUDP(tftpErrorPacket(tftpErrAccessViolation, "Server is read only"), addr)
+		return
+	}
+
+	directory, fileName, err := t.resolvePath(request.filename)
+	if err != nil {
+		Log.Debug("TFTP (resolve file)", F("file", request.filename), F("ip", addr.IP.String()), F("error", err))
+		conn.WriteToUDP(tftpErrorPacket(tftpErrAccessViolation, err.Error()), addr)
+		return
+	}
+	file, err := os.Open(t.Root + "/" + directory + "/" + fileName)
+	if err != nil {
+		Log.Debug("TFTP (open file)", F("file", request.filename), F("ip", addr.IP.String()), F("error", err))
+		conn.WriteToUDP(tftpErrorPacket(tftpErrFileNotFound, "File not found"), addr)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		conn.WriteToUDP(tftpErrorPacket(tftpErrNotDefined, err.Error()), addr)
+		return
+	}
+
+	// Negotiate options (RFC 2347). Unknown options are ignored
+	blockSize := tftpDefaultBlockSize
+	timeout := tftpDefaultTimeout
+	var acknowledged []string
+	for _, name := range request.optionNames {
+		value := request.options[name]
+		switch name {
+		case "blksize":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < tftpMinBlockSize {
+				conn.WriteToUDP(tftpErrorPacket(tftpErrOptionRefused, "Invalid block size"), addr)
+				return
+			}
+			if size > tftpMaxBlockSize {
+				size = tftpMaxBlockSize
+			}
+			blockSize = size
+			acknowledged = append(acknowledged, name, strconv.Itoa(size))
+		case "tsize":
+			acknowledged = append(acknowledged, name, strconv.FormatInt(info.Size(), 10))
+		case "timeout":
+			seconds, err := strconv.Atoi(value)
+			if err == nil && seconds >= 1 && seconds <= 255 {
+				timeout = time.Duration(seconds) * time.Second
+				acknowledged = append(acknowledged, name, value)
+			}
+		}
+	}
+
+	// Update the device status like the HTTP file handlers do
+	go updateDownloadStatus(addr.IP.String(), directory, fileName)
+
+	if len(acknowledged) > 0 {
+		oack := []byte{0, tftpOpOACK}
+		for _, field := range acknowledged {
+			oack = append(oack, field...)
+			oack = append(oack, 0)
+		}
+		// The client acknowledges the OACK with block 0
+		err = t.sendAndWait(conn, addr, oack, 0, timeout)
+		if err != nil {
+			Log.Debug("TFTP (option acknowledgment)", F("ip", addr.IP.String()), F("error", err))
+			return
+		}
+	}
+
+	fileDownloadsInFlight.WithLabelValues(directory, "tftp").Inc()
+	counter := &countingReader{reader: file}
+	err = t.sendFile(conn, addr, counter, blockSize, timeout)
+	fileDownloadsInFlight.WithLabelValues(directory, "tftp").Dec()
+	fileDownloadBytes.WithLabelValues(directory, "tftp").Add(float64(counter.count))
+	if err != nil {
+		Log.Error("TFTP (send file)", F("file", request.filename), F("ip", addr.IP.String()), F("error", err))
+		return
+	}
+	fileDownloads.WithLabelValues(directory, "tftp").Inc()
+	Log.Debug("TFTP: file sent", F("file", request.filename), F("ip", addr.IP.String()))
+}
+
+// sendFile sends the file in DATA packets, waiting for the acknowledgment of each block.
+// The transfer ends with a block smaller than the block size
+func (t TFTPServer) sendFile(conn *net.UDPConn, addr *net.UDPAddr, file io.Reader, blockSize int, timeout time.Duration) error {
+	buffer := make([]byte, blockSize)
+	// Block numbers roll over to 0 for files bigger than 65535 blocks
+	block := uint16(1)
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			conn.WriteToUDP(tftpErrorPacket(tftpErrNotDefined, err.Error()), addr)
+			return err
+		}
+		packet := make([]byte, 4+n)
+		binary.BigEndian.PutUint16(packet, tftpOpDATA)
+		binary.BigEndian.PutUint16(packet[2:], block)
+		copy(packet[4:], buffer[:n])
+		err = t.sendAndWait(conn, addr, packet, block, timeout)
+		if err != nil {
+			return err
+		}
+		if n < blockSize {
+			return nil
+		}
+		block++
+	}
+}
+
+// sendAndWait sends a packet and waits for the ACK of the given block, retransmitting on timeout
+func (t TFTPServer) sendAndWait(conn *net.UDPConn, addr *net.UDPAddr, packet []byte, block uint16, timeout time.Duration) error {
+	response := make([]byte, 1500)
+	for retry := 0; retry < tftpRetries; retry++ {
+		_, err := conn.WriteToUDP(packet, addr)
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, from, err := conn.ReadFromUDP(response)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			// Packets from other transfer identifiers are rejected
+			if !from.IP.Equal(addr.IP) || from.Port != addr.Port {
+				conn.WriteToUDP(tftpErrorPacket(tftpErrUnknownTID, "Unknown transfer ID"), from)
+				continue
+			}
+			if n < 4 {
+				continue
+			}
+			switch binary.BigEndian.Uint16(response) {
+			case tftpOpACK:
+				if binary.BigEndian.Uint16(response[2:]) == block {
+					return nil
+				}
+				// Duplicated ACK of a previous block, keep waiting
+			case tftpOpERROR:
+				return errors.New("Client error: " + string(bytes.TrimRight(response[4:n], "\x00")))
+			}
+		}
+	}
+	return errors.New("Timeout waiting for acknowledgment of block " + strconv.Itoa(int(block)))
+}
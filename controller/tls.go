@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/asaskevich/govalidator"
+)
+
+// Paths of the self signed certificate generated on first start when no certificate is configured
+var (
+	selfSignedCertFile = basePath + "/certs/ztp-dashboard.crt"
+	selfSignedKeyFile  = basePath + "/certs/ztp-dashboard.key"
+)
+
+// TLSEnabled tells if the HTTPS listener is configured
+func TLSEnabled() bool {
+	return os.Getenv("APP_TLS_PORT") != ""
+}
+
+// tlsSelfSigned tells if the HTTPS listener uses the generated self signed certificate
+func tlsSelfSigned() bool {
+	return os.Getenv("APP_TLS_CERT") == "" || os.Getenv("APP_TLS_KEY") == ""
+}
+
+// TLSCertificate returns the certificate and key files for the HTTPS listener. If APP_TLS_CERT
+// and APP_TLS_KEY are not set, a self signed certificate is generated on first start and reused after
+func TLSCertificate() (string, string, error) {
+	if !tlsSelfSigned() {
+		return os.Getenv("APP_TLS_CERT"), os.Getenv("APP_TLS_KEY"), nil
+	}
+	_, certErr := os.Stat(selfSignedCertFile)
+	_, keyErr := os.Stat(selfSignedKeyFile)
+	if certErr == nil && keyErr == nil {
+		return selfSignedCertFile, selfSignedKeyFile, nil
+	}
+	CreateDirIfNotExist(basePath + "/certs")
+	err := generateSelfSignedCertificate(selfSignedCertFile, selfSignedKeyFile)
+	if err != nil {
+		return "", "", err
+	}
+	Log.Info("TLSCertificate: self signed certificate generated", F("file", selfSignedCertFile))
+	return selfSignedCertFile, selfSignedKeyFile, nil
+}
+
+// generateSelfSignedCertificate creates a certificate valid for the host name and the local addresses
+func generateSelfSignedCertificate(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	certTemplate := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"ZTP Dashboard"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if hostname != "" {
+		certTemplate.DNSNames = append(certTemplate.DNSNames, hostname)
+	}
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			certTemplate.IPAddresses = append(certTemplate.IPAddresses, ipNet.IP)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &certTemplate, &certTemplate, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certOut, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer certOut.Close()
+	err = pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err != nil {
+		return err
+	}
+	keyOut, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer keyOut.Close()
+	return pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// HTTPHandler returns the handler for the plain HTTP listener. When APP_TLS_ENFORCE is on, the
+// dashboard and API paths are redirected to HTTPS, while the paths used by devices during
+// provisioning are still served over HTTP
+func HTTPHandler(next http.Handler) http.Handler {
+	if !TLSEnabled() || os.Getenv("APP_TLS_ENFORCE") != "on" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isDevicePath(r.URL.Path) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if govalidator.IsIPv6(host) {
+				host = "[" + host + "]"
+			}
+			target := "https://" + host + ":" + os.Getenv("APP_TLS_PORT") + r.URL.RequestURI()
+			// 308 keeps the method and body of API calls
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isDevicePath tells if the path is downloaded or called by devices while provisioning
+func isDevicePath(path string) bool {
+	for _, prefix := range []string{"/scripts/", "/configs/", "/images/", "/api/devices/provisioned"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceSupportsTLS tells if the device downloads its files over HTTPS. XR devices fetch the
+// config with curl from the ZTP script, which supports HTTPS. NX devices use POAP over TFTP/SCP
+func deviceSupportsTLS(device model.Device) bool {
+	return TLSEnabled() && os.Getenv("DEVICE_TLS") == "on" && device.DeviceType.Name == "iOS-XR"
+}
+
+// deviceServerURL returns the base URL the ZTP script uses to reach the web server, with the
+// scheme and port the device supports
+func deviceServerURL(device model.Device, serverIP string) string {
+	return serverURL(serverIP, deviceSupportsTLS(device))
+}
+
+// deviceBootURL returns the base URL of the files given in the DHCP options. iPXE and the ZTP
+// agent cannot be told to trust the self signed certificate, so they only use HTTPS with an
+// operator provided certificate
+func deviceBootURL(device model.Device, serverIP string) string {
+	return serverURL(serverIP, deviceSupportsTLS(device) && !tlsSelfSigned())
+}
+
+// serverURL returns the base URL of the HTTPS or HTTP listener at the given address
+func serverURL(serverIP string, useTLS bool) string {
+	if govalidator.IsIPv6(serverIP) {
+		serverIP = "[" + serverIP + "]"
+	}
+	if useTLS {
+		return "https://" + serverIP + ":" + os.Getenv("APP_TLS_PORT")
+	}
+	return "http://" + serverIP + ":" + os.Getenv("APP_WEB_PORT")
+}
+
+// selfSignedPin returns the PEM of the self signed certificate and the curl pin of its public key,
+// sha256// followed by the base64 SHA-256 of the subject public key info
+func selfSignedPin() (string, string, error) {
+	content, err := ioutil.ReadFile(selfSignedCertFile)
+	if err != nil {
+		return "", "", err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return "", "", errors.New("no certificate in " + selfSignedCertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return string(content), "sha256//" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// watchdogInterval is the time between two checks of the provisioning devices
+const watchdogInterval = time.Minute
+
+// defaultProvisioningTimeouts are used for the statuses without timeout in the settings
+var defaultProvisioningTimeouts = []model.ProvisioningTimeout{
+	{Status: downloadStatuses["scripts"].status, Minutes: 30},
+	{Status: downloadStatuses["configs"].status, Minutes: 30},
+	{Status: downloadStatuses["images"].status, Minutes: 45},
+}
+
+// provisioningTimeout returns the timeout of a status for a device type, 0 when the status does not
+// time out. Timeouts of the settings come before the default ones, and timeouts for the device type
+// before the ones for every type
+func provisioningTimeout(timeouts []model.ProvisioningTimeout, status string, deviceType string) time.Duration {
+	for _, candidates := range [][]model.ProvisioningTimeout{timeouts, defaultProvisioningTimeouts} {
+		for _, forType := range []string{deviceType, ""} {
+			for _, timeout := range candidates {
+				if timeout.Status == status && timeout.DeviceType == forType {
+					return time.Duration(timeout.Minutes) * time.Minute
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// validateProvisioningTimeouts returns a message for the first invalid timeout, or an empty string
+func validateProvisioningTimeouts(timeouts []model.ProvisioningTimeout) string {
+	for _, timeout := range timeouts {
+		if timeout.Status == "" {
+			return "Provisioning timeouts need a status"
+		}
+		if timeout.Minutes < 0 {
+			return "Provisioning timeout of " + timeout.Status + " is negative"
+		}
+	}
+	return ""
+}
+
+// provisioningWatchdog times out the devices that stay too long in a provisioning status, e.g. a
+// device that died after downloading its script
+type provisioningWatchdog struct {
+	db dbController
+}
+
+// run checks the devices every watchdogInterval
+func (p provisioningWatchdog) run() {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.check()
+	}
+}
+
+// check times out the devices that spent more than the timeout of their status since their last
+// status change
+func (p provisioningWatchdog) check() {
+	settings, err := notificationSettings()
+	if err != nil {
+		Log.Error("provisioningWatchdog (read settings)", F("error", err))
+		return
+	}
+	session, err := p.db.OpenSession()
+	if err != nil {
+		Log.Error("provisioningWatchdog (open database)", F("error", err))
+		return
+	}
+	defer session.Close()
+
+	var devices []model.Device
+	err = session.DB("ztpDashboard").C("device").Find(nil).All(&devices)
+	if err != nil {
+		Log.Error("provisioningWatchdog (read database)", F("error", err))
+		return
+	}
+	for _, device := range devices {
+		timeout := provisioningTimeout(settings.ProvisioningTimeouts, device.Status, device.DeviceType.Name)
+		if timeout == 0 {
+			continue
+		}
+		change, err := lastStatusChange(session, device.Hostname)
+		if err != nil {
+			Log.Error("provisioningWatchdog (read timeline)", append(deviceFields(device.Hostname, device.Serial), F("error", err))...)
+			continue
+		}
+		// Devices without timeline were added before the timeline existed
+		if change == nil || change.Status != device.Status || time.Since(change.Time) < timeout {
+			continue
+		}
+		p.timeOut(session, device, change.Time, timeout)
+	}
+}
+
+// timeOut marks the device as timed out, unless its status changed in the meantime
+func (p provisioningWatchdog) timeOut(session storeSession, device model.Device, since time.Time, timeout time.Duration) {
+	previousStatus := device.Status
+	err := session.DB("ztpDashboard").C("device").Update(bson.M{"hostname": device.Hostname, "status": previousStatus}, bson.M{"$set": bson.M{"status": provisioningTimedOutStatus}})
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		Log.Error("provisioningWatchdog (update database)", append(deviceFields(device.Hostname, device.Serial), F("error", err))...)
+		return
+	}
+	detail := "no progress in " + previousStatus + " for " + timeout.String()
+	Log.Warn("provisioningWatchdog: Device timed out", append(deviceFields(device.Hostname, device.Serial), F("status", previousStatus), F("timeout", timeout.String()))...)
+
+	stuck := deviceStuckAlert(device, since)
+	device.Status = provisioningTimedOutStatus
+	recordDeviceStatus(device, "Timed out: "+detail)
+	Notify(Notification{Type: model.NotifyDeviceTimedOut, Hostname: device.Hostname, Serial: device.Serial, Status: device.Status, Detail: detail})
+	go SituationMgrCtl.RaiseDeviceEvent(device, situationProvisioning, LevelError, "Provisioning of device "+device.Hostname+" timed out: "+detail)
+	go alertCtl.fireAlert(stuck)
+}
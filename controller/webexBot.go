@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/mux"
+)
+
+// webexWebhookName identifies the webhook registered by the dashboard, so it is replaced on restarts
+const webexWebhookName = "ztp-dashboard"
+
+// webexWebhookPath receives the messages sent to the bot
+const webexWebhookPath = "/api/webex/webhook"
+
+// webexMaxBodySize limits the webhook payloads, which only reference the message
+const webexMaxBodySize = 64 * 1024
+
+// failedDeviceStatuses are the statuses listed by the "list failed" command
+var failedDeviceStatuses = []string{provisioningFailedStatus, provisioningTimedOutStatus, testsFailedStatus, "Unreachable"}
+
+// webexWebhook is a webhook of the bot, as registered and as listed by Webex Teams
+type webexWebhook struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	TargetURL string `json:"targetUrl"`
+	Resource  string `json:"resource"`
+	Event     string `json:"event"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+// webexWebhookEvent is the body of a webhook call. The message text is not included, it is read
+// with the message ID
+type webexWebhookEvent struct {
+	Resource string `json:"resource"`
+	Event    string `json:"event"`
+	Data     struct {
+		ID          string `json:"id"`
+		RoomID      string `json:"roomId"`
+		PersonEmail string `json:"personEmail"`
+	} `json:"data"`
+}
+
+// webexReceivedMessage is a message read from Webex Teams
+type webexReceivedMessage struct {
+	ID     string `json:"id"`
+	RoomID string `json:"roomId"`
+	Text   string `json:"text"`
+}
+
+// registerRoutes specifies what are the URL that this controller will respond to
+func (w WebexTeamsController) registerRoutes(r *mux.Router) {
+	r.HandleFunc(webexWebhookPath, w.handleWebhook)
+}
+
+// webhookSecret is the shared secret of the webhook, from WEBEX_WEBHOOK_SECRET
+func (w WebexTeamsController) webhookSecret() string {
+	return os.Getenv("WEBEX_WEBHOOK_SECRET")
+}
+
+// RegisterWebhook registers the bot webhook for the messages sent to it. targetURL is the public URL
+// of the dashboard, the webhook of a previous start is replaced
+func (w WebexTeamsController) RegisterWebhook(targetURL string) error {
+	if os.Getenv("WEBEX_BOT_TOKEN") == "" || w.webhookSecret() == "" {
+		return errors.New("the webex bot needs WEBEX_BOT_TOKEN and WEBEX_WEBHOOK_SECRET")
+	}
+
+	resp, err := w.makeCall("GET", "/v1/webhooks", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("webex teams returned status " + resp.Status + " listing the webhooks")
+	}
+	var webhooks struct {
+		Items []webexWebhook `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&webhooks)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks.Items {
+		if webhook.Name != webexWebhookName {
+			continue
+		}
+		deleteResp, err := w.makeCall("DELETE", "/v1/webhooks/"+webhook.ID, nil)
+		if err != nil {
+			return err
+		}
+		deleteResp.Body.Close()
+	}
+
+	payload, err := json.Marshal(webexWebhook{
+		Name:      webexWebhookName,
+		TargetURL: strings.TrimSuffix(targetURL, "/") + webexWebhookPath,
+		Resource:  "messages",
+		Event:     "created",
+		Secret:    w.webhookSecret(),
+	})
+	if err != nil {
+		return err
+	}
+	createResp, err := w.makeCall("POST", "/v1/webhooks", payload)
+	if err != nil {
+		return err
+	}
+	defer createResp.Body.Close()
+	if !(createResp.StatusCode >= 200 && createResp.StatusCode <= 299) {
+		return errors.New("webex teams returned status " + createResp.Status + " creating the webhook")
+	}
+	return nil
+}
+
+// validWebhookSignature checks the X-Spark-Signature header, the HMAC-SHA1 of the body with the
+// webhook secret
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal([]byte(strings.ToLower(signature)), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+// handleWebhook receives the messages sent to the bot and answers the commands in the room
+func (w WebexTeamsController) handleWebhook(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if w.webhookSecret() == "" {
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte("Webex bot not configured"))
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, webexMaxBodySize))
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+		if !validWebhookSignature(w.webhookSecret(), body, r.Header.Get("X-Spark-Signature")) {
+			requestLog(r).Warn("handleWebhook: invalid webhook signature")
+			rw.WriteHeader(http.StatusUnauthorized)
+			rw.Write([]byte("Invalid signature"))
+			return
+		}
+		var event webexWebhookEvent
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(err.Error()))
+			return
+		}
+
+		// Messages of bots, including the answers of this one, are not commands
+		if event.Resource == "messages" && event.Event == "created" && !strings.HasSuffix(event.Data.PersonEmail, "@webex.bot") {
+			go w.answerMessage(event.Data.ID, event.Data.RoomID)
+		}
+		rw.Write([]byte("ok"))
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		rw.Write([]byte("Method not allowed"))
+	}
+}
+
+// answerMessage reads a message sent to the bot and posts the answer of its command. Only the rooms
+// of the enabled Webex Teams channels are answered
+func (w WebexTeamsController) answerMessage(messageID string, roomID string) {
+	logger := Log.With(F("messageId", messageID), F("roomId", roomID))
+	settings, err := notificationSettings()
+	if err != nil {
+		logger.Error("answerMessage (read settings)", F("error", err))
+		return
+	}
+	if !webexBotRoom(settings, roomID) {
+		logger.Warn("answerMessage: message from a room without notification channel, ignored")
+		return
+	}
+
+	message, err := w.readMessage(messageID)
+	if err != nil {
+		logger.Error("answerMessage (read message)", F("error", err))
+		return
+	}
+	logger.Info("answerMessage: command received", F("text", message.Text))
+	err = w.SendMessage(roomID, w.runCommand(message.Text))
+	if err != nil {
+		logger.Error("answerMessage (send answer)", F("error", err))
+	}
+}
+
+// webexBotRoom tells if the room is the one of an enabled Webex Teams channel
+func webexBotRoom(settings model.Settings, roomID string) bool {
+	for _, channel := range notificationChannels(settings) {
+		if channel.Enabled && channel.Type == model.NotificationWebex && channel.RoomID == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// readMessage returns a message by ID. Bots can only read the messages that mention them, or sent
+// in a direct room
+func (w WebexTeamsController) readMessage(messageID string) (*webexReceivedMessage, error) {
+	resp, err := w.makeCall("GET", "/v1/messages/"+messageID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("webex teams returned status " + resp.Status + " reading the message")
+	}
+	var message webexReceivedMessage
+	err = json.NewDecoder(resp.Body).Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// parseBotCommand returns the command of a message and its argument. In group rooms the message
+// starts with the name of the bot, so the words before the command are skipped
+func parseBotCommand(text string) (string, string) {
+	words := strings.Fields(text)
+	for i, word := range words {
+		command := strings.ToLower(word)
+		argument := strings.Join(words[i+1:], " ")
+		switch command {
+		case "status", "retest", "summary", "help":
+			return command, argument
+		case "list":
+			return command, strings.ToLower(argument)
+		}
+	}
+	return "", ""
+}
+
+// runCommand answers a message sent to the bot, in markdown
+func (w WebexTeamsController) runCommand(text string) string {
+	command, argument := parseBotCommand(text)
+	session, err := deviceCtl.db.OpenSession()
+	if err != nil {
+		Log.Error("runCommand (open database)", F("error", err))
+		return "Cannot read the devices: " + err.Error()
+	}
+	defer session.Close()
+
+	switch {
+	case command == "status" && argument != "":
+		return botDeviceStatus(session, argument)
+	case command == "list" && argument == "failed":
+		return botFailedDevices(session)
+	case command == "retest" && argument != "":
+		return botRetest(session, argument)
+	case command == "summary":
+		return botSummary(session)
+	}
+	return "Commands:\n\n" +
+		"- `status <hostname>`: provisioning status of a device\n" +
+		"- `list failed`: devices that failed their provisioning or tests\n" +
+		"- `retest <serial>`: run the test suites of a device again\n" +
+		"- `summary`: number of devices by status"
+}
+
+// botDeviceStatus answers the status command, with the last change of the timeline
+func botDeviceStatus(session storeSession, hostname string) string {
+	device, err := apiV1Ctl.findDevice(session, hostname)
+	if err == mgo.ErrNotFound {
+		return "Device " + hostname + " not found."
+	}
+	if err != nil {
+		return "Cannot read device " + hostname + ": " + err.Error()
+	}
+	answer := "Device **" + device.Hostname + "** (serial " + device.Serial + ", " + device.DeviceType.Name + ", " + device.Fixedip + ") is **" + device.Status + "**"
+
+	change, err := lastStatusChange(session, device.Hostname)
+	if err == nil && change != nil {
+		answer += " since " + change.Time.Format("2006-01-02 15:04 MST")
+		if change.Detail != "" {
+			answer += " (" + change.Detail + ")"
+		}
+	}
+	return answer + "."
+}
+
+// botFailedDevices answers the list failed command
+func botFailedDevices(session storeSession) string {
+	var devices []model.Device
+	err := session.DB("ztpDashboard").C("device").Find(bson.M{"status": bson.M{"$in": failedDeviceStatuses}}).Sort("hostname").All(&devices)
+	if err != nil {
+		return "Cannot read the devices: " + err.Error()
+	}
+	if len(devices) == 0 {
+		return "No failed devices."
+	}
+	lines := []string{strconv.Itoa(len(devices)) + " failed devices:", ""}
+	for _, device := range devices {
+		lines = append(lines, "- **"+device.Hostname+"** (serial "+device.Serial+"): "+device.Status)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// botRetest answers the retest command once the test ended
+func botRetest(session storeSession, serial string) string {
+	device, err := apiV1Ctl.findDevice(session, serial)
+	if err == mgo.ErrNotFound {
+		return "Device " + serial + " not found."
+	}
+	if err != nil {
+		return "Cannot read device " + serial + ": " + err.Error()
+	}
+	if device.Fixedip == "" {
+		return "Device " + device.Hostname + " has no address to test."
+	}
+	runs := testController.TestDevice(*device, testTriggerBot)
+	device, err = apiV1Ctl.findDevice(session, serial)
+	if err != nil {
+		return "Cannot read device " + serial + " after the test: " + err.Error()
+	}
+	lines := []string{"Tested device **" + device.Hostname + "** (" + device.Fixedip + "), it is **" + device.Status + "**.", ""}
+	for _, run := range runs {
+		for _, result := range run.Results {
+			outcome := "passed"
+			if !result.Passed {
+				outcome = "failed"
+			}
+			lines = append(lines, "- "+run.Suite+"/"+result.Name+": "+outcome+" ("+result.Detail+")")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// botSummary answers the summary command
+func botSummary(session storeSession) string {
+	var devices []model.Device
+	err := session.DB("ztpDashboard").C("device").Find(nil).Select(bson.M{"status": 1}).All(&devices)
+	if err != nil {
+		return "Cannot read the devices: " + err.Error()
+	}
+	if len(devices) == 0 {
+		return "No devices."
+	}
+	counts := make(map[string]int)
+	for _, device := range devices {
+		counts[device.Status]++
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	lines := []string{strconv.Itoa(len(devices)) + " devices:", ""}
+	for _, status := range statuses {
+		name := status
+		if name == "" {
+			name = "No status"
+		}
+		lines = append(lines, "- "+name+": "+strconv.Itoa(counts[status]))
+	}
+	return strings.Join(lines, "\n")
+}
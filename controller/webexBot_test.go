@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CiscoSE/ztp-dashboard/model"
+)
+
+const (
+	testBotSecret = "bot-secret"
+	testBotToken  = "bot-token"
+	testBotRoom   = "room1"
+)
+
+type postedMessage struct {
+	authorization string
+	message       webexMessage
+}
+
+// fakeWebex serves the messages read by the bot and records the ones it posts
+type fakeWebex struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	messages map[string]webexReceivedMessage
+	read     []string
+	posted   chan postedMessage
+}
+
+func newFakeWebex(t *testing.T) *fakeWebex {
+	fake := &fakeWebex{messages: make(map[string]webexReceivedMessage), posted: make(chan postedMessage, 10)}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/messages/"):
+			id := strings.TrimPrefix(r.URL.Path, "/v1/messages/")
+			fake.mutex.Lock()
+			message, present := fake.messages[id]
+			fake.read = append(fake.read, id)
+			fake.mutex.Unlock()
+			if !present {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(message)
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/messages":
+			var message webexMessage
+			json.NewDecoder(r.Body).Decode(&message)
+			fake.posted <- postedMessage{authorization: r.Header.Get("Authorization"), message: message}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeWebex) addMessage(id string, text string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.messages[id] = webexReceivedMessage{ID: id, RoomID: testBotRoom, Text: text}
+}
+
+func (f *fakeWebex) readMessages() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.read...)
+}
+
+// newWebexBotServer starts the dashboard with the bot configured to use the fake Webex Teams API
+func newWebexBotServer(t *testing.T) (*httptest.Server, *fakeWebex) {
+	resetTestStore(t)
+	t.Setenv("WEBEX_WEBHOOK_SECRET", testBotSecret)
+	t.Setenv("WEBEX_BOT_TOKEN", testBotToken)
+	saveTestSettings(t, model.Settings{WebexTeamsRoomID: testBotRoom})
+
+	fake := newFakeWebex(t)
+	baseURL := WebexTeamsCtl.BaseURL
+	WebexTeamsCtl.BaseURL = fake.URL
+	t.Cleanup(func() { WebexTeamsCtl.BaseURL = baseURL })
+	return newTestServer(t, nil), fake
+}
+
+// postWebhook sends the webhook call of a message created by sender, signed with secret
+func postWebhook(t *testing.T, server *httptest.Server, messageID string, sender string, secret string) int {
+	event := webexWebhookEvent{Resource: "messages", Event: "created"}
+	event.Data.ID = messageID
+	event.Data.RoomID = testBotRoom
+	event.Data.PersonEmail = sender
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, server.URL+webexWebhookPath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Spark-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// waitAnswer returns the next message posted by the bot
+func waitAnswer(t *testing.T, fake *fakeWebex) string {
+	select {
+	case posted := <-fake.posted:
+		if posted.authorization != "Bearer "+testBotToken {
+			t.Errorf("answer sent with Authorization %q", posted.authorization)
+		}
+		if posted.message.RoomID != testBotRoom {
+			t.Errorf("answer sent to room %q, want %s", posted.message.RoomID, testBotRoom)
+		}
+		return posted.message.Markdown
+	case <-time.After(5 * time.Second):
+		t.Fatal("the bot did not answer")
+	}
+	return ""
+}
+
+// ask sends a command to the bot and returns its answer
+func ask(t *testing.T, server *httptest.Server, fake *fakeWebex, messageID string, text string) string {
+	fake.addMessage(messageID, "ZTP "+text)
+	if status := postWebhook(t, server, messageID, "user@example.com", testBotSecret); status != http.StatusOK {
+		t.Fatalf("webhook call for %q returned %d", text, status)
+	}
+	return waitAnswer(t, fake)
+}
+
+func TestWebexBotWebhook(t *testing.T) {
+	server, fake := newWebexBotServer(t)
+	fake.addMessage("forged", "summary")
+	fake.addMessage("unsigned", "summary")
+	fake.addMessage("bot", "summary")
+
+	if status := postWebhook(t, server, "forged", "user@example.com", "other-secret"); status != http.StatusUnauthorized {
+		t.Errorf("webhook call with an invalid signature returned %d, want 401", status)
+	}
+	if status := postWebhook(t, server, "unsigned", "user@example.com", ""); status != http.StatusUnauthorized {
+		t.Errorf("webhook call without signature returned %d, want 401", status)
+	}
+	if status := postWebhook(t, server, "bot", "ztp@webex.bot", testBotSecret); status != http.StatusOK {
+		t.Errorf("webhook call for a bot message returned %d, want 200", status)
+	}
+
+	// Only the message of the user is read and answered
+	answer := ask(t, server, fake, "user", "help")
+	if !strings.HasPrefix(answer, "Commands:") {
+		t.Errorf("help answer is %q", answer)
+	}
+	select {
+	case posted := <-fake.posted:
+		t.Errorf("unexpected answer %q", posted.message.Markdown)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if read := fake.readMessages(); strings.Join(read, ",") != "user" {
+		t.Errorf("the bot read the messages %v, want only the user message", read)
+	}
+}
+
+func TestWebexBotCommands(t *testing.T) {
+	server, fake := newWebexBotServer(t)
+
+	// The retested device answers on a local port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	session, err := openDBSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	xr := model.DeviceType{Name: "iOS-XR"}
+	for _, device := range []model.Device{
+		{Hostname: "bot1", Serial: "BOT1", Fixedip: "10.0.0.21", DeviceType: xr, Status: "Provisioned"},
+		{Hostname: "bot2", Serial: "BOT2", Fixedip: "10.0.0.22", DeviceType: xr, Status: provisioningFailedStatus},
+		{Hostname: "bot3", Serial: "BOT3", Fixedip: "10.0.0.23", DeviceType: xr, Status: testsFailedStatus},
+		{Hostname: "bot4", Serial: "BOT4", Fixedip: "127.0.0.1", DeviceType: xr, Status: "Unreachable"},
+	} {
+		err = session.DB("ztpDashboard").C("device").Insert(&device)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = session.DB("ztpDashboard").C("deviceStatus").Insert(&model.DeviceStatusChange{
+		Time:     time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		Hostname: "bot1",
+		Serial:   "BOT1",
+		Status:   "Provisioned",
+		Detail:   "ZTP script finished",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.DB("ztpDashboard").C("testSuite").Insert(&model.TestSuite{
+		Name:    "bot",
+		Devices: []string{"bot4"},
+		Tests:   []model.DeviceTest{{Name: "tcp", Kind: model.TestTCP, Port: port, Timeout: 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := []struct {
+		text   string
+		answer string
+	}{
+		{"status bot1", "Device **bot1** (serial BOT1, iOS-XR, 10.0.0.21) is **Provisioned** since 2026-10-19 08:00 UTC (ZTP script finished)."},
+		{"status missing", "Device missing not found."},
+		{"list failed", "3 failed devices:\n\n- **bot2** (serial BOT2): Provisioning failed\n- **bot3** (serial BOT3): Tests failed\n- **bot4** (serial BOT4): Unreachable"},
+		{"summary", "4 devices:\n\n- Provisioned: 1\n- Provisioning failed: 1\n- Tests failed: 1\n- Unreachable: 1"},
+		{"retest BOT4", "Tested device **bot4** (127.0.0.1), it is **Reachable**.\n\n- bot/tcp: passed (127.0.0.1:" + strconv.Itoa(port) + " open)"},
+		{"list failed", "2 failed devices:\n\n- **bot2** (serial BOT2): Provisioning failed\n- **bot3** (serial BOT3): Tests failed"},
+	}
+	for i, command := range commands {
+		answer := ask(t, server, fake, "command"+strconv.Itoa(i), command.text)
+		if answer != command.answer {
+			t.Errorf("answer to %q is %q, want %q", command.text, answer, command.answer)
+		}
+	}
+}
@@ -2,12 +2,10 @@ package controller
 
 import (
 	"bytes"
-	"html/template"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
-
-	"github.com/CiscoSE/ztp-dashboard/model"
-	"github.com/globalsign/mgo/bson"
 )
 
 // WebexTeamsController encapsulates all request to Cisco Webex teams
@@ -16,82 +14,31 @@ type WebexTeamsController struct {
 	db      dbController
 }
 
-// messageTemplateParams encapsulates all variables for the message template
-type messageTemplateParams struct {
-	Message string
-	RoomID  string
+// webexMessage is the payload of a message posted in a room
+type webexMessage struct {
+	RoomID   string `json:"roomId"`
+	Markdown string `json:"markdown"`
 }
 
-// SendMessage uses webex teams to send a message to the configured room ID
-func (w WebexTeamsController) SendMessage(message string) {
-	// Get room ID from database
-
-	// Open database
-	session, err := w.db.OpenSession()
-	if err != nil {
-		go CustomLog("SendMessage (open database): "+err.Error(), ErrorSeverity)
-		return
-	}
-	defer session.Close()
-
-	dbCollection := session.DB("ztpDashboard").C("settings")
-
-	settingsCollection := dbCollection.Find(bson.M{})
-	count, err := settingsCollection.Count()
-	if err != nil {
-		go CustomLog("SendMessage (read database): "+err.Error(), ErrorSeverity)
-		return
-	}
-	if count == 0 {
-		go CustomLog("No settings in database, have you configure the settings? ", DebugSeverity)
-		return
-	}
-
-	var settings model.Settings
-	err = settingsCollection.One(&settings)
-	if err != nil {
-		go CustomLog("SendMessage (read database): "+err.Error(), ErrorSeverity)
-		return
-	}
-
-	// check that there is a valid roomID and token
-	if settings.WebexTeamsRoomID == "" {
-		go CustomLog("makeCall (Webex Room ID) No webex teams room ID configured. Cannot send message", DebugSeverity)
-		return
-	}
+// SendMessage sends a message to a Webex Teams room with the bot token of WEBEX_BOT_TOKEN
+func (w WebexTeamsController) SendMessage(roomID string, message string) error {
 	if os.Getenv("WEBEX_BOT_TOKEN") == "" {
-		go CustomLog("makeCall (Webex Token): No webex teams token configured. Cannot send message", DebugSeverity)
-		return
-	}
-	// Create request
-
-	// 'buf' is an io.Writter to capture the template execution output
-	payload := new(bytes.Buffer)
-	templateParams := &messageTemplateParams{
-		Message: message,
-		RoomID:  settings.WebexTeamsRoomID,
-	}
-	// Read the json template file
-	t, err := template.ParseFiles(basePath + "/jsonTemplates/addWebexTeamsMessage.json")
-	if err != nil {
-		go CustomLog("SendMessage (parse template): "+err.Error(), ErrorSeverity)
-		return
+		return errors.New("no webex teams token configured")
 	}
 
-	err = t.Execute(payload, templateParams)
+	payload, err := json.Marshal(webexMessage{RoomID: roomID, Markdown: message})
 	if err != nil {
-		go CustomLog("SendMessage (execute template): "+err.Error(), ErrorSeverity)
-		return
+		return err
 	}
-	resp, err := w.makeCall("POST", "/v1/messages", payload.Bytes())
-
+	resp, err := w.makeCall("POST", "/v1/messages", payload)
 	if err != nil {
-		go CustomLog("SendMessage (make call): "+err.Error(), ErrorSeverity)
-		return
+		return err
 	}
+	defer resp.Body.Close()
 	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
-		go CustomLog("SendMessage (make call): webex teams returned status code "+string(resp.StatusCode), ErrorSeverity)
+		return responseDeliveryError("webex teams", resp)
 	}
+	return nil
 }
 
 // Single point to make calls to webex teams
@@ -101,8 +48,8 @@ func (w WebexTeamsController) makeCall(method string, url string, payload []byte
 	botToken := os.Getenv("WEBEX_BOT_TOKEN")
 	callURL := w.BaseURL + url
 
-	go CustomLog("Making call to WebexTeams -> "+method+": "+callURL, DebugSeverity)
-	go CustomLog("Payload -> "+string(payload[:]), DebugSeverity)
+	Log.Debug("Making call to WebexTeams", F("method", method), F("url", callURL))
+	Log.Debug("WebexTeams payload", F("payload", string(payload)))
 
 	// Create request
 	req, _ := http.NewRequest(method, callURL, bytes.NewBuffer(payload))
@@ -111,12 +58,9 @@ func (w WebexTeamsController) makeCall(method string, url string, payload []byte
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("authorization", "Bearer "+botToken)
 
-	// Set up client
-	client := &http.Client{}
-
 	// Do request
-	resp, err := client.Do(req)
-	go CustomLog("Webex request done", DebugSeverity)
+	resp, err := notificationClient.Do(req)
+	Log.Debug("Webex request done")
 
 	// Return the results
 	return resp, err
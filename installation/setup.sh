@@ -14,6 +14,7 @@
 #export DHCP_SUBNET_NETMASK=
 #export DHCP_CONFIG_PATH=/etc/dhcp/dhcpd.conf
 #export DHCP_SERVICE_RESTART_CMD="systemctl restart isc-dhcp-server"
+#export DHCP_LEASES_PATH=/var/lib/dhcp/dhcpd.leases
 
 # DHCP v6 information
 #export DHCP6_NAMESERVERS=
@@ -21,11 +22,31 @@
 #export DHCP6_SUBNET_NETMASK=
 #export DHCP6_CONFIG_PATH=/etc/dhcp/dhcpd6.conf
 #export DHCP6_SERVICE_RESTART_CMD="systemctl restart isc-dhcp-server6"
+#export DHCP6_LEASES_PATH=/var/lib/dhcp/dhcpd6.leases
+
+# Kea control agent URL. Leave empty to read leases from the ISC dhcpd lease files
+#export KEA_CONTROL_URL=
+
+# Embedded TFTP server. Set to on to serve scripts, configs and images without an external TFTP server
+#export TFTP_ENABLED=
+#export TFTP_PORT=69
 
 # Mongo URI to be used by the tool
 #export DB_URI=
 # Port to be listening for incomming web requests
 #export APP_WEB_PORT=8080
+# HTTPS listener port. Leave empty to serve only HTTP
+#export APP_TLS_PORT=
+# Certificate and key for HTTPS. Leave empty to generate a self signed certificate on first start
+#export APP_TLS_CERT=
+#export APP_TLS_KEY=
+# Set to on to redirect the dashboard and API to HTTPS. Device provisioning paths stay on HTTP
+#export APP_TLS_ENFORCE=
+# Set to on so XR devices download scripts, configs and images over HTTPS
+#export DEVICE_TLS=
+# First admin user, created when there are no users. A random password is logged if empty
+#export ADMIN_USERNAME=admin
+#export ADMIN_PASSWORD=
 # Token to be used when sending notifications
 #export WEBEX_BOT_TOKEN=
 # Enable for extra log information
@@ -62,6 +83,7 @@ sudo systemctl start isc-dhcp-server.service
 sudo systemctl start isc-dhcp-server6.service
 
 #install tftp server
+#not needed when the embedded TFTP server is enabled with TFTP_ENABLED=on
 
 sudo apt install -y xinetd tftpd tftp
 
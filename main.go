@@ -3,7 +3,6 @@ package main
 import (
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 
@@ -17,11 +16,38 @@ func main() {
 
 	templates := populateTemplates()
 
+	// The certificate is loaded before startup, since the ZTP scripts generated then pin it
+	var certFile, keyFile string
+	if controller.TLSEnabled() {
+		var err error
+		certFile, keyFile, err = controller.TLSCertificate()
+		if err != nil {
+			controller.Log.Error("Failed to load TLS certificate", controller.F("error", err))
+			os.Exit(1)
+		}
+	}
+
 	controller.Startup(templates, r)
-	log.Println("Listening in http://0.0.0.0:" + os.Getenv("APP_WEB_PORT") + "/web/")
-	err := http.ListenAndServe(":"+os.Getenv("APP_WEB_PORT"), r)
+
+	// Every request is authenticated, except the ones made by devices while provisioning
+	handler := controller.AuthHandler(r)
+
+	// Optional HTTPS listener
+	if controller.TLSEnabled() {
+		go func() {
+			controller.Log.Info("Listening", controller.F("url", "https://0.0.0.0:"+os.Getenv("APP_TLS_PORT")+"/web/"))
+			err := http.ListenAndServeTLS(":"+os.Getenv("APP_TLS_PORT"), certFile, keyFile, handler)
+			if err != nil {
+				controller.Log.Error("Failed to start HTTPS web server", controller.F("error", err))
+				os.Exit(1)
+			}
+		}()
+	}
+
+	controller.Log.Info("Listening", controller.F("url", "http://0.0.0.0:"+os.Getenv("APP_WEB_PORT")+"/web/"))
+	err := http.ListenAndServe(":"+os.Getenv("APP_WEB_PORT"), controller.HTTPHandler(handler))
 	if err != nil {
-		controller.CustomLog("Failed to start web server: "+err.Error(), controller.ErrorSeverity)
+		controller.Log.Error("Failed to start web server", controller.F("error", err))
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// Alert sink types
+const (
+	AlertSinkAlertmanager = "alertmanager"
+	AlertSinkPagerDuty    = "pagerduty"
+)
+
+// Alert names
+const (
+	AlertProvisioningFailed = "ZTPProvisioningFailed"
+	AlertDeviceStuck        = "ZTPDeviceStuck"
+	AlertDHCPApplyFailed    = "ZTPDHCPApplyFailed"
+)
+
+// AlertSink is an Alertmanager or a PagerDuty Events v2 compatible API
+type AlertSink struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	// URL is the Alertmanager base URL, or the PagerDuty events endpoint
+	URL string `json:"url"`
+	// RoutingKey is the PagerDuty integration key
+	RoutingKey string `json:"routingKey,omitempty"`
+}
+
+// Alert is a problem that stays firing until it is resolved. Key deduplicates the alerts, it is
+// based on the device serial for device alerts
+type Alert struct {
+	Key         string    `json:"key" bson:"_id"`
+	Name        string    `json:"name"`
+	Severity    string    `json:"severity"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	Summary     string    `json:"summary"`
+	Description string    `json:"description,omitempty"`
+	StartsAt    time.Time `json:"startsAt"`
+	// EndsAt is set on the resolved alerts sent to the sinks. Stored alerts are firing
+	EndsAt time.Time `json:"-" bson:"-"`
+}
@@ -0,0 +1,12 @@
+package model
+
+// APIError is the body returned by the v1 API when a request fails
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail holds the HTTP status code and a description of the error
+type APIErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
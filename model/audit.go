@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// AuditEntry records a change made to an object of the dashboard. Entries are never changed or removed
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	SourceIP   string    `json:"sourceIp"`
+	Action     string    `json:"action"`
+	ObjectType string    `json:"objectType"`
+	ObjectName string    `json:"objectName"`
+	// Before and After hold the object as returned by the API, empty when it did not exist
+	Before  map[string]interface{} `json:"before,omitempty"`
+	After   map[string]interface{} `json:"after,omitempty"`
+	Changes []AuditChange          `json:"changes"`
+}
+
+// AuditChange is a field that changed, identified by its JSON path (e.g. "image.name")
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
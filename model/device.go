@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // Device identifies the attributes for the network device
 type Device struct {
 	Hostname   string     `json:"hostname"`
@@ -9,9 +11,34 @@ type Device struct {
 	Config     Config     `json:"config"`
 	DeviceType DeviceType `json:"deviceType"`
 	Status     string     `json:"status"`
+	Scope      string     `json:"scope"`
+	// Switch port binding, used when the serial is not known in advance.
+	// Circuit and remote IDs come from the DHCPv4 relay agent (option 82),
+	// interface ID from the DHCPv6 relay (option 18)
+	RelayCircuitID string `json:"relayCircuitId"`
+	RelayRemoteID  string `json:"relayRemoteId"`
+	InterfaceID    string `json:"interfaceId"`
+	// StatusSince is the time of the last status change and TimeInStatus the seconds spent in the
+	// status since then. They come from the timeline and are only set by the device detail API
+	StatusSince  *time.Time `json:"statusSince,omitempty" bson:"-"`
+	TimeInStatus int64      `json:"timeInStatus,omitempty" bson:"-"`
 }
 
 // DeviceType identifies if the device is NX or XR type
 type DeviceType struct {
 	Name string `json:"name"`
 }
+
+// HasPortBinding tells if the device is identified by the port it is cabled to
+func (d Device) HasPortBinding() bool {
+	return d.RelayCircuitID != "" || d.RelayRemoteID != "" || d.InterfaceID != ""
+}
+
+// ScriptName returns the base name used for the day0 script of the device.
+// Devices bound to a switch port might not have a serial yet, so the hostname is used instead
+func (d Device) ScriptName() string {
+	if d.Serial != "" {
+		return d.Serial
+	}
+	return d.Hostname
+}
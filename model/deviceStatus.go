@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// DeviceStatusChange records a status reached by a device, so the provisioning of a device can be
+// followed over time
+type DeviceStatusChange struct {
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Serial   string    `json:"serial"`
+	Status   string    `json:"status"`
+	// Detail is the file downloaded, or a short description of the change
+	Detail string `json:"detail"`
+}
+
+// ProvisioningTimeout is the longest time a device can stay in a provisioning status before it
+// times out. DeviceType restricts the timeout to a device type, empty for every type
+type ProvisioningTimeout struct {
+	Status     string `json:"status"`
+	DeviceType string `json:"deviceType,omitempty"`
+	// Minutes is the timeout, 0 to never time out
+	Minutes int `json:"minutes"`
+}
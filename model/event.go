@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Event types. Changes made through the API use the object type and the audit action,
+// e.g. "device.create", "config.update" or "image.delete"
+const (
+	EventDeviceStatus = "device.status"
+	EventDHCPApply    = "dhcp.apply"
+)
+
+// Event is published when something changes in the dashboard and streamed by /api/events
+type Event struct {
+	// ID increases with each event, so clients can resume a stream
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Hostname and Serial identify the device of device events
+	Hostname string `json:"hostname,omitempty"`
+	Serial   string `json:"serial,omitempty"`
+	// Object is the name of the changed config, image or other object
+	Object  string `json:"object,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Lease represents an address handed out by the DHCP server
+type Lease struct {
+	Address      string    `json:"address"`
+	HardwareAddr string    `json:"hardwareAddress"`
+	ClientID     string    `json:"clientId"`
+	Serial       string    `json:"serial"`
+	ClientName   string    `json:"clientName"`
+	VendorClass  string    `json:"vendorClass"`
+	UserClass    string    `json:"userClass"`
+	CircuitID    string    `json:"circuitId"`
+	RemoteID     string    `json:"remoteId"`
+	State        string    `json:"state"`
+	Starts       time.Time `json:"starts"`
+	Ends         time.Time `json:"ends"`
+	// Hostname of the device in inventory matching this lease. Empty for unknown clients
+	Device string `json:"device"`
+}
+
+// AdoptRequest turns an unknown DHCP client into a device record
+type AdoptRequest struct {
+	ClientID   string     `json:"clientId"`
+	Hostname   string     `json:"hostname"`
+	Fixedip    string     `json:"fixedIp"`
+	Scope      string     `json:"scope"`
+	DeviceType DeviceType `json:"deviceType"`
+	Image      string     `json:"image"`
+	Config     string     `json:"config"`
+}
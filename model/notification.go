@@ -0,0 +1,35 @@
+package model
+
+// Notification types. Notification channels select them by type or prefix, e.g. "device"
+const (
+	NotifyDeviceCreate    = "device.create"
+	NotifyDeviceUpdate    = "device.update"
+	NotifyDeviceDelete    = "device.delete"
+	NotifyDeviceSerial    = "device.serial"
+	NotifyDeviceStatus    = "device.status"
+	NotifyProvisionFailed = "device.provisionFailed"
+	NotifyDeviceTimedOut  = "device.timedOut"
+	NotifyTestSucceeded   = "device.testSucceeded"
+	NotifyTestFailed      = "device.testFailed"
+	NotifyImageCreate     = "image.create"
+	NotifyScopeCreate     = "scope.create"
+	NotifyScopeUpdate     = "scope.update"
+	NotifyScopeDelete     = "scope.delete"
+	NotifySettingsUpdate  = "settings.update"
+)
+
+// NotificationTemplate replaces the default message of a notification type. Templates use the Go
+// text/template syntax with the fields of the notification, e.g. {{.Hostname}} or {{.Status}}
+type NotificationTemplate struct {
+	Type     string `json:"type"`
+	Template string `json:"template"`
+}
+
+// NotificationType describes a notification type and its message template
+type NotificationType struct {
+	Type            string `json:"type"`
+	Description     string `json:"description"`
+	DefaultTemplate string `json:"defaultTemplate"`
+	// Template is the template of the settings, or the default one
+	Template string `json:"template"`
+}
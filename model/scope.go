@@ -0,0 +1,23 @@
+package model
+
+// Scope represents a DHCP subnet served by the dashboard, usually a staging VLAN or a remote site
+// reached through a DHCP relay
+type Scope struct {
+	Name             string    `json:"name"`
+	Site             string    `json:"site"`
+	Subnet           string    `json:"subnet"`
+	Gateway          string    `json:"gateway"`
+	NameServers      []string  `json:"nameServers"`
+	Domain           string    `json:"domain"`
+	DefaultLeaseTime int       `json:"defaultLeaseTime"`
+	MaxLeaseTime     int       `json:"maxLeaseTime"`
+	ZtpServer        string    `json:"ztpServer"`
+	Exclusions       []IPRange `json:"exclusions"`
+	DynamicRange     IPRange   `json:"dynamicRange"`
+}
+
+// IPRange is an inclusive range of addresses
+type IPRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
@@ -4,4 +4,47 @@ package model
 type Settings struct {
 	SituationMgrURL  string `json:"situationMgrURL"`
 	WebexTeamsRoomID string `json:"webexTeamsRoomID"`
+	// NotificationChannels receive the notifications, in addition to the Webex Teams room above
+	NotificationChannels []NotificationChannel `json:"notificationChannels"`
+	// NotificationTemplates replace the default messages of some notification types
+	NotificationTemplates []NotificationTemplate `json:"notificationTemplates"`
+	// AlertSinks receive the firing and resolved alerts
+	AlertSinks []AlertSink `json:"alertSinks"`
+	// ProvisioningTimeouts replace the default timeouts of the provisioning statuses
+	ProvisioningTimeouts []ProvisioningTimeout `json:"provisioningTimeouts"`
+}
+
+// Notification channel types
+const (
+	NotificationWebex   = "webex"
+	NotificationSlack   = "slack"
+	NotificationEmail   = "email"
+	NotificationWebhook = "webhook"
+)
+
+// NotificationChannel is a destination of the notifications. The fields used depend on the type
+type NotificationChannel struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+	// Events are the notification types or prefixes sent to the channel, e.g. "device.status" or
+	// "device". Empty means every notification
+	Events []string `json:"events"`
+	// RateLimit is the maximum number of messages per minute, 0 for no limit
+	RateLimit int `json:"rateLimit,omitempty"`
+	// DigestInterval batches the device status changes into one message every DigestInterval
+	// minutes, 0 to send each change
+	DigestInterval int `json:"digestInterval,omitempty"`
+	// RoomID is the Webex Teams room
+	RoomID string `json:"roomID,omitempty"`
+	// URL is the Slack incoming webhook or the generic webhook
+	URL string `json:"url,omitempty"`
+	// Secret signs the generic webhook payloads with HMAC-SHA256
+	Secret string `json:"secret,omitempty"`
+	// SMTPServer is the host:port of the mail server
+	SMTPServer   string   `json:"smtpServer,omitempty"`
+	SMTPUsername string   `json:"smtpUsername,omitempty"`
+	SMTPPassword string   `json:"smtpPassword,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
 }
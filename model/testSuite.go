@@ -0,0 +1,67 @@
+package model
+
+import "time"
+
+// Test kinds
+const (
+	TestICMP = "icmp"
+	TestTCP  = "tcp"
+	TestHTTP = "http"
+	TestSSH  = "ssh"
+)
+
+// TestSuite is a list of tests run on the devices once they are provisioned. A suite applies to the
+// devices of its device types and to the devices listed by hostname or serial
+type TestSuite struct {
+	Name        string       `json:"name"`
+	DeviceTypes []string     `json:"deviceTypes"`
+	Devices     []string     `json:"devices"`
+	Tests       []DeviceTest `json:"tests"`
+}
+
+// DeviceTest is a test of a suite. The fields used depend on the kind
+type DeviceTest struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Port of the tcp, http and ssh tests. HTTP uses 80, or 443 with TLS, and SSH 22 by default
+	Port int `json:"port,omitempty"`
+	// TLS makes the http test use HTTPS. Certificates of the devices are not verified
+	TLS bool `json:"tls,omitempty"`
+	// Path of the http test, / by default
+	Path string `json:"path,omitempty"`
+	// ExpectStatus is the HTTP status code expected, any status below 400 when 0
+	ExpectStatus int `json:"expectStatus,omitempty"`
+	// Command is run by the ssh test
+	Command string `json:"command,omitempty"`
+	// Expect is a regular expression the HTTP body or the command output must match
+	Expect string `json:"expect,omitempty"`
+	// ExpectImage requires the command output to contain the version of the image of the device,
+	// e.g. 7.3.2 for xrv9k-fullk9-x-7.3.2.iso
+	ExpectImage bool `json:"expectImage,omitempty"`
+	// Timeout in seconds, 10 by default
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// TestRun is the result of a suite run on a device
+type TestRun struct {
+	ID       string       `json:"id" bson:"_id"`
+	Hostname string       `json:"hostname"`
+	Serial   string       `json:"serial"`
+	Suite    string       `json:"suite"`
+	Trigger  string       `json:"trigger"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Passed   bool         `json:"passed"`
+	Results  []TestResult `json:"results"`
+}
+
+// TestResult is the result of a test of a run
+type TestResult struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Passed bool   `json:"passed"`
+	// Detail is the error of a failed test, or a short description of the success
+	Detail string `json:"detail"`
+	// Duration in milliseconds
+	Duration int64 `json:"duration"`
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// Roles that can be given to users and API tokens
+const (
+	AdminRole    = "admin"
+	OperatorRole = "operator"
+	ReadOnlyRole = "readonly"
+)
+
+// User is a local account of the dashboard. The password is only received, the hash is stored
+type User struct {
+	Username     string `json:"username"`
+	Password     string `json:"password,omitempty" bson:"-"`
+	PasswordHash string `json:"-"`
+	Role         string `json:"role"`
+}
+
+// APIToken is a bearer token used by automation. Only a hash of the token is stored, the token
+// itself is returned once when it is created
+type APIToken struct {
+	Name      string    `json:"name"`
+	Token     string    `json:"token,omitempty" bson:"-"`
+	TokenHash string    `json:"-"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+}
+
+// Session is a web UI login, identified by the cookie sent to the browser
+type Session struct {
+	ID       string
+	Username string
+	Expires  time.Time
+}
+
+// Principal is the user or token that sent a request
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Token tells if the request was authenticated with an API token
+	Token bool `json:"token"`
+}
+
+// ValidRole tells if the role is one of the known roles
+func ValidRole(role string) bool {
+	return role == AdminRole || role == OperatorRole || role == ReadOnlyRole
+}
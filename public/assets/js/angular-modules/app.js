@@ -111,6 +111,20 @@ appModule.config(['$interpolateProvider', function ($interpolateProvider) {
     $interpolateProvider.endSymbol('a}');
 }]);
 
+// Send the browser to the login page when the session expires
+appModule.config(['$httpProvider', function ($httpProvider) {
+    $httpProvider.interceptors.push(function ($q, $window) {
+        return {
+            responseError: function (response) {
+                if (response.status === 401) {
+                    $window.location.href = '/web/login';
+                }
+                return $q.reject(response);
+            }
+        };
+    });
+}]);
+
 /* Factories */
 
 // The notify factory allows services to notify to an specific controller when they finish operations
@@ -162,6 +176,9 @@ appModule.controller('AppController', function ($scope, $location, $http) {
     $scope.settings = {};
     $scope.settingsLoading = false;
 
+    // User variables
+    $scope.currentUser = {};
+
     // Common functions
     $scope.clearError = function () {
         $scope.error = "";
@@ -173,6 +190,19 @@ appModule.controller('AppController', function ($scope, $location, $http) {
         $location.path(path);
     };
 
+    // Get the logged in user
+    $scope.getCurrentUser = function () {
+        $http
+            .get('/api/users/me')
+            .then(function (response, status, headers, config) {
+                $scope.currentUser = response.data;
+            })
+            .catch(function (response, status, headers, config) {
+                $scope.error = response.data
+            })
+    };
+    $scope.getCurrentUser()
+
     // Configurations
 
     // Get all the configs in the database
@@ -257,6 +287,18 @@ appModule.controller('AppController', function ($scope, $location, $http) {
     };
     $scope.getDeviceTypes()
 
+    $scope.getScopes = function () {
+        $http
+            .get('/api/scopes')
+            .then(function (response, status, headers, config) {
+                $scope.scopes = response.data;
+            })
+            .catch(function (response, status, headers, config) {
+                $scope.error = response.data
+            })
+    };
+    $scope.getScopes()
+
     $scope.getDevices = function () {
         $scope.devicesLoading = true;
         $http
@@ -272,14 +314,38 @@ appModule.controller('AppController', function ($scope, $location, $http) {
             })
     };
     $scope.getDevices();
-    // Refresh devices each 10 seconds. TODO: This should be done with websockets 
-    setInterval(function(){ $scope.getDevices(); }, 10000);
+    // Follow the live events of the server. Browsers without EventSource refresh devices each 10 seconds
+    if (window.EventSource) {
+        var eventSource = new EventSource('/api/events');
+        eventSource.onmessage = function (message) {
+            var event = JSON.parse(message.data);
+            $scope.$apply(function () {
+                if (event.type === 'device.status') {
+                    angular.forEach($scope.devices, function (device) {
+                        if (device.hostname === event.hostname) {
+                            device.status = event.status;
+                            device.serial = event.serial;
+                        }
+                    });
+                } else if (event.type.indexOf('device.') === 0) {
+                    $scope.getDevices();
+                } else if (event.type.indexOf('config.') === 0) {
+                    $scope.getConfigs();
+                } else if (event.type.indexOf('image.') === 0) {
+                    $scope.getImages();
+                }
+            });
+        };
+    } else {
+        setInterval(function(){ $scope.getDevices(); }, 10000);
+    }
 
     $scope.submitDevice = function () {
         $scope.clearError();
         $scope.clearSuccess();
 
-        if (!($scope.currentDevice.hostname && $scope.currentDevice.serial && $scope.currentDevice.fixedIp && $scope.currentDevice.deviceType && $scope.currentDevice.image && $scope.currentDevice.config)) {
+        var hasPortBinding = $scope.currentDevice.relayCircuitId || $scope.currentDevice.relayRemoteId || $scope.currentDevice.interfaceId;
+        if (!($scope.currentDevice.hostname && ($scope.currentDevice.serial || hasPortBinding) && $scope.currentDevice.deviceType && $scope.currentDevice.image && $scope.currentDevice.config)) {
             $scope.error = "Please complete all fields";
             return;
         }
@@ -326,8 +392,10 @@ appModule.controller('AppController', function ($scope, $location, $http) {
         $scope.clearError();
         $scope.clearSuccess();
 
+        // Devices bound to a switch port might not have a serial yet
+        var deleteQuery = $scope.currentDevice.serial ? 'serial=' + $scope.currentDevice.serial : 'hostname=' + $scope.currentDevice.hostname;
         $http
-        .delete('/api/devices?serial=' + $scope.currentDevice.serial)
+        .delete('/api/devices?' + deleteQuery)
         .then(function (response, status, headers, config) {
             $scope.success = "Device removed"
             $scope.getDevices();
@@ -425,16 +493,89 @@ appModule.controller('AppController', function ($scope, $location, $http) {
     };
     $scope.getSettings();
 
+    $scope.getNotificationTypes = function () {
+        $http
+            .get('/api/settings/notifications')
+            .then(function (response, status, headers, config) {
+                $scope.notificationTypes = response.data;
+            })
+            .catch(function (response, status, headers, config) {
+                $scope.error = response.data
+            });
+    };
+    $scope.getNotificationTypes();
+
+    // Channels without events receive every notification. Events can also be type prefixes
+    $scope.channelReceives = function (channel, type) {
+        if (!channel.events || channel.events.length == 0) {
+            return true;
+        }
+        return channel.events.some(function (event) {
+            return type == event || type.indexOf(event + '.') == 0;
+        });
+    };
+
+    $scope.toggleChannelEvent = function (channel, type) {
+        var selected = $scope.notificationTypes
+            .map(function (notificationType) { return notificationType.type; })
+            .filter(function (t) { return $scope.channelReceives(channel, t); });
+        if (selected.indexOf(type) >= 0) {
+            selected.splice(selected.indexOf(type), 1);
+        } else {
+            selected.push(type);
+        }
+        channel.events = selected;
+    };
+
+    $scope.addNotificationChannel = function () {
+        if (!$scope.settings.notificationChannels) {
+            $scope.settings.notificationChannels = [];
+        }
+        $scope.settings.notificationChannels.push({ type: 'webex', enabled: true, events: [] });
+    };
+
+    $scope.removeNotificationChannel = function (index) {
+        $scope.settings.notificationChannels.splice(index, 1);
+    };
+
+    $scope.addAlertSink = function () {
+        if (!$scope.settings.alertSinks) {
+            $scope.settings.alertSinks = [];
+        }
+        $scope.settings.alertSinks.push({ type: 'alertmanager', enabled: true });
+    };
+
+    $scope.removeAlertSink = function (index) {
+        $scope.settings.alertSinks.splice(index, 1);
+    };
+
+    $scope.addProvisioningTimeout = function () {
+        if (!$scope.settings.provisioningTimeouts) {
+            $scope.settings.provisioningTimeouts = [];
+        }
+        $scope.settings.provisioningTimeouts.push({ status: 'Installing image', minutes: 45 });
+    };
+
+    $scope.removeProvisioningTimeout = function (index) {
+        $scope.settings.provisioningTimeouts.splice(index, 1);
+    };
+
     $scope.submitSettings = function () {
         $scope.clearError();
         $scope.clearSuccess();
         $scope.settingsLoading = true;
 
+        // Only the templates changed from the default are saved
+        $scope.settings.notificationTemplates = ($scope.notificationTypes || [])
+            .filter(function (notificationType) { return notificationType.template != notificationType.defaultTemplate; })
+            .map(function (notificationType) { return { type: notificationType.type, template: notificationType.template }; });
+
         $http
         .post('/api/settings', $scope.settings)
         .then(function (response, status, headers, config) {
             $scope.success = "Settings updated"
             $scope.getSettings();
+            $scope.getNotificationTypes();
         })
         .catch(function (response, status, headers, config) {
             $scope.error = response.data;
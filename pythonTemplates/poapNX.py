@@ -1718,6 +1718,27 @@ def cleanup_temp_images():
         remove_file(midway_system)
 
 
+def report_serial():
+    """
+    Reports the serial number to the dashboard on first contact, so devices registered only by
+    their switch port get it recorded. Failures are logged and do not stop the provisioning
+    """
+    if 'POAP_SERIAL' not in os.environ:
+        return
+    url = "{{.ServerURL}}/api/devices/provisioned?status=started&serial=%s" % os.environ['POAP_SERIAL']
+    try:
+        try:
+            import urllib2 as request
+        except ImportError:
+            import urllib.request as request
+        req = request.Request(url)
+        req.get_method = lambda: "PUT"
+        request.urlopen(req, timeout=10)
+        poap_log("Reported serial number %s" % os.environ['POAP_SERIAL'])
+    except Exception as e:
+        poap_log("WARN: Failed to report serial number: %s" % str(e))
+
+
 def main():
     signal.signal(signal.SIGTERM, sigterm_handler)
 
@@ -1727,6 +1748,9 @@ def main():
     # Configure the logging for the POAP process
     setup_logging()
 
+    # Report the serial number before anything is downloaded
+    report_serial()
+
     # Initialize parameters based on the mode
     setup_mode()
 
@@ -26,6 +26,12 @@ dns_config
 
 config_url="{{.ServerURL}}{{.ConfigURL}}"
 
+# The self signed certificate of the dashboard is trusted and pinned
+curl_tls=
+{{if .CACert}}ca_file="/tmp/ztp-dashboard.crt"
+echo "{{.CACert}}" > "${ca_file}"
+curl_tls="--cacert ${ca_file} --pinnedpubkey {{.PinnedPubKey}}"
+{{end}}
 
 function configure_crypto() {
 	# don't regenerate if we already have a host key
@@ -39,24 +45,33 @@ configure_crypto
 
 config_file="${ZTP_DIR}/customer/ztp.config"
 
+# Report the serial on first contact, so devices bound to a switch port get it recorded
+serial=$(xrcmd "show inventory chassis" | awk '/SN:/ {print $NF; exit}')
+curl ${curl_tls} --silent --connect-timeout 10 -X PUT "{{.ServerURL}}/api/devices/provisioned?status=started&serial=${serial}"
+
+# Report a provisioning failure to the dashboard and stop
+function report_failure() {
+	ztp_console_log "$1"
+	curl ${curl_tls} --silent --connect-timeout 10 -G -X PUT \
+		--data-urlencode "status=failed" --data-urlencode "detail=$1" "{{.ServerURL}}/api/devices/provisioned"
+	ztp_hook_error_exit "$1"
+}
+
 ztp_console_log "CONFIG: Getting XR config from ${config_url}/..."
 
 rc=
 
 rm -f "${config_file}"
-curl --silent --connect-timeout 10 --retry 5 \
+curl ${curl_tls} --silent --connect-timeout 10 --retry 5 \
 	--fail --location --output "${config_file}" "${config_url}"
 rc="$?"
 
 if [ "${rc}" -ne 0 ]; then
-	ztp_console_log "Failed to get config from ${config_url}: curl exit status: ${rc}"
-	ztp_hook_error_exit "$*"
-	break
+	report_failure "Failed to get config from ${config_url}: curl exit status: ${rc}"
 fi
 
 if [ ! -f "${config_file}" ]; then
-	ztp_console_log "Failed to get config from ${config_urls}: file not found"
-	ztp_hook_error_exit "$*"
+	report_failure "Failed to get config from ${config_url}: file not found"
 fi
 
 xr_apply_config() {
@@ -72,5 +87,5 @@ ztp_console_log "CONFIG: XR configuration loaded from ZTP"
 
 ztp_console_log "INFO: Zero Touch Provisioning completed"
 
-# Notify that device is read
-curl -X PUT {{.ServerURL}}/api/devices/provisioned
\ No newline at end of file
+# Notify that device is ready
+curl ${curl_tls} -X PUT "{{.ServerURL}}/api/devices/provisioned?serial=${serial}"
\ No newline at end of file